@@ -0,0 +1,171 @@
+package fakeserver
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/* How long tokens from the token endpoint are valid unless set */
+const defaultTokenLifetime = time.Hour
+
+/*Auth is the set of credentials the fakeserver requires on /api/
+  requests. Every mode that is set must be satisfied. A request that
+  fails one gets a 401 with a WWW-Authenticate header for that mode.
+  Bearer (and token endpoint) modes and basic credentials both use the
+  Authorization header, so cannot be combined*/
+type Auth struct {
+	/* Requires Authorization: Bearer with this token */
+	BearerToken string
+
+	/* Requires basic credentials */
+	Username string
+	Password string
+
+	/* Requires the API key in the named header, such as X-API-Key */
+	APIKeyHeader string
+	APIKey       string
+
+	/* Turns /oauth/token into a client credentials token endpoint for
+	   this client. The tokens it issues are accepted as bearer tokens */
+	ClientID      string
+	ClientSecret  string
+	TokenLifetime time.Duration
+
+	/* Requires the named header to hold the hex encoded HMAC-SHA256
+	   of the request body, keyed with the secret */
+	HMACHeader string
+	HMACSecret string
+}
+
+/*SetAuth makes the server require the given credentials. Tokens issued
+  under any previous setting are forgotten. An empty Auth accepts anything*/
+func (svr *Fakeserver) SetAuth(auth Auth) {
+	svr.mutex.Lock()
+	defer svr.mutex.Unlock()
+	if auth.TokenLifetime == 0 {
+		auth.TokenLifetime = defaultTokenLifetime
+	}
+	svr.auth = auth
+	svr.tokens = make(map[string]time.Time)
+}
+
+/*RevokeTokens makes the tokens issued so far invalid, as if they expired early*/
+func (svr *Fakeserver) RevokeTokens() {
+	svr.mutex.Lock()
+	defer svr.mutex.Unlock()
+	for token := range svr.tokens {
+		svr.tokens[token] = time.Time{}
+	}
+}
+
+/*TokensIssued returns how many tokens the token endpoint has handed out*/
+func (svr *Fakeserver) TokensIssued() int {
+	svr.mutex.Lock()
+	defer svr.mutex.Unlock()
+	return len(svr.tokens)
+}
+
+/* Returns the WWW-Authenticate challenge for a request that does
+   not satisfy the configured auth, or "" if it does */
+func (svr *Fakeserver) checkAuth(r *http.Request, body []byte) string {
+	svr.mutex.Lock()
+	defer svr.mutex.Unlock()
+	auth := svr.auth
+
+	if auth.BearerToken != "" || auth.ClientID != "" {
+		header := r.Header.Get("Authorization")
+		token := strings.TrimPrefix(header, "Bearer ")
+		expiry, issued := svr.tokens[token]
+		switch {
+		case !strings.HasPrefix(header, "Bearer "):
+			return `Bearer realm="fakeserver"`
+		case auth.BearerToken != "" && token == auth.BearerToken:
+		case issued && time.Now().Before(expiry):
+		case issued:
+			return `Bearer realm="fakeserver", error="invalid_token", error_description="The access token expired"`
+		default:
+			return `Bearer realm="fakeserver", error="invalid_token"`
+		}
+	}
+
+	if auth.Username != "" || auth.Password != "" {
+		username, password, ok := r.BasicAuth()
+		if !ok || username != auth.Username || password != auth.Password {
+			return `Basic realm="fakeserver"`
+		}
+	}
+
+	if auth.APIKeyHeader != "" && r.Header.Get(auth.APIKeyHeader) != auth.APIKey {
+		return fmt.Sprintf(`APIKey realm="fakeserver", header="%s"`, auth.APIKeyHeader)
+	}
+
+	if auth.HMACHeader != "" {
+		mac := hmac.New(sha256.New, []byte(auth.HMACSecret))
+		mac.Write(body)
+		signature, err := hex.DecodeString(r.Header.Get(auth.HMACHeader))
+		if err != nil || !hmac.Equal(signature, mac.Sum(nil)) {
+			return fmt.Sprintf(`HMAC-SHA256 realm="fakeserver", header="%s"`, auth.HMACHeader)
+		}
+	}
+
+	return ""
+}
+
+/* A minimal client credentials token endpoint. The client may
+   authenticate with basic credentials or in the form */
+func (svr *Fakeserver) handleToken(w http.ResponseWriter, r *http.Request) {
+	svr.mutex.Lock()
+	auth := svr.auth
+	svr.mutex.Unlock()
+
+	if auth.ClientID == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.ParseForm()
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.PostForm.Get("client_id")
+		clientSecret = r.PostForm.Get("client_secret")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if r.PostForm.Get("grant_type") != "client_credentials" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "unsupported_grant_type"}`))
+		return
+	}
+	if clientID != auth.ClientID || clientSecret != auth.ClientSecret {
+		w.Header().Set("WWW-Authenticate", `Basic realm="fakeserver"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error": "invalid_client"}`))
+		return
+	}
+
+	b := make([]byte, 16)
+	rand.Read(b)
+	token := hex.EncodeToString(b)
+
+	svr.mutex.Lock()
+	svr.tokens[token] = time.Now().Add(auth.TokenLifetime)
+	svr.mutex.Unlock()
+
+	resp, _ := json.Marshal(map[string]interface{}{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   int(auth.TokenLifetime.Seconds()),
+	})
+	w.Write(resp)
+}
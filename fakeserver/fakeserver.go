@@ -1,13 +1,18 @@
 package fakeserver
 
 import (
+	"bytes"
+	"crypto/sha1"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -17,6 +22,22 @@ type Fakeserver struct {
 	objects map[string]map[string]interface{}
 	debug   bool
 	running bool
+	delay   time.Duration
+	perPage int
+
+	mutex    sync.Mutex
+	requests []Request
+	rules    []*Rule
+	auth     Auth
+	tokens   map[string]time.Time
+}
+
+/*Request is a record of a request the fakeserver received*/
+type Request struct {
+	Method string
+	URL    string
+	Header http.Header
+	Body   string
 }
 
 /*NewFakeServer creates a HTTP server used for tests and debugging*/
@@ -27,6 +48,7 @@ func NewFakeServer(iPort int, iObjects map[string]map[string]interface{}, iStart
 		debug:   iDebug,
 		objects: iObjects,
 		running: false,
+		tokens:  make(map[string]time.Time),
 	}
 
 	//If we were passed an argument for where to serve /static from...
@@ -43,6 +65,7 @@ func NewFakeServer(iPort int, iObjects map[string]map[string]interface{}, iStart
 	}
 
 	serverMux.HandleFunc("/api/", svr.handleAPIObject)
+	serverMux.HandleFunc("/oauth/token", svr.handleToken)
 
 	apiObjectServer := &http.Server{
 		Addr:    fmt.Sprintf("127.0.0.1:%d", iPort),
@@ -81,6 +104,44 @@ func (svr *Fakeserver) Running() bool {
 	return svr.running
 }
 
+/*SetDelay makes the server wait before answering each API request*/
+func (svr *Fakeserver) SetDelay(delay time.Duration) {
+	svr.mutex.Lock()
+	defer svr.mutex.Unlock()
+	svr.delay = delay
+}
+
+/*SetPageSize makes the list endpoints return at most perPage objects at a time.
+  /api/objects points to the next page with a Link header, /api/object_list
+  with an X-Next-Page header and /api/object_pages with the next key of an
+  envelope as well as a Link header. 0 turns pagination off, though each
+  request can still ask for pages with the per_page query parameter*/
+func (svr *Fakeserver) SetPageSize(perPage int) {
+	svr.mutex.Lock()
+	defer svr.mutex.Unlock()
+	svr.perPage = perPage
+}
+
+/*Requests returns the requests received to /api/ so far, oldest first*/
+func (svr *Fakeserver) Requests() []Request {
+	svr.mutex.Lock()
+	defer svr.mutex.Unlock()
+	return append([]Request(nil), svr.requests...)
+}
+
+/*FailNext makes the next times requests with the given method to path
+  (without the query string) fail with code instead of being handled.
+  It is a shorthand for AddRule*/
+func (svr *Fakeserver) FailNext(method string, path string, code int, times int) {
+	svr.AddRule(&Rule{Method: method, Path: path, Status: code, Times: times})
+}
+
+/*Handler returns the handler for the server's routes, so the fakeserver
+  can be run with httptest on a free port rather than a fixed one*/
+func (svr *Fakeserver) Handler() http.Handler {
+	return svr.server.Handler
+}
+
 /*GetServer returns the server object itself*/
 func (svr *Fakeserver) GetServer() *http.Server {
 	return svr.server
@@ -94,6 +155,33 @@ func (svr *Fakeserver) handleAPIObject(w http.ResponseWriter, r *http.Request) {
 	/* Assume this will never fail */
 	b, _ := ioutil.ReadAll(r.Body)
 
+	svr.mutex.Lock()
+	svr.requests = append(svr.requests, Request{Method: r.Method, URL: r.URL.RequestURI(), Header: r.Header.Clone(), Body: string(b)})
+	delay := svr.delay
+	svr.mutex.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+
+	if svr.applyRules(w, r) {
+		return
+	}
+
+	if challenge := svr.checkAuth(r, b); challenge != "" {
+		if svr.debug {
+			log.Printf("fakeserver.go: Rejecting %s %s: %s", r.Method, r.URL.Path, challenge)
+		}
+		w.Header().Set("WWW-Authenticate", challenge)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	/* Requests are handled one at a time from here, so
+	   concurrent clients see a consistent set of objects */
+	svr.mutex.Lock()
+	defer svr.mutex.Unlock()
+
 	if svr.debug {
 		log.Printf("fakeserver.go: Recieved request: %+v\n", r)
 		log.Printf("fakeserver.go: Headers:\n")
@@ -136,15 +224,31 @@ func (svr *Fakeserver) handleAPIObject(w http.ResponseWriter, r *http.Request) {
 	} else if path == "/api/object_list" && r.Method == "GET" {
 		/* Provide a URL similar to /api/objects that will also show the number of results
 		   as if a search was performed (which just returns all objects */
-		tmp := make([]map[string]interface{}, 0)
+		tmp, page, pages := svr.listPage(r)
 		result := map[string]interface{}{
 			"results": true,
-			"pages":   1,
-			"page":    1,
+			"pages":   pages,
+			"page":    page,
 			"list":    &tmp,
 		}
-		for _, hash := range svr.objects {
-			tmp = append(tmp, hash)
+		if page < pages {
+			w.Header().Set("X-Next-Page", strconv.Itoa(page+1))
+		}
+		b, _ := json.Marshal(result)
+		w.Write(b)
+		return
+	} else if path == "/api/object_pages" && r.Method == "GET" {
+		/* The same objects in an envelope that gives the URL of the next
+		   page, which is also given in a Link header */
+		tmp, page, pages := svr.listPage(r)
+		result := map[string]interface{}{
+			"results": &tmp,
+			"next":    nil,
+		}
+		if page < pages {
+			next := pageURL(r, page+1)
+			result["next"] = next
+			w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", next))
 		}
 		b, _ := json.Marshal(result)
 		w.Write(b)
@@ -157,9 +261,9 @@ func (svr *Fakeserver) handleAPIObject(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	} else if path == "/api/objects" && r.Method == "GET" {
-		result := make([]map[string]interface{}, 0)
-		for _, hash := range svr.objects {
-			result = append(result, hash)
+		result, page, pages := svr.listPage(r)
+		if page < pages {
+			w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\", <%s>; rel=\"last\"", pageURL(r, page+1), pageURL(r, pages)))
 		}
 		b, _ := json.Marshal(result)
 		w.Write(b)
@@ -180,7 +284,13 @@ func (svr *Fakeserver) handleAPIObject(w http.ResponseWriter, r *http.Request) {
 			log.Printf("fakeserver.go: data sent - unmarshalling from JSON: %s\n", string(b))
 		}
 
-		err := json.Unmarshal(b, &obj)
+		/* Keep numbers exactly as they were sent. The data sent
+		   replaces what is stored rather than being merged into it */
+		stored := obj
+		obj = nil
+		decoder := json.NewDecoder(bytes.NewReader(b))
+		decoder.UseNumber()
+		err := decoder.Decode(&obj)
 		if err != nil {
 			/* Failure goes back to the user as a 500. Log data here for
 			   debugging (which shouldn't ever fail!) */
@@ -206,6 +316,18 @@ func (svr *Fakeserver) handleAPIObject(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
+		/* Objects with a revision must be updated by
+		   sending back the revision that is stored */
+		if rev, ok := stored["revision"]; ok && r.Method != "POST" {
+			if fmt.Sprintf("%v", obj["revision"]) != fmt.Sprintf("%v", rev) {
+				if svr.debug {
+					log.Printf("fakeserver.go: Conflict - revision %v was sent but %v is stored", obj["revision"], rev)
+				}
+				http.Error(w, fmt.Sprintf("revision must be %v", rev), http.StatusConflict)
+				return
+			}
+		}
+
 		/* Overwrite our stored test object */
 		if svr.debug {
 			log.Printf("fakeserver.go: Overwriting %s with new data:%+v\n", id, obj)
@@ -217,10 +339,75 @@ func (svr *Fakeserver) handleAPIObject(w http.ResponseWriter, r *http.Request) {
 		w.Write(b)
 		return
 	}
-	/* No data was sent... must be just a retrieval */
+	/* No data was sent... must be just a retrieval. Tag the
+	   object so clients can ask if it changed */
+	b, _ = json.Marshal(obj)
+	etag := fmt.Sprintf("\"%x\"", sha1.Sum(b))
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		if svr.debug {
+			log.Printf("fakeserver.go: Object not modified.\n")
+		}
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
 	if svr.debug {
 		log.Printf("fakeserver.go: Returning object.\n")
 	}
-	b, _ = json.Marshal(obj)
 	w.Write(b)
 }
+
+/* The objects on the page requested (all of them unless pagination
+   is on), along with the page number and count. The page size can be
+   set per request with per_page, and the objects narrowed down to those
+   with search_value at search_key */
+func (svr *Fakeserver) listPage(r *http.Request) ([]map[string]interface{}, int, int) {
+	query := r.URL.Query()
+	searchKey := query.Get("search_key")
+	searchValue := query.Get("search_value")
+
+	ids := make([]string, 0, len(svr.objects))
+	for id, obj := range svr.objects {
+		if searchKey != "" && fmt.Sprintf("%v", obj[searchKey]) != searchValue {
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	perPage := svr.perPage
+	if n, err := strconv.Atoi(query.Get("per_page")); err == nil && n > 0 {
+		perPage = n
+	}
+
+	if perPage <= 0 {
+		result := make([]map[string]interface{}, 0, len(ids))
+		for _, id := range ids {
+			result = append(result, svr.objects[id])
+		}
+		return result, 1, 1
+	}
+
+	/* Pages only make sense in a stable order */
+	sort.Strings(ids)
+	pages := (len(ids) + perPage - 1) / perPage
+	if pages == 0 {
+		pages = 1
+	}
+	page, err := strconv.Atoi(query.Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	result := make([]map[string]interface{}, 0, perPage)
+	for i := (page - 1) * perPage; i < len(ids) && i < page*perPage; i++ {
+		result = append(result, svr.objects[ids[i]])
+	}
+	return result, page, pages
+}
+
+/* The URL of another page of a list, keeping the rest of the query */
+func pageURL(r *http.Request, page int) string {
+	query := r.URL.Query()
+	query.Set("page", strconv.Itoa(page))
+	return fmt.Sprintf("%s?%s", r.URL.Path, query.Encode())
+}
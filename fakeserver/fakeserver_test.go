@@ -0,0 +1,80 @@
+package fakeserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+)
+
+func TestListPages(t *testing.T) {
+	objects := make(map[string]map[string]interface{})
+	for i := 1; i <= 7; i++ {
+		id := fmt.Sprintf("%d", i)
+		objects[id] = map[string]interface{}{"id": id, "size": "small"}
+		if i > 4 {
+			objects[id]["size"] = "large"
+		}
+	}
+	svr := NewFakeServer(0, objects, false, false, "")
+	httpSvr := httptest.NewServer(svr.Handler())
+	defer httpSvr.Close()
+
+	/* Follow the envelope's next key to the end, collecting the ids */
+	follow := func(path string) ([]string, int) {
+		var ids []string
+		pages := 0
+		for path != "" {
+			resp, err := http.Get(httpSvr.URL + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var page struct {
+				Results []map[string]interface{} `json:"results"`
+				Next    *string                  `json:"next"`
+			}
+			err = json.NewDecoder(resp.Body).Decode(&page)
+			resp.Body.Close()
+			if err != nil {
+				t.Fatalf("fakeserver_test.go: %s: %s", path, err)
+			}
+			if page.Next != nil && resp.Header.Get("Link") != fmt.Sprintf("<%s>; rel=\"next\"", *page.Next) {
+				t.Errorf("fakeserver_test.go: %s: the Link header '%s' does not match next '%s'", path, resp.Header.Get("Link"), *page.Next)
+			}
+			for _, obj := range page.Results {
+				ids = append(ids, obj["id"].(string))
+			}
+			path = ""
+			if page.Next != nil {
+				path = *page.Next
+			}
+			pages++
+		}
+		return ids, pages
+	}
+
+	cases := []struct {
+		path     string
+		perPage  int
+		expected string
+		pages    int
+	}{
+		{path: "/api/object_pages", expected: "[1 2 3 4 5 6 7]", pages: 1},
+		{path: "/api/object_pages", perPage: 3, expected: "[1 2 3 4 5 6 7]", pages: 3},
+		{path: "/api/object_pages?per_page=2", perPage: 3, expected: "[1 2 3 4 5 6 7]", pages: 4},
+		{path: "/api/object_pages?per_page=2&search_key=size&search_value=large", expected: "[5 6 7]", pages: 2},
+		{path: "/api/object_pages?search_key=size&search_value=medium", expected: "[]", pages: 1},
+	}
+
+	for _, c := range cases {
+		svr.SetPageSize(c.perPage)
+		ids, pages := follow(c.path)
+		/* Without pagination the order is not defined */
+		sort.Strings(ids)
+		if got := fmt.Sprintf("%v", ids); got != c.expected || pages != c.pages {
+			t.Errorf("fakeserver_test.go: %s with %d per page: expected %s over %d pages but got %s over %d", c.path, c.perPage, c.expected, c.pages, got, pages)
+		}
+	}
+}
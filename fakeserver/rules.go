@@ -0,0 +1,128 @@
+package fakeserver
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+/*Rule makes requests to a route misbehave. A request matches if its
+  method and path (without the query string) are those of the rule; an
+  empty Method or Path matches any. Every matching rule's latency is
+  applied, then the first matching rule with a fault answers the request
+  in place of the server. Requests are recorded whatever the rules do*/
+type Rule struct {
+	Method string
+	Path   string
+
+	/* Wait this long, plus a random amount up to Jitter, before answering */
+	Latency time.Duration
+	Jitter  time.Duration
+
+	/* The fault, if any. Only one of these should be set */
+	Status         int  /* answer with this status code */
+	RetryAfter     int  /* answer 429 with a Retry-After of this many seconds */
+	DropConnection bool /* send the headers and part of the body, then hang up */
+	MalformedJSON  bool /* answer 200 with a body that is not valid JSON */
+
+	/* Only apply to the first Times matching requests. 0 means every one */
+	Times int
+
+	matched int64
+}
+
+/*Matched returns how many requests the rule has been applied to*/
+func (rule *Rule) Matched() int {
+	return int(atomic.LoadInt64(&rule.matched))
+}
+
+func (rule *Rule) hasFault() bool {
+	return rule.Status != 0 || rule.RetryAfter != 0 || rule.DropConnection || rule.MalformedJSON
+}
+
+/*AddRule adds a rule after any already set, and returns it so its
+  Matched count can be checked*/
+func (svr *Fakeserver) AddRule(rule *Rule) *Rule {
+	svr.mutex.Lock()
+	defer svr.mutex.Unlock()
+	svr.rules = append(svr.rules, rule)
+	return rule
+}
+
+/*ResetRules removes all rules, so the server behaves again*/
+func (svr *Fakeserver) ResetRules() {
+	svr.mutex.Lock()
+	defer svr.mutex.Unlock()
+	svr.rules = nil
+}
+
+/* Pick the rules for this request, counting each against its Times */
+func (svr *Fakeserver) matchRules(r *http.Request) (latency time.Duration, fault *Rule) {
+	svr.mutex.Lock()
+	defer svr.mutex.Unlock()
+	for _, rule := range svr.rules {
+		if (rule.Method != "" && rule.Method != r.Method) || (rule.Path != "" && rule.Path != r.URL.Path) {
+			continue
+		}
+		if rule.Times > 0 && rule.Matched() >= rule.Times {
+			continue
+		}
+		if rule.hasFault() && fault != nil {
+			continue
+		}
+
+		atomic.AddInt64(&rule.matched, 1)
+		latency += rule.Latency
+		if rule.Jitter > 0 {
+			latency += time.Duration(rand.Int63n(int64(rule.Jitter)))
+		}
+		if rule.hasFault() {
+			fault = rule
+		}
+	}
+	return latency, fault
+}
+
+/* Apply the rules matching a request. Returns true if one of them answered it */
+func (svr *Fakeserver) applyRules(w http.ResponseWriter, r *http.Request) bool {
+	latency, fault := svr.matchRules(r)
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+	if fault == nil {
+		return false
+	}
+	if svr.debug {
+		log.Printf("fakeserver.go: Answering %s %s with an injected fault: %+v", r.Method, r.URL.Path, fault)
+	}
+
+	switch {
+	case fault.Status != 0:
+		http.Error(w, http.StatusText(fault.Status), fault.Status)
+	case fault.RetryAfter != 0:
+		w.Header().Set("Retry-After", strconv.Itoa(fault.RetryAfter))
+		http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+	case fault.MalformedJSON:
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": "1", "name": oops}`))
+	case fault.DropConnection:
+		hijacker, ok := w.(http.Hijacker)
+		if !ok {
+			http.Error(w, "fakeserver.go: Cannot drop the connection of this response", http.StatusInternalServerError)
+			return true
+		}
+		conn, buf, err := hijacker.Hijack()
+		if err != nil {
+			return true
+		}
+		body := `{"id": "1", "name": "the rest of this never arrives"}`
+		fmt.Fprintf(buf, "HTTP/1.1 200 OK\r\nContent-Type: application/json\r\nContent-Length: %d\r\n\r\n%s", len(body), body[:len(body)/2])
+		buf.Flush()
+		conn.Close()
+	}
+	return true
+}
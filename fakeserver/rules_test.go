@@ -0,0 +1,90 @@
+package fakeserver
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRules(t *testing.T) {
+	svr := NewFakeServer(0, map[string]map[string]interface{}{"1": {"id": "1"}}, false, false, "")
+	httpSvr := httptest.NewServer(svr.Handler())
+	defer httpSvr.Close()
+
+	do := func(method string, path string) (*http.Response, string, error) {
+		req, _ := http.NewRequest(method, httpSvr.URL+path, strings.NewReader(`{"id": "1"}`))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, "", err
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		return resp, string(b), err
+	}
+	expectStatus := func(method string, path string, code int) {
+		resp, body, err := do(method, path)
+		if err != nil {
+			t.Fatalf("rules_test.go: %s %s: %s", method, path, err)
+		}
+		if resp.StatusCode != code {
+			t.Errorf("rules_test.go: %s %s: expected %d but got %d: %s", method, path, code, resp.StatusCode, body)
+		}
+	}
+
+	/* Fail the first N, and only for the method and path given */
+	rule := svr.AddRule(&Rule{Method: "GET", Path: "/api/objects/1", Status: http.StatusBadGateway, Times: 2})
+	expectStatus("PUT", "/api/objects/1", http.StatusOK)
+	expectStatus("GET", "/api/objects", http.StatusOK)
+	expectStatus("GET", "/api/objects/1?x=1", http.StatusBadGateway)
+	expectStatus("GET", "/api/objects/1", http.StatusBadGateway)
+	expectStatus("GET", "/api/objects/1", http.StatusOK)
+	if rule.Matched() != 2 {
+		t.Errorf("rules_test.go: expected the rule to match 2 requests but it matched %d", rule.Matched())
+	}
+
+	/* Latency from every matching rule adds up, and the first fault wins */
+	latency := svr.AddRule(&Rule{Latency: 100 * time.Millisecond, Jitter: 50 * time.Millisecond})
+	svr.AddRule(&Rule{Path: "/api/objects/1", Latency: 100 * time.Millisecond})
+	svr.AddRule(&Rule{Path: "/api/objects/1", RetryAfter: 7})
+	unused := svr.AddRule(&Rule{Path: "/api/objects/1", Status: http.StatusTeapot})
+	start := time.Now()
+	resp, _, err := do("GET", "/api/objects/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("rules_test.go: expected about 200-250ms of latency but the request took %s", elapsed)
+	}
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") != "7" {
+		t.Errorf("rules_test.go: expected a 429 with Retry-After 7 but got %d '%s'", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+	if latency.Matched() != 1 || unused.Matched() != 0 {
+		t.Errorf("rules_test.go: expected 1 and 0 matches but got %d and %d", latency.Matched(), unused.Matched())
+	}
+
+	/* Rules are gone once reset */
+	svr.ResetRules()
+	expectStatus("GET", "/api/objects/1", http.StatusOK)
+
+	svr.AddRule(&Rule{MalformedJSON: true, Times: 1})
+	resp, body, err := do("GET", "/api/objects/1")
+	var obj map[string]interface{}
+	if err != nil || resp.StatusCode != http.StatusOK || json.Unmarshal([]byte(body), &obj) == nil {
+		t.Errorf("rules_test.go: expected a 200 with malformed JSON but got %v '%s'", err, body)
+	}
+
+	svr.AddRule(&Rule{DropConnection: true, Times: 1})
+	if _, body, err := do("GET", "/api/objects/1"); err == nil {
+		t.Errorf("rules_test.go: expected the connection to be dropped mid-body but read '%s'", body)
+	}
+	expectStatus("GET", "/api/objects/1", http.StatusOK)
+
+	/* Requests are recorded whatever the rules did */
+	if got := len(svr.Requests()); got != 10 {
+		t.Errorf("rules_test.go: expected 10 requests to be recorded but got %d", got)
+	}
+}
@@ -1,15 +1,72 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
 	"github.com/Mastercard/terraform-provider-restapi/restapi"
-	"github.com/hashicorp/terraform/plugin"
-	"github.com/hashicorp/terraform/terraform"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov6/tf6server"
+)
+
+/* Set by goreleaser */
+var (
+	version = "dev"
+	commit  = ""
 )
 
+/* The command line flags. With none, the provider is served to terraform as usual */
+type options struct {
+	debug       bool
+	showVersion bool
+}
+
+func parseFlags(args []string) (*options, error) {
+	opts := &options{}
+	flags := flag.NewFlagSet("terraform-provider-restapi", flag.ContinueOnError)
+	flags.BoolVar(&opts.debug, "debug", false, "Start the provider in a mode for use with a debugger such as delve. The TF_REATTACH_PROVIDERS value to give terraform is printed")
+	flags.BoolVar(&opts.showVersion, "version", false, "Print the version of the provider and exit")
+	return opts, flags.Parse(args)
+}
+
+func (opts *options) serveOpts() []tf6server.ServeOpt {
+	var serveOpts []tf6server.ServeOpt
+	if opts.debug {
+		serveOpts = append(serveOpts, tf6server.WithManagedDebug())
+	}
+	return serveOpts
+}
+
+func versionString() string {
+	if commit == "" {
+		return fmt.Sprintf("terraform-provider-restapi %s", version)
+	}
+	return fmt.Sprintf("terraform-provider-restapi %s (%s)", version, commit)
+}
+
 func main() {
-	plugin.Serve(&plugin.ServeOpts{
-		ProviderFunc: func() terraform.ResourceProvider {
-			return restapi.Provider()
-		},
-	})
+	opts, err := parseFlags(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		os.Exit(2)
+	}
+
+	if opts.showVersion {
+		fmt.Println(versionString())
+		return
+	}
+
+	serverFactory, err := restapi.ProviderServerFactory(context.Background(), version, commit)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := tf6server.Serve("registry.terraform.io/Mastercard/restapi", serverFactory, opts.serveOpts()...); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestParseFlags(t *testing.T) {
+	cases := []struct {
+		args        []string
+		debug       bool
+		showVersion bool
+		serveOpts   int
+	}{
+		/* What terraform runs the provider with */
+		{args: nil, serveOpts: 0},
+		{args: []string{"-debug"}, debug: true, serveOpts: 1},
+		{args: []string{"-version"}, showVersion: true, serveOpts: 0},
+	}
+
+	for _, c := range cases {
+		opts, err := parseFlags(c.args)
+		if err != nil {
+			t.Fatalf("main_test.go: %v: %s", c.args, err)
+		}
+		if opts.debug != c.debug || opts.showVersion != c.showVersion {
+			t.Errorf("main_test.go: %v: expected debug %t and version %t but got %+v", c.args, c.debug, c.showVersion, opts)
+		}
+		if got := len(opts.serveOpts()); got != c.serveOpts {
+			t.Errorf("main_test.go: %v: expected %d serve options but got %d", c.args, c.serveOpts, got)
+		}
+	}
+
+	if _, err := parseFlags([]string{"-unknown"}); err == nil {
+		t.Errorf("main_test.go: expected an unknown flag to be an error")
+	}
+}
+
+func TestVersionString(t *testing.T) {
+	defer func(v string, c string) { version, commit = v, c }(version, commit)
+
+	if got := versionString(); got != "terraform-provider-restapi dev" {
+		t.Errorf("main_test.go: unexpected version '%s' for a development build", got)
+	}
+	version, commit = "1.2.3", "abc123"
+	if got := versionString(); got != "terraform-provider-restapi 1.2.3 (abc123)" {
+		t.Errorf("main_test.go: unexpected version '%s' for a release", got)
+	}
+}
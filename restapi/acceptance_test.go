@@ -0,0 +1,82 @@
+package restapi
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/Mastercard/terraform-provider-restapi/fakeserver"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+/* Acceptance tests (those run with resource.Test, which only happens
+   when TF_ACC is set) share one fakeserver for the whole package */
+const testAccServerPort = 8097
+
+var testAccServerObjects = make(map[string]map[string]interface{})
+
+/* resource.TestMain also runs the sweepers when given -sweep */
+func TestMain(m *testing.M) {
+	if os.Getenv("TF_ACC") != "" {
+		fakeserver.NewFakeServer(testAccServerPort, testAccServerObjects, true, false, "")
+	}
+	resource.TestMain(m)
+}
+
+/* A provider block pointing at the shared fakeserver, to
+   start the configuration of each acceptance test with */
+func testAccProviderConfig() string {
+	return fmt.Sprintf(`
+provider "restapi" {
+  uri = "http://127.0.0.1:%d/"
+}
+`, testAccServerPort)
+}
+
+/* Checks the objects at the given ids are gone from the shared fakeserver */
+func testAccCheckObjectsDestroyed(ids ...string) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+		for _, id := range ids {
+			if _, ok := testAccServerObjects[id]; ok {
+				return fmt.Errorf("acceptance_test.go: Object '%s' is still on the server", id)
+			}
+		}
+		return nil
+	}
+}
+
+func TestAccRestApiObject_Lifecycle(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		CheckDestroy:             testAccCheckObjectsDestroyed("lifecycle"),
+		Steps: []resource.TestStep{
+			{
+				Config: testAccProviderConfig() + generateTestResource("Foo", `{ "id": "lifecycle", "name": "tftest-foo" }`, make(map[string]interface{})),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("restapi_object.Foo", "id", "lifecycle"),
+					resource.TestCheckResourceAttr("restapi_object.Foo", "api_data.name", "tftest-foo"),
+					func(s *terraform.State) error {
+						if testAccServerObjects["lifecycle"]["name"] != "tftest-foo" {
+							return fmt.Errorf("acceptance_test.go: Expected the object to be created on the server but found %v", testAccServerObjects["lifecycle"])
+						}
+						return nil
+					},
+				),
+			},
+			{
+				Config: testAccProviderConfig() + generateTestResource("Foo", `{ "id": "lifecycle", "name": "tftest-bar" }`, make(map[string]interface{})),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("restapi_object.Foo", "id", "lifecycle"),
+					resource.TestCheckResourceAttr("restapi_object.Foo", "api_data.name", "tftest-bar"),
+					func(s *terraform.State) error {
+						if testAccServerObjects["lifecycle"]["name"] != "tftest-bar" {
+							return fmt.Errorf("acceptance_test.go: Expected the object to be updated on the server but found %v", testAccServerObjects["lifecycle"])
+						}
+						return nil
+					},
+				),
+			},
+		},
+	})
+}
@@ -1,24 +1,35 @@
 package restapi
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"math"
+	"mime"
 	"net/http"
 	"net/http/cookiejar"
+	"net/http/httptrace"
 	"net/url"
 	"strings"
 	"time"
 
+	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/clientcredentials"
 	"golang.org/x/time/rate"
 )
 
+/* Responses larger than this are refused unless overridden */
+const defaultMaxResponseBytes = 32 * 1024 * 1024
+
+/* Sent on any request with a body unless overridden */
+const defaultContentType = "application/json"
+
 type apiClientOpt struct {
 	uri                 string
 	insecure            bool
@@ -42,12 +53,36 @@ type apiClientOpt struct {
 	oauthScopes         []string
 	oauthTokenURL       string
 	oauthEndpointParams url.Values
+	oauthExpirySkew     int
 	certFile            string
 	keyFile             string
+	errorBodyLength     int
+	circuitThreshold    int
+	circuitWindow       int
+	circuitCooldown     int
+	maxResponseBytes    int64
+	enableHTTPCache     bool
+	enableReadCache     bool
+	verboseErrors       bool
+	verboseErrorLines   int
+	readFailureMode     string
+	slowThreshold       int
+	contentType         string
+	accept              string
+	maxPages            int
+	hostOverrides       map[string]string
+	userAgent           string
+	transport           http.RoundTripper
 	debug               bool
 }
 
-/*APIClient is a HTTP client with additional controlling fields*/
+/*APIClient is a HTTP client with additional controlling fields. One
+  client is shared by every operation terraform runs in parallel, so it
+  is safe for concurrent use: its fields are only set when it is built,
+  and the state it keeps between requests (metrics, caches, the circuit
+  breaker and the oauth token) guards itself. Anything about a single
+  request belongs in its requestConfig. TestAPIClientConcurrent exercises
+  all of this and should be extended whenever more such state is added*/
 type APIClient struct {
 	httpClient          *http.Client
 	uri                 string
@@ -65,8 +100,104 @@ type APIClient struct {
 	createReturnsObject bool
 	xssiPrefix          string
 	rateLimiter         *rate.Limiter
+	errorBodyLength     int
 	debug               bool
 	oauthConfig         *clientcredentials.Config
+	oauthHTTPClient     *http.Client
+	oauthTokenSource    *skewTokenSource
+	metrics             *apiMetrics
+	circuitBreaker      *circuitBreaker
+	maxResponseBytes    int64
+	etagCache           *etagCache
+	readCache           *readCache
+	verboseErrors       bool
+	verboseErrorLines   int
+	readFailureMode     string
+	slowThreshold       time.Duration
+	timeout             time.Duration
+	contentType         string
+	accept              string
+	maxPages            int
+	userAgent           string
+}
+
+/*requestConfig holds settings that apply to a single request */
+type requestConfig struct {
+	ctx              context.Context
+	maxResponseBytes int64
+	timeout          time.Duration
+	tokenRetried     bool
+	cachedRead       bool
+	stream           func(io.Reader) error
+}
+
+/*requestOption adjusts the settings of a single request */
+type requestOption func(*requestConfig)
+
+/* Allow a single request to read a larger (or smaller) response
+   than the client-wide max_response_bytes */
+func withMaxResponseBytes(n int64) requestOption {
+	return func(c *requestConfig) {
+		if n > 0 {
+			c.maxResponseBytes = n
+		}
+	}
+}
+
+/* Give a single request longer (or less time) to complete than
+   the provider's timeout. 0 leaves the provider's timeout in place */
+func withTimeout(timeout time.Duration) requestOption {
+	return func(c *requestConfig) {
+		if timeout > 0 {
+			c.timeout = timeout
+		}
+	}
+}
+
+/* Tie a request to the context of the terraform operation it is
+   part of, so it is abandoned if terraform is interrupted */
+func withContext(ctx context.Context) requestOption {
+	return func(c *requestConfig) {
+		if ctx != nil {
+			c.ctx = ctx
+		}
+	}
+}
+
+/* Marks a request as the retry after an oauth token was
+   rejected, so it is not retried again */
+func withTokenRetried() requestOption {
+	return func(c *requestConfig) {
+		c.tokenRetried = true
+	}
+}
+
+/* Let a read be answered from the read cache, if that is enabled.
+   Only lookups that can tolerate what was read earlier in the run
+   should ask for this - refreshing a resource must see the API */
+func withCachedRead(cached bool) requestOption {
+	return func(c *requestConfig) {
+		c.cachedRead = cached
+	}
+}
+
+/* Hand the body of a successful response to fn as it arrives rather
+   than reading it into the response. max_response_bytes is then up to
+   fn, which should only count what it keeps. Bodies that were cached
+   (or are being cached) are handed over just the same once read */
+func withStream(fn func(io.Reader) error) requestOption {
+	return func(c *requestConfig) {
+		c.stream = fn
+	}
+}
+
+/*apiResponse holds the interesting parts of a completed HTTP exchange */
+type apiResponse struct {
+	method     string
+	url        string
+	statusCode int
+	header     http.Header
+	body       string
 }
 
 //NewAPIClient makes a new api client for RESTful calls
@@ -79,6 +210,12 @@ func NewAPIClient(opt *apiClientOpt) (*APIClient, error) {
 		return nil, errors.New("uri must be set to construct an API client")
 	}
 
+	/* 0 means tokens are used right up to their expiry, so the default
+	   skew comes from the provider schema rather than being filled in here */
+	if opt.oauthExpirySkew < 0 {
+		return nil, fmt.Errorf("oauth_expiry_skew must not be negative, got %d", opt.oauthExpirySkew)
+	}
+
 	/* Sane default */
 	if opt.idAttribute == "" {
 		opt.idAttribute = "id"
@@ -102,6 +239,18 @@ func NewAPIClient(opt *apiClientOpt) (*APIClient, error) {
 	if opt.destroyMethod == "" {
 		opt.destroyMethod = "DELETE"
 	}
+	if opt.errorBodyLength == 0 {
+		opt.errorBodyLength = defaultErrorBodyLength
+	}
+	if opt.maxResponseBytes <= 0 {
+		opt.maxResponseBytes = defaultMaxResponseBytes
+	}
+	if opt.maxPages <= 0 {
+		opt.maxPages = defaultMaxPages
+	}
+	if opt.verboseErrorLines <= 0 {
+		opt.verboseErrorLines = defaultVerboseErrorLines
+	}
 
 	tlsConfig := &tls.Config{
 		/* Disable TLS verification if requested */
@@ -120,6 +269,33 @@ func NewAPIClient(opt *apiClientOpt) (*APIClient, error) {
 		TLSClientConfig: tlsConfig,
 		Proxy:           http.ProxyFromEnvironment,
 	}
+	var transport http.RoundTripper = tr
+
+	/* The oauth token endpoint keeps its default transport
+	   unless it needs to honor host_overrides */
+	var oauthHTTPClient *http.Client
+	if opt.transport != nil {
+		/* A transport that was handed in is used as it is for every
+		   request, so the TLS and host_overrides settings are its concern */
+		transport = opt.transport
+		oauthHTTPClient = &http.Client{
+			Timeout:   time.Second * time.Duration(opt.timeout),
+			Transport: opt.transport,
+		}
+	} else if len(opt.hostOverrides) > 0 {
+		overrides, err := validateHostOverrides(opt.hostOverrides)
+		if err != nil {
+			return nil, err
+		}
+		tr.DialContext = hostOverrideDialer(overrides, opt.debug)
+
+		tokenTransport := http.DefaultTransport.(*http.Transport).Clone()
+		tokenTransport.DialContext = tr.DialContext
+		oauthHTTPClient = &http.Client{
+			Timeout:   time.Second * time.Duration(opt.timeout),
+			Transport: tokenTransport,
+		}
+	}
 
 	var cookieJar http.CookieJar
 
@@ -127,15 +303,23 @@ func NewAPIClient(opt *apiClientOpt) (*APIClient, error) {
 		cookieJar, _ = cookiejar.New(nil)
 	}
 
-	rateLimit := rate.Limit(opt.rateLimit)
-	bucketSize := int(math.Max(math.Round(opt.rateLimit), 1))
+	/* Unset (or the provider's default of the largest float) means
+	   no limit. Newer versions of the rate package never refill a
+	   bucket with a limit of 0, and the bucket size would overflow */
+	rateLimit := rate.Inf
+	bucketSize := 1
+	if opt.rateLimit > 0 && opt.rateLimit < math.MaxFloat64 {
+		rateLimit = rate.Limit(opt.rateLimit)
+		bucketSize = int(math.Max(math.Round(math.Min(opt.rateLimit, math.MaxInt32)), 1))
+	}
 	log.Printf("limit: %f bucket: %d", opt.rateLimit, bucketSize)
 	rateLimiter := rate.NewLimiter(rateLimit, bucketSize)
 
 	client := APIClient{
+		/* The timeout is applied to each request as a deadline
+		   so that individual calls can override it */
 		httpClient: &http.Client{
-			Timeout:   time.Second * time.Duration(opt.timeout),
-			Transport: tr,
+			Transport: transport,
 			Jar:       cookieJar,
 		},
 		rateLimiter:         rateLimiter,
@@ -153,7 +337,39 @@ func NewAPIClient(opt *apiClientOpt) (*APIClient, error) {
 		writeReturnsObject:  opt.writeReturnsObject,
 		createReturnsObject: opt.createReturnsObject,
 		xssiPrefix:          opt.xssiPrefix,
+		timeout:             time.Second * time.Duration(opt.timeout),
+		contentType:         opt.contentType,
+		accept:              opt.accept,
+		maxPages:            opt.maxPages,
+		verboseErrors:       opt.verboseErrors,
+		verboseErrorLines:   opt.verboseErrorLines,
+		readFailureMode:     opt.readFailureMode,
+		userAgent:           opt.userAgent,
+		errorBodyLength:     opt.errorBodyLength,
 		debug:               opt.debug,
+		maxResponseBytes:    opt.maxResponseBytes,
+		metrics:             newAPIMetrics(),
+		circuitBreaker: newCircuitBreaker(
+			opt.circuitThreshold,
+			time.Second*time.Duration(opt.circuitWindow),
+			time.Second*time.Duration(opt.circuitCooldown),
+		),
+	}
+
+	if client.contentType == "" {
+		client.contentType = defaultContentType
+	}
+
+	if opt.slowThreshold > 0 {
+		client.slowThreshold = time.Duration(opt.slowThreshold) * time.Second
+	}
+
+	if opt.enableHTTPCache {
+		client.etagCache = newETagCache(httpCacheSize)
+	}
+
+	if opt.enableReadCache {
+		client.readCache = newReadCache()
 	}
 
 	if opt.oauthClientID != "" && opt.oauthClientSecret != "" && opt.oauthTokenURL != "" {
@@ -164,6 +380,26 @@ func NewAPIClient(opt *apiClientOpt) (*APIClient, error) {
 			Scopes:         opt.oauthScopes,
 			EndpointParams: opt.oauthEndpointParams,
 		}
+
+		client.oauthHTTPClient = oauthHTTPClient
+
+		/* The token is fetched on first use rather than here. Every
+		   request waits on the fetch, so a token endpoint that hangs
+		   gets no longer than any other request would */
+		config := client.oauthConfig
+		timeout := client.timeout
+		client.oauthTokenSource = newSkewTokenSource(func() (*oauth2.Token, error) {
+			ctx := context.Background()
+			if timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+			}
+			if oauthHTTPClient != nil {
+				ctx = context.WithValue(ctx, oauth2.HTTPClient, oauthHTTPClient)
+			}
+			return config.Token(ctx)
+		}, time.Duration(opt.oauthExpirySkew)*time.Second)
 	}
 
 	if opt.debug {
@@ -195,31 +431,90 @@ func (client *APIClient) toString() string {
 
 /* Helper function that handles sending/receiving and handling
    of HTTP data in and out. */
-func (client *APIClient) sendRequest(method string, path string, data string) (string, error) {
+func (client *APIClient) sendRequest(method string, path string, data string, options ...requestOption) (string, error) {
+	resp, err := client.doRequest(method, path, data, options...)
+	if resp == nil {
+		return "", err
+	}
+	return resp.body, err
+}
+
+/* Same as sendRequest, but returns the full response so callers
+   can consult the status and headers. Any failure is returned as
+   an *APIError. A response is returned whenever one was received,
+   even when err is also set. */
+func (client *APIClient) doRequest(method string, path string, data string, options ...requestOption) (*apiResponse, error) {
+	var body io.Reader
+	if data != "" {
+		body = strings.NewReader(data)
+	}
+	return client.doRequestBody(method, path, body, options...)
+}
+
+/* Same as doRequest, but the body is read from a reader as it is sent
+   so large payloads need not be held in memory as well. Bodies that are
+   bytes or string readers, or can seek, are sent with a Content-Length
+   and can be sent again if the request has to be retried (or follows a
+   redirect). Others are sent chunked, and the request is not retried */
+func (client *APIClient) doRequestBody(method string, path string, reqBody io.Reader, options ...requestOption) (*apiResponse, error) {
 	fullURI := client.uri + path
 	var req *http.Request
 	var err error
 
+	config := &requestConfig{
+		ctx:              context.Background(),
+		maxResponseBytes: client.maxResponseBytes,
+		timeout:          client.timeout,
+	}
+	for _, option := range options {
+		option(config)
+	}
+
 	if client.debug {
-		log.Printf("api_client.go: method='%s', path='%s', full uri (derived)='%s', data='%s'\n", method, path, fullURI, data)
+		log.Printf("api_client.go: method='%s', path='%s', full uri (derived)='%s', data='%s'\n", method, path, fullURI, describeBody(reqBody))
 	}
 
-	buffer := bytes.NewBuffer([]byte(data))
+	/* Reads that may be cached don't reach the API (or wait on the
+	   rate limiter) if they were answered earlier. Writes make what
+	   was cached for the path and its parents stale once they finish */
+	cacheable := client.readCache != nil && config.cachedRead && reqBody == nil
+	if cacheable {
+		if cached, ok := client.readCache.get(method, fullURI); ok {
+			if client.debug {
+				log.Printf("api_client.go: Using the response read earlier from %s %s\n", method, fullURI)
+			}
+			client.metrics.readCacheResult(true)
+			return client.streamBody(config, cached)
+		}
+		client.metrics.readCacheResult(false)
+	} else if client.readCache != nil && method != "GET" && method != "HEAD" {
+		defer client.readCache.invalidate(fullURI)
+	}
 
-	if data == "" {
+	if reqBody == nil {
 		req, err = http.NewRequest(method, fullURI, nil)
 	} else {
-		req, err = http.NewRequest(method, fullURI, buffer)
+		req, err = http.NewRequest(method, fullURI, reqBody)
 
 		/* Default of application/json, but allow headers array to overwrite later */
 		if err == nil {
-			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Content-Type", client.contentType)
+			err = rewindableBody(req, reqBody)
 		}
 	}
 
+	if err == nil && client.accept != "" {
+		req.Header.Set("Accept", client.accept)
+	}
+
+	/* A User-Agent in the headers array takes precedence */
+	if err == nil && client.userAgent != "" {
+		req.Header.Set("User-Agent", client.userAgent)
+	}
+
 	if err != nil {
 		log.Fatal(err)
-		return "", err
+		return nil, err
 	}
 
 	if client.debug {
@@ -233,11 +528,12 @@ func (client *APIClient) sendRequest(method string, path string, data string) (s
 		}
 	}
 
-	if client.oauthConfig != nil {
-		tokenSource := client.oauthConfig.TokenSource(context.Background())
-		token, err := tokenSource.Token()
+	var token *oauth2.Token
+	var tokenFetchedAt time.Time
+	if client.oauthTokenSource != nil {
+		token, tokenFetchedAt, err = client.oauthTokenSource.Token()
 		if err != nil {
-			return "", err
+			return nil, newAPIError(method, fullURI, nil, "", client.errorBodyLength, fmt.Errorf("failed to obtain an oauth token from %s: %w", client.oauthConfig.TokenURL, err))
 		}
 		req.Header.Set("Authorization", "Bearer "+token.AccessToken)
 	}
@@ -247,6 +543,21 @@ func (client *APIClient) sendRequest(method string, path string, data string) (s
 		req.SetBasicAuth(client.username, client.password)
 	}
 
+	/* If we have seen this object before, ask the server to
+	   only send it again if it changed. Writes make whatever
+	   we have cached for the path stale */
+	var cached *etagEntry
+	if client.etagCache != nil {
+		if method == "GET" {
+			if entry, ok := client.etagCache.get(fullURI); ok {
+				cached = entry
+				req.Header.Set("If-None-Match", entry.etag)
+			}
+		} else {
+			client.etagCache.invalidate(fullURI)
+		}
+	}
+
 	if client.debug {
 		log.Printf("api_client.go: Request headers:\n")
 		for name, headers := range req.Header {
@@ -256,11 +567,13 @@ func (client *APIClient) sendRequest(method string, path string, data string) (s
 		}
 
 		log.Printf("api_client.go: BODY:\n")
-		body := "<none>"
-		if req.Body != nil {
-			body = string(data)
-		}
-		log.Printf("%s\n", body)
+		log.Printf("%s\n", describeBody(reqBody))
+	}
+
+	/* Don't bother waiting on the rate limiter for an API that is down */
+	probe, err := client.circuitBreaker.admit()
+	if err != nil {
+		return nil, newAPIError(method, fullURI, nil, "", client.errorBodyLength, err)
 	}
 
 	if client.rateLimiter != nil {
@@ -268,14 +581,44 @@ func (client *APIClient) sendRequest(method string, path string, data string) (s
 		if client.debug {
 			log.Printf("Waiting for rate limit availability\n")
 		}
-		_ = client.rateLimiter.Wait(context.Background())
+		if err := client.rateLimiter.Wait(config.ctx); err != nil {
+			if probe {
+				client.circuitBreaker.abandon()
+			}
+			return nil, newAPIError(method, fullURI, nil, "", client.errorBodyLength, err)
+		}
 	}
 
+	ctx := config.ctx
+	if config.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.timeout)
+		defer cancel()
+	}
+
+	startTime := time.Now()
+	timer := newRequestTimer()
+	req = req.WithContext(httptrace.WithClientTrace(ctx, timer.clientTrace()))
 	resp, err := client.httpClient.Do(req)
 
 	if err != nil {
 		//log.Printf("api_client.go: Error detected: %s\n", err)
-		return "", err
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("request timed out after %s: %w", config.timeout, err)
+		}
+		client.metrics.record(method, path, 0, time.Since(startTime))
+		recordTrace(config.ctx, method, path, 0, time.Since(startTime))
+		/* Terraform giving up on the request says nothing about the API */
+		if config.ctx.Err() != nil {
+			if probe {
+				client.circuitBreaker.abandon()
+			}
+		} else {
+			client.circuitBreaker.failure(err)
+		}
+		apiErr := newAPIError(method, fullURI, nil, "", client.errorBodyLength, err)
+		apiErr.NoResponse = true
+		return nil, apiErr
 	}
 
 	if client.debug {
@@ -288,21 +631,325 @@ func (client *APIClient) sendRequest(method string, path string, data string) (s
 		}
 	}
 
-	bodyBytes, err2 := ioutil.ReadAll(resp.Body)
+	if config.stream != nil && !cacheable && resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return client.streamResponse(config, method, path, fullURI, resp, timer, startTime)
+	}
+
+	/* Read one byte past the limit so we can tell if it was exceeded */
+	bodyBytes, err2 := ioutil.ReadAll(io.LimitReader(resp.Body, config.maxResponseBytes+1))
 	resp.Body.Close()
+	timer.done()
+	client.metrics.record(method, path, resp.StatusCode, time.Since(startTime))
+	recordTrace(config.ctx, method, path, resp.StatusCode, time.Since(startTime))
+	client.logTiming(config.ctx, method, fullURI, resp.StatusCode, timer)
 
 	if err2 != nil {
-		return "", err2
+		client.circuitBreaker.failure(err2)
+		return nil, newAPIError(method, fullURI, resp, "", client.errorBodyLength, err2)
+	}
+	client.circuitBreaker.success()
+	client.metrics.received(len(bodyBytes))
+
+	if int64(len(bodyBytes)) > config.maxResponseBytes {
+		return nil, newAPIError(method, fullURI, resp, "", client.errorBodyLength,
+			fmt.Errorf("response body exceeded the max_response_bytes limit of %d bytes (Content-Type: '%s') - is the uri pointing at the API?", config.maxResponseBytes, resp.Header.Get("Content-Type")))
+	}
+	body := stripXSSIPrefix(string(bodyBytes), client.xssiPrefix)
+
+	result := &apiResponse{
+		method:     method,
+		url:        fullURI,
+		statusCode: resp.StatusCode,
+		header:     resp.Header,
+		body:       body,
+	}
+
+	if client.etagCache != nil && method == "GET" {
+		if resp.StatusCode == http.StatusNotModified && cached != nil {
+			if client.debug {
+				log.Printf("api_client.go: Not modified - using cached response for %s\n", fullURI)
+			}
+			client.metrics.cacheResult(true)
+			result.statusCode = http.StatusOK
+			result.header = cached.header
+			result.body = cached.body
+			return client.streamBody(config, result)
+		}
+		client.metrics.cacheResult(false)
+		if etag := resp.Header.Get("ETag"); etag != "" && resp.StatusCode == http.StatusOK {
+			client.etagCache.put(fullURI, etag, body, resp.Header)
+		}
 	}
-	body := strings.TrimPrefix(string(bodyBytes), client.xssiPrefix)
+
 	if client.debug {
 		log.Printf("api_client.go: BODY:\n%s\n", body)
 	}
 
+	contentType := resp.Header.Get("Content-Type")
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return body, fmt.Errorf("unexpected response code '%d': %s", resp.StatusCode, body)
+		/* Gateways love to return HTML error pages. There is no
+		   point trying to find an error envelope in those */
+		bodyLength := client.errorBodyLength
+		if !isJSON(contentType, body) && bodyLength > nonJSONErrorBodyLength {
+			bodyLength = nonJSONErrorBodyLength
+		}
+		apiErr := newAPIError(method, fullURI, resp, body, bodyLength, nil)
+		switch resp.StatusCode {
+		case http.StatusUnauthorized:
+			/* The server says the token is no good but we think it
+			   is - get a new one and try once more */
+			if token != nil && !config.tokenRetried && strings.Contains(resp.Header.Get("WWW-Authenticate"), "invalid_token") {
+				client.oauthTokenSource.invalidate(token)
+				retryBody, rewound := rewindBody(req)
+				if rewound {
+					log.Printf("api_client.go: oauth token was rejected as invalid_token - refreshing it and retrying %s %s", method, fullURI)
+					return client.doRequestBody(method, path, retryBody, append(options, withTokenRetried())...)
+				}
+				log.Printf("api_client.go: oauth token was rejected as invalid_token but the body of %s %s cannot be sent again, so it is not retried", method, fullURI)
+			}
+			authErr := &UnauthorizedError{APIError: apiErr, AuthMethod: client.authMethod()}
+			if !tokenFetchedAt.IsZero() {
+				authErr.TokenFetched = true
+				authErr.TokenAge = time.Since(tokenFetchedAt)
+				authErr.TokenRefreshed = config.tokenRetried
+			}
+			return result, authErr
+		case http.StatusForbidden:
+			return result, &ForbiddenError{APIError: apiErr, AuthMethod: client.authMethod(), Path: path}
+		}
+		return result, apiErr
+	}
+
+	if body != "" && contentType != "" && !isJSONContentType(contentType) {
+		log.Printf("api_client.go: WARNING: %s %s returned Content-Type '%s' rather than JSON. Attempting to use the response anyway.", method, fullURI, contentType)
+	}
+
+	if cacheable {
+		client.readCache.put(result)
 	}
 
-	return body, nil
+	return client.streamBody(config, result)
+}
+
+/* Pass a successful response straight from the wire to the request's
+   stream function. The body is not kept, so it is not cached either */
+func (client *APIClient) streamResponse(config *requestConfig, method string, path string, fullURI string, resp *http.Response, timer *requestTimer, startTime time.Time) (*apiResponse, error) {
+	body := &countingReader{r: resp.Body}
+	err := config.stream(stripXSSIPrefixReader(body, client.xssiPrefix))
+	/* Anything left unread (if the stream stopped early) is dropped
+	   with the connection rather than downloaded for nothing */
+	resp.Body.Close()
+	timer.done()
+	client.metrics.record(method, path, resp.StatusCode, time.Since(startTime))
+	recordTrace(config.ctx, method, path, resp.StatusCode, time.Since(startTime))
+	client.logTiming(config.ctx, method, fullURI, resp.StatusCode, timer)
+	client.metrics.received(int(body.n))
+
+	result := &apiResponse{
+		method:     method,
+		url:        fullURI,
+		statusCode: resp.StatusCode,
+		header:     resp.Header,
+	}
+	if body.err != nil && body.err != io.EOF {
+		client.circuitBreaker.failure(body.err)
+		return nil, newAPIError(method, fullURI, resp, "", client.errorBodyLength, body.err)
+	}
+	client.circuitBreaker.success()
+	return result, client.responseError(result, err)
+}
+
+/* Pass a response body that was read in full to the request's stream function, if it has one */
+func (client *APIClient) streamBody(config *requestConfig, result *apiResponse) (*apiResponse, error) {
+	if config.stream == nil {
+		return result, nil
+	}
+	return result, client.responseError(result, config.stream(strings.NewReader(result.body)))
+}
+
+/* A body that was sent once and is being sent again, such as on the
+   retry after an oauth token was rejected */
+type replayBody struct {
+	getBody func() (io.ReadCloser, error)
+	length  int64
+}
 
+func (r *replayBody) Read(p []byte) (int, error) {
+	return 0, errors.New("replayBody is only read through its request")
+}
+
+/* http.NewRequest already knows how long bytes and string readers are
+   and how to read them again. Anything else that can seek gets the same
+   treatment here, and is not closed when sent - that is up to whoever
+   supplied it */
+func rewindableBody(req *http.Request, body io.Reader) error {
+	switch b := body.(type) {
+	case *bytes.Buffer, *bytes.Reader, *strings.Reader:
+		return nil
+	case *replayBody:
+		rc, err := b.getBody()
+		if err != nil {
+			return err
+		}
+		req.Body, req.GetBody, req.ContentLength = rc, b.getBody, b.length
+	case io.ReadSeeker:
+		start, err := b.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		end, err := b.Seek(0, io.SeekEnd)
+		if err != nil {
+			return err
+		}
+		if _, err := b.Seek(start, io.SeekStart); err != nil {
+			return err
+		}
+		req.Body = io.NopCloser(b)
+		req.ContentLength = end - start
+		req.GetBody = func() (io.ReadCloser, error) {
+			if _, err := b.Seek(start, io.SeekStart); err != nil {
+				return nil, err
+			}
+			return io.NopCloser(b), nil
+		}
+	}
+	return nil
+}
+
+/* Returns the body to send a request with again, which is false
+   if the body it was sent with could only be read once */
+func rewindBody(req *http.Request) (io.Reader, bool) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, true
+	}
+	if req.GetBody == nil {
+		return nil, false
+	}
+	return &replayBody{getBody: req.GetBody, length: req.ContentLength}, true
+}
+
+/* What is logged for a request body in debug mode. Streamed bodies
+   are not read just to log them */
+func describeBody(body io.Reader) string {
+	switch b := body.(type) {
+	case nil:
+		return "<none>"
+	case *strings.Reader:
+		buf := make([]byte, b.Size())
+		n, _ := b.ReadAt(buf, 0)
+		return string(buf[:n])
+	case *bytes.Reader:
+		buf := make([]byte, b.Size())
+		n, _ := b.ReadAt(buf, 0)
+		return string(buf[:n])
+	case *bytes.Buffer:
+		return b.String()
+	case *replayBody:
+		return fmt.Sprintf("<%d bytes, sent again>", b.length)
+	}
+	return fmt.Sprintf("<streamed from a %T>", body)
+}
+
+/* Counts what is read through it, and remembers the first error */
+type countingReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if err != nil && c.err == nil {
+		c.err = err
+	}
+	return n, err
+}
+
+/* Only this much of a non-JSON error body (such as an HTML
+   error page from a gateway) is included in errors */
+const nonJSONErrorBodyLength = 200
+
+/* The same as stripXSSIPrefix, for a body that is being streamed */
+func stripXSSIPrefixReader(r io.Reader, prefix string) io.Reader {
+	if prefix == "" {
+		return r
+	}
+	buffered := bufio.NewReader(r)
+	if peeked, _ := buffered.Peek(len(prefix) + 2); strings.HasPrefix(string(peeked), prefix) {
+		buffered.Discard(len(peeked) - len(stripXSSIPrefix(string(peeked), prefix)))
+	}
+	return buffered
+}
+
+/* Remove an XSSI protection prefix (such as `)]}'`) from a response body.
+   A newline following the prefix is removed as well */
+func stripXSSIPrefix(body string, prefix string) string {
+	if prefix == "" || !strings.HasPrefix(body, prefix) {
+		return body
+	}
+	body = strings.TrimPrefix(body, prefix)
+	if strings.HasPrefix(body, "\r\n") {
+		return body[2:]
+	}
+	return strings.TrimPrefix(body, "\n")
+}
+
+/* Whether the Content-Type header claims the body is JSON */
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || mediaType == "text/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+/* Whether a response is JSON, going by the Content-Type if
+   present or what the body looks like if not */
+func isJSON(contentType string, body string) bool {
+	if contentType != "" {
+		return isJSONContentType(contentType)
+	}
+	trimmed := strings.TrimSpace(body)
+	return strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[")
+}
+
+const (
+	authMethodNone   = "none"
+	authMethodOAuth  = "oauth client credentials"
+	authMethodBasic  = "basic auth"
+	authMethodHeader = "Authorization header"
+)
+
+/* Describe how this client authenticates to the API, for error messages */
+func (client *APIClient) authMethod() string {
+	/* Basic auth is applied last in doRequest, so it wins */
+	if client.username != "" && client.password != "" {
+		return authMethodBasic
+	}
+	if client.oauthConfig != nil {
+		return authMethodOAuth
+	}
+	for k := range client.headers {
+		if strings.EqualFold(k, "Authorization") {
+			return authMethodHeader
+		}
+	}
+	return authMethodNone
+}
+
+/* Attach the HTTP context of a response to an error that occurred
+   while processing it (such as a JSON decode failure) */
+func (client *APIClient) responseError(resp *apiResponse, err error) error {
+	if err == nil || resp == nil {
+		return err
+	}
+	return &APIError{
+		Method:     resp.method,
+		URL:        resp.url,
+		StatusCode: resp.statusCode,
+		RequestID:  requestIDFromHeader(resp.header),
+		Body:       excerpt(resp.body, client.errorBodyLength),
+		Err:        err,
+	}
 }
@@ -1,10 +1,22 @@
 package restapi
 
 import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/Mastercard/terraform-provider-restapi/fakeserver"
 )
 
 var apiClientServer *http.Server
@@ -116,3 +128,610 @@ func setupAPIClientServer() {
 func shutdownAPIClientServer() {
 	apiClientServer.Close()
 }
+
+/* A transport that hands requests straight to handler,
+   so tests can reach a fakeserver without any sockets */
+type handlerTransport struct {
+	handler http.Handler
+}
+
+func (t handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := req.Clone(req.Context())
+	if r.Body == nil {
+		r.Body = http.NoBody
+	}
+	r.RequestURI = r.URL.RequestURI()
+
+	rec := httptest.NewRecorder()
+	t.handler.ServeHTTP(rec, r)
+	resp := rec.Result()
+	resp.Request = req
+	return resp, nil
+}
+
+func TestAPIClientTransport(t *testing.T) {
+	svr := fakeserver.NewFakeServer(0, map[string]map[string]interface{}{"1": {"id": "1"}}, false, false, "")
+	svr.SetAuth(fakeserver.Auth{ClientID: "id", ClientSecret: "secret"})
+
+	/* Nothing listens here; every request has to go through the transport */
+	client, err := NewAPIClient(&apiClientOpt{
+		uri:               "http://fakeserver.invalid",
+		timeout:           2,
+		oauthClientID:     "id",
+		oauthClientSecret: "secret",
+		oauthTokenURL:     "http://fakeserver.invalid/oauth/token",
+		transport:         handlerTransport{svr.Handler()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := client.sendRequest("GET", "/api/objects/1", "")
+	if err != nil {
+		t.Fatalf("client_test.go: %s", err)
+	}
+	if res != `{"id":"1"}` {
+		t.Errorf("client_test.go: Got back '%s' but expected the object", res)
+	}
+	if svr.TokensIssued() != 1 {
+		t.Errorf("client_test.go: expected the oauth token to be fetched through the transport, but %d were issued", svr.TokensIssued())
+	}
+}
+
+func TestAPIClientMaxResponseBytes(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		chunk := []byte(strings.Repeat("<div>web ui</div>", 64))
+		for i := 0; i < 1024; i++ {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+		}
+	}))
+	defer svr.Close()
+
+	client, err := NewAPIClient(&apiClientOpt{
+		uri:              svr.URL,
+		timeout:          2,
+		maxResponseBytes: 4096,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.sendRequest("GET", "/bundle.js", "")
+	if err == nil {
+		t.Fatalf("client_test.go: expected an oversized response to be refused")
+	}
+	for _, expected := range []string{"max_response_bytes limit of 4096 bytes", svr.URL + "/bundle.js", "text/html"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("client_test.go: expected '%s' to contain '%s'", err, expected)
+		}
+	}
+
+	/* A per-call override allows more */
+	resp, err := client.doRequest("GET", "/bundle.js", "", withMaxResponseBytes(2*1024*1024))
+	if err != nil {
+		t.Fatalf("client_test.go: expected the per-call limit to allow the response: %s", err)
+	}
+	if len(resp.body) != 1024*64*len("<div>web ui</div>") {
+		t.Errorf("client_test.go: unexpected body length %d", len(resp.body))
+	}
+}
+
+func TestAPIClientResponseFormats(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/prefixed", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(")]}'\n{\"id\": \"1234\"}"))
+	})
+	mux.HandleFunc("/badgateway", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte("<html><body>" + strings.Repeat("<p>502 Bad Gateway</p>", 50) + "</body></html>"))
+	})
+	mux.HandleFunc("/empty", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	mux.HandleFunc("/plain", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(`{"id": "1234"}`))
+	})
+	svr := httptest.NewServer(mux)
+	defer svr.Close()
+
+	client, err := NewAPIClient(&apiClientOpt{
+		uri:        svr.URL,
+		timeout:    2,
+		xssiPrefix: ")]}'",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := client.sendRequest("GET", "/prefixed", "")
+	if err != nil {
+		t.Fatalf("client_test.go: %s", err)
+	}
+	if res != `{"id": "1234"}` {
+		t.Errorf("client_test.go: expected the XSSI prefix and newline to be stripped but got '%s'", res)
+	}
+
+	_, err = client.sendRequest("GET", "/badgateway", "")
+	if err == nil {
+		t.Fatalf("client_test.go: expected an error for a 502")
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 502 {
+		t.Fatalf("client_test.go: expected an APIError with status 502 but got: %v", err)
+	}
+	if !strings.HasPrefix(apiErr.Body, "<html><body><p>502 Bad Gateway</p>") || len(apiErr.Body) > nonJSONErrorBodyLength+32 {
+		t.Errorf("client_test.go: expected a short excerpt of the HTML page but got '%s'", apiErr.Body)
+	}
+
+	_, err = client.sendRequest("GET", "/empty", "")
+	if err == nil || !strings.Contains(err.Error(), "unexpected response code '503' (empty response body)") {
+		t.Errorf("client_test.go: unexpected error for an empty 503: %v", err)
+	}
+
+	/* Unexpected content types on success are used anyway */
+	res, err = client.sendRequest("GET", "/plain", "")
+	if err != nil || res != `{"id": "1234"}` {
+		t.Errorf("client_test.go: expected a best-effort result for text/plain but got '%s', %v", res, err)
+	}
+}
+
+func TestAPIClientContentHeaders(t *testing.T) {
+	var tokenHeaders, objectHeaders http.Header
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		tokenHeaders = r.Header.Clone()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token": "abc", "token_type": "bearer", "expires_in": 3600}`))
+	})
+	mux.HandleFunc("/api/objects/1", func(w http.ResponseWriter, r *http.Request) {
+		objectHeaders = r.Header.Clone()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": "1"}`))
+	})
+	svr := httptest.NewServer(mux)
+	defer svr.Close()
+
+	cases := []struct {
+		name        string
+		opt         *apiClientOpt
+		method      string
+		data        string
+		contentType string
+		accept      string
+	}{
+		{
+			name:        "defaults",
+			opt:         &apiClientOpt{},
+			method:      "PUT",
+			data:        `{"id": "1"}`,
+			contentType: "application/json",
+		},
+		{
+			name:        "configured",
+			opt:         &apiClientOpt{contentType: "application/json; charset=utf-8", accept: "application/vnd.api+json"},
+			method:      "PUT",
+			data:        `{"id": "1"}`,
+			contentType: "application/json; charset=utf-8",
+			accept:      "application/vnd.api+json",
+		},
+		{
+			name:   "no_body",
+			opt:    &apiClientOpt{contentType: "application/vnd.api+json", accept: "application/vnd.api+json"},
+			method: "GET",
+			accept: "application/vnd.api+json",
+		},
+		{
+			name: "headers_take_precedence",
+			opt: &apiClientOpt{
+				contentType: "application/vnd.api+json",
+				accept:      "application/vnd.api+json",
+				headers:     map[string]string{"Content-Type": "text/plain", "Accept": "*/*"},
+			},
+			method:      "POST",
+			data:        `{"id": "1"}`,
+			contentType: "text/plain",
+			accept:      "*/*",
+		},
+		{
+			name: "oauth",
+			opt: &apiClientOpt{
+				contentType:       "application/vnd.api+json",
+				accept:            "application/vnd.api+json",
+				oauthClientID:     "id",
+				oauthClientSecret: "secret",
+				oauthTokenURL:     svr.URL + "/token",
+			},
+			method:      "PUT",
+			data:        `{"id": "1"}`,
+			contentType: "application/vnd.api+json",
+			accept:      "application/vnd.api+json",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tokenHeaders, objectHeaders = nil, nil
+			c.opt.uri = svr.URL
+			c.opt.timeout = 2
+			client, err := NewAPIClient(c.opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := client.sendRequest(c.method, "/api/objects/1", c.data); err != nil {
+				t.Fatalf("client_test.go: %s", err)
+			}
+			if got := objectHeaders.Get("Content-Type"); got != c.contentType {
+				t.Errorf("client_test.go: expected Content-Type '%s' but the server got '%s'", c.contentType, got)
+			}
+			if got := objectHeaders.Get("Accept"); got != c.accept {
+				t.Errorf("client_test.go: expected Accept '%s' but the server got '%s'", c.accept, got)
+			}
+			if tokenHeaders != nil {
+				if got := tokenHeaders.Get("Content-Type"); got != "application/x-www-form-urlencoded" {
+					t.Errorf("client_test.go: oauth token request should not use the configured Content-Type but sent '%s'", got)
+				}
+				if got := tokenHeaders.Get("Accept"); got == c.accept {
+					t.Errorf("client_test.go: oauth token request should not use the configured Accept")
+				}
+			}
+		})
+	}
+}
+
+func TestAPIClientRequestTimeout(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(1500 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": "1"}`))
+	}))
+	defer svr.Close()
+
+	client, err := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	/* A short per-call timeout fires even though the provider's would not */
+	_, err = client.doRequest("GET", "/slow", "", withTimeout(500*time.Millisecond))
+	if err == nil || !strings.Contains(err.Error(), "timed out after 500ms") {
+		t.Fatalf("client_test.go: expected the per-call timeout to fire but got: %v", err)
+	}
+
+	if _, err := client.doRequest("GET", "/slow", ""); err != nil {
+		t.Fatalf("client_test.go: expected the provider timeout to allow the request: %s", err)
+	}
+
+	/* ... and a longer one allows a call the provider's would abort */
+	client, err = NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.doRequest("GET", "/slow", ""); err == nil {
+		t.Fatalf("client_test.go: expected the provider timeout to abort the request")
+	}
+	if _, err := client.doRequest("GET", "/slow", "", withTimeout(5*time.Second)); err != nil {
+		t.Fatalf("client_test.go: expected the longer per-call timeout to allow the request: %s", err)
+	}
+}
+
+func TestAPIClientAuthentication(t *testing.T) {
+	svr := fakeserver.NewFakeServer(0, make(map[string]map[string]interface{}), false, false, "")
+	httpSvr := httptest.NewServer(svr.Handler())
+	defer httpSvr.Close()
+
+	/* Sends a GET with a client built from opt, and returns the UnauthorizedError if it was rejected */
+	get := func(opt *apiClientOpt) (*UnauthorizedError, error) {
+		opt.uri = httpSvr.URL
+		opt.timeout = 2
+		client, err := NewAPIClient(opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = client.sendRequest("GET", "/api/objects", "")
+		var authErr *UnauthorizedError
+		if errors.As(err, &authErr) {
+			return authErr, nil
+		}
+		return nil, err
+	}
+	expectAccepted := func(mode string, opt *apiClientOpt) {
+		if authErr, err := get(opt); authErr != nil || err != nil {
+			t.Errorf("client_test.go: %s: expected the credentials to be accepted but got %v %v", mode, authErr, err)
+		}
+	}
+	expectRejected := func(mode string, opt *apiClientOpt, authMethod string) {
+		authErr, err := get(opt)
+		if err != nil || authErr == nil {
+			t.Errorf("client_test.go: %s: expected a 401 but got %v", mode, err)
+			return
+		}
+		if authErr.AuthMethod != authMethod {
+			t.Errorf("client_test.go: %s: expected auth method '%s' but got '%s'", mode, authMethod, authErr.AuthMethod)
+		}
+	}
+
+	svr.SetAuth(fakeserver.Auth{Username: "user", Password: "pass"})
+	expectAccepted("basic", &apiClientOpt{username: "user", password: "pass"})
+	expectRejected("basic", &apiClientOpt{username: "user", password: "wrong"}, authMethodBasic)
+	expectRejected("basic", &apiClientOpt{}, authMethodNone)
+
+	svr.SetAuth(fakeserver.Auth{BearerToken: "s3cret"})
+	expectAccepted("bearer", &apiClientOpt{headers: map[string]string{"Authorization": "Bearer s3cret"}})
+	expectRejected("bearer", &apiClientOpt{headers: map[string]string{"Authorization": "Bearer guess"}}, authMethodHeader)
+
+	svr.SetAuth(fakeserver.Auth{APIKeyHeader: "X-API-Key", APIKey: "k3y"})
+	expectAccepted("api key", &apiClientOpt{headers: map[string]string{"X-API-Key": "k3y"}})
+	expectRejected("api key", &apiClientOpt{headers: map[string]string{"X-API-Key": "nope"}}, authMethodNone)
+
+	/* The signature of an empty body, as sent with a GET */
+	svr.SetAuth(fakeserver.Auth{HMACHeader: "X-Signature", HMACSecret: "shared"})
+	mac := hmac.New(sha256.New, []byte("shared"))
+	expectAccepted("hmac", &apiClientOpt{headers: map[string]string{"X-Signature": hex.EncodeToString(mac.Sum(nil))}})
+	expectRejected("hmac", &apiClientOpt{headers: map[string]string{"X-Signature": "00"}}, authMethodNone)
+
+	svr.SetAuth(fakeserver.Auth{ClientID: "client", ClientSecret: "secret"})
+	oauth := &apiClientOpt{
+		oauthClientID:     "client",
+		oauthClientSecret: "secret",
+		oauthTokenURL:     httpSvr.URL + "/oauth/token",
+	}
+	expectAccepted("oauth", oauth)
+	if svr.TokensIssued() != 1 {
+		t.Errorf("client_test.go: oauth: expected one token to be issued but got %d", svr.TokensIssued())
+	}
+	expectRejected("oauth", &apiClientOpt{}, authMethodNone)
+
+	/* A rejected token is replaced once, without the caller seeing the 401 */
+	client, err := NewAPIClient(&apiClientOpt{uri: httpSvr.URL, timeout: 2, oauthClientID: "client", oauthClientSecret: "secret", oauthTokenURL: httpSvr.URL + "/oauth/token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.sendRequest("GET", "/api/objects", ""); err != nil {
+		t.Fatalf("client_test.go: oauth: %s", err)
+	}
+	svr.RevokeTokens()
+	if _, err := client.sendRequest("GET", "/api/objects", ""); err != nil {
+		t.Fatalf("client_test.go: oauth: expected the revoked token to be replaced but got %s", err)
+	}
+	if svr.TokensIssued() != 3 {
+		t.Errorf("client_test.go: oauth: expected a replacement token to be issued but %d were in total", svr.TokensIssued())
+	}
+
+	/* Bad client credentials fail at the token endpoint */
+	oauth.oauthClientSecret = "wrong"
+	if _, err := get(oauth); err == nil || !strings.Contains(err.Error(), "invalid_client") {
+		t.Errorf("client_test.go: oauth: expected the token endpoint to refuse the client but got %v", err)
+	}
+}
+
+func TestAPIClientInjectedFaults(t *testing.T) {
+	svr := fakeserver.NewFakeServer(0, map[string]map[string]interface{}{"1": {"id": "1"}}, false, false, "")
+	httpSvr := httptest.NewServer(svr.Handler())
+	defer httpSvr.Close()
+
+	client, err := NewAPIClient(&apiClientOpt{uri: httpSvr.URL, timeout: 2, idAttribute: "id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	object := func() *APIObject {
+		obj, err := NewAPIObject(client, &apiObjectOpts{path: "/api/objects", id: "1", data: `{"id": "1"}`})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return obj
+	}
+
+	/* Latency: a per-call timeout and cancellation both cut a slow request short */
+	svr.AddRule(&fakeserver.Rule{Latency: 500 * time.Millisecond})
+	if _, err := client.doRequest("GET", "/api/objects/1", "", withTimeout(100*time.Millisecond)); err == nil || !strings.Contains(err.Error(), "timed out after 100ms") {
+		t.Errorf("client_test.go: expected the per-call timeout to fire but got: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := object().readObject(ctx); err == nil || !strings.Contains(err.Error(), "context deadline exceeded") {
+		t.Errorf("client_test.go: expected the read to be cancelled but got: %v", err)
+	}
+	svr.ResetRules()
+
+	/* Fail the first N: the status code is reported, and the next request works */
+	failing := svr.AddRule(&fakeserver.Rule{Method: "PUT", Path: "/api/objects/1", Status: http.StatusServiceUnavailable, Times: 1})
+	var apiErr *APIError
+	if err := object().updateObject(context.Background()); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("client_test.go: expected the update to fail with a 503 but got: %v", err)
+	}
+	if err := object().updateObject(context.Background()); err != nil || failing.Matched() != 1 {
+		t.Errorf("client_test.go: expected only the first update to fail but got %v after %d failures", err, failing.Matched())
+	}
+
+	/* Timeouts count against a circuit, so it gets a client of its own */
+	client, err = NewAPIClient(&apiClientOpt{
+		uri:              httpSvr.URL,
+		timeout:          2,
+		idAttribute:      "id",
+		circuitThreshold: 1,
+		circuitWindow:    60,
+		circuitCooldown:  60,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	/* 429: an HTTP error, so it does not count against the circuit */
+	svr.AddRule(&fakeserver.Rule{RetryAfter: 30, Times: 2})
+	for i := 0; i < 2; i++ {
+		if _, err := client.sendRequest("GET", "/api/objects/1", ""); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusTooManyRequests {
+			t.Errorf("client_test.go: expected a 429 but got: %v", err)
+		}
+	}
+
+	/* Malformed JSON: the decode error points at where it went wrong */
+	svr.AddRule(&fakeserver.Rule{MalformedJSON: true, Times: 1})
+	if err := object().readObject(context.Background()); err == nil || !strings.Contains(err.Error(), "(at offset") {
+		t.Errorf("client_test.go: expected a decode error with its offset but got: %v", err)
+	}
+
+	/* A connection dropped mid-body is a transport failure, which opens the circuit */
+	svr.AddRule(&fakeserver.Rule{DropConnection: true, Times: 1})
+	if _, err := client.sendRequest("GET", "/api/objects/1", ""); err == nil {
+		t.Errorf("client_test.go: expected the dropped connection to be an error")
+	}
+	if _, err := client.sendRequest("GET", "/api/objects/1", ""); err == nil || !strings.Contains(err.Error(), "circuit open") {
+		t.Errorf("client_test.go: expected the circuit to open after the dropped connection but got: %v", err)
+	}
+}
+
+func TestStripXSSIPrefix(t *testing.T) {
+	cases := []struct {
+		body     string
+		prefix   string
+		expected string
+	}{
+		{")]}'\n{}", ")]}'", "{}"},
+		{")]}'\r\n{}", ")]}'", "{}"},
+		{")]}',\n{}", ")]}',", "{}"},
+		{"{}", ")]}'", "{}"},
+		{")]}'\n{}", "", ")]}'\n{}"},
+		{")]}'\n{}", ")]}'\n", "{}"},
+	}
+	for _, c := range cases {
+		if res := stripXSSIPrefix(c.body, c.prefix); res != c.expected {
+			t.Errorf("client_test.go: stripping '%q' from '%q' - expected '%q' but got '%q'", c.prefix, c.body, c.expected, res)
+		}
+	}
+}
+
+/* Only io.ReadSeeker, so http.NewRequest does not know how to rewind it */
+type seekOnlyReader struct {
+	io.ReadSeeker
+}
+
+func TestAPIClientRequestBody(t *testing.T) {
+	payload := bytes.Repeat([]byte(`{"name": "a large object"}`), 40000)
+
+	type received struct {
+		path          string
+		contentLength int64
+		body          []byte
+	}
+	var requests []received
+	tokenRequests := 0
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		tokenRequests++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(fmt.Sprintf(`{"access_token": "token-%d", "token_type": "bearer", "expires_in": 3600}`, tokenRequests)))
+	})
+	record := func(r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, received{path: r.URL.Path, contentLength: r.ContentLength, body: body})
+	}
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		http.Redirect(w, r, "/api/objects", http.StatusTemporaryRedirect)
+	})
+	mux.HandleFunc("/api/objects", func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		/* Only the first token handed out is considered expired */
+		if r.Header.Get("Authorization") == "Bearer token-1" {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": "1"}`))
+	})
+
+	newClient := func() *APIClient {
+		client, err := NewAPIClient(&apiClientOpt{
+			uri:               "http://fakeserver.invalid",
+			timeout:           2,
+			oauthClientID:     "id",
+			oauthClientSecret: "secret",
+			oauthTokenURL:     "http://fakeserver.invalid/token",
+			transport:         handlerTransport{mux},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return client
+	}
+
+	/* The body is sent in full to the redirect, again once it is followed,
+	   and twice more when the rejected token is replaced */
+	rewindable := map[string]func() io.Reader{
+		"bytes":  func() io.Reader { return bytes.NewReader(payload) },
+		"string": func() io.Reader { return strings.NewReader(string(payload)) },
+		"seeker": func() io.Reader { return seekOnlyReader{bytes.NewReader(payload)} },
+	}
+	for name, body := range rewindable {
+		requests, tokenRequests = nil, 0
+		if _, err := newClient().doRequestBody("POST", "/redirect", body()); err != nil {
+			t.Fatalf("client_test.go: %s: %s", name, err)
+		}
+		if tokenRequests != 2 || len(requests) != 4 {
+			t.Fatalf("client_test.go: %s: expected 4 requests with 2 tokens but got %d requests with %d tokens", name, len(requests), tokenRequests)
+		}
+		for i, r := range requests {
+			if !bytes.Equal(r.body, payload) {
+				t.Errorf("client_test.go: %s: request %d to %s was sent %d of the %d bytes", name, i, r.path, len(r.body), len(payload))
+			}
+			if r.contentLength != int64(len(payload)) {
+				t.Errorf("client_test.go: %s: request %d to %s had Content-Length %d", name, i, r.path, r.contentLength)
+			}
+		}
+	}
+
+	/* A body that can only be read once is streamed, and not sent again */
+	requests, tokenRequests = nil, 0
+	_, err := newClient().doRequestBody("POST", "/api/objects", io.MultiReader(bytes.NewReader(payload)))
+	var authErr *UnauthorizedError
+	if !errors.As(err, &authErr) {
+		t.Fatalf("client_test.go: expected the rejected token to be returned as an error for a body that cannot be sent again but got: %v", err)
+	}
+	if len(requests) != 1 || !bytes.Equal(requests[0].body, payload) || requests[0].contentLength > 0 {
+		t.Errorf("client_test.go: expected a single request streamed with no Content-Length but got %d requests", len(requests))
+	}
+}
+
+/* Compare the memory used to upload a 20 MB payload that was marshalled
+   to bytes, the way create and update do, as a string and as a reader */
+func BenchmarkRequestBody(b *testing.B) {
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 20*1024*1024/16)
+	client, err := NewAPIClient(&apiClientOpt{
+		uri:     "http://fakeserver.invalid",
+		timeout: 10,
+		transport: handlerTransport{http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.Copy(io.Discard, r.Body)
+		})},
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("string", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(payload)))
+		for i := 0; i < b.N; i++ {
+			if _, err := client.sendRequest("POST", "/api/objects", string(payload)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("reader", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(payload)))
+		for i := 0; i < b.N; i++ {
+			if _, err := client.doRequestBody("POST", "/api/objects", bytes.NewReader(payload)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
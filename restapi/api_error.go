@@ -0,0 +1,265 @@
+package restapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/* Default number of characters of a response body to
+   include when rendering an APIError */
+const defaultErrorBodyLength = 512
+
+/*APIError describes a failed exchange with the API server. It carries
+  enough of the HTTP context (method, URL, status, request id and an
+  excerpt of the body) to identify which call failed. When the failure
+  happened after a response was received (such as a JSON decode error),
+  the underlying error is available via Unwrap */
+type APIError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	RequestID  string
+	Body       string
+
+	/* Populated from the server's error envelope, if present */
+	Code    string
+	Message string
+
+	/* Populated from a list of problems with particular fields
+	   ({"errors":[{"field":...,"message":...}]}), if present */
+	Violations []Violation
+
+	/* The underlying error, if any (transport or parsing failures) */
+	Err error
+
+	/* Whether the request was sent but got no response, because the
+	   connection failed or timed out. Errors raised before a request is
+	   sent (fetching an oauth token, an open circuit) leave this false */
+	NoResponse bool
+}
+
+func (e *APIError) Error() string {
+	var buffer bytes.Buffer
+	buffer.WriteString(fmt.Sprintf("%s %s", e.Method, e.URL))
+
+	if e.StatusCode != 0 && (e.StatusCode < 200 || e.StatusCode >= 300) {
+		buffer.WriteString(fmt.Sprintf(": unexpected response code '%d'", e.StatusCode))
+	}
+	if e.RequestID != "" {
+		buffer.WriteString(fmt.Sprintf(" (request id %s)", e.RequestID))
+	}
+	if e.Err != nil {
+		buffer.WriteString(fmt.Sprintf(": %v", e.Err))
+
+		/* Decode errors are much easier to chase down with a position */
+		var syntaxErr *json.SyntaxError
+		if errors.As(e.Err, &syntaxErr) {
+			buffer.WriteString(fmt.Sprintf(" (at offset %d)", syntaxErr.Offset))
+		}
+	}
+
+	if e.Message == "" && len(e.Violations) > 0 {
+		problems := make([]string, len(e.Violations))
+		for i, v := range e.Violations {
+			problems[i] = v.String()
+		}
+		buffer.WriteString(fmt.Sprintf(": %s", strings.Join(problems, "; ")))
+	} else if e.Message != "" {
+		if e.Code != "" {
+			buffer.WriteString(fmt.Sprintf(": [%s] %s", e.Code, e.Message))
+		} else {
+			buffer.WriteString(fmt.Sprintf(": %s", e.Message))
+		}
+	} else if e.Body != "" {
+		buffer.WriteString(fmt.Sprintf(": %s", e.Body))
+	} else if e.Err == nil {
+		buffer.WriteString(" (empty response body)")
+	}
+
+	return buffer.String()
+}
+
+/*Unwrap returns the underlying error, if any */
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+/* Build an APIError from the details of a request and (optionally) the
+   response that came back. The body is truncated to maxBody characters */
+func newAPIError(method string, url string, resp *http.Response, body string, maxBody int, err error) *APIError {
+	apiErr := &APIError{
+		Method: method,
+		URL:    url,
+		Body:   excerpt(body, maxBody),
+		Err:    err,
+	}
+
+	if resp != nil {
+		apiErr.StatusCode = resp.StatusCode
+		apiErr.RequestID = requestIDFromHeader(resp.Header)
+	}
+
+	if resp == nil || resp.Header.Get("Content-Type") == "" || isJSONContentType(resp.Header.Get("Content-Type")) {
+		apiErr.Code, apiErr.Message = parseErrorEnvelope(body)
+		apiErr.Violations = parseViolations(body)
+	}
+
+	return apiErr
+}
+
+/* Servers and gateways don't agree on what to call the request id header */
+func requestIDFromHeader(header http.Header) string {
+	for _, name := range []string{"X-Request-Id", "X-Correlation-Id", "X-Amzn-Requestid"} {
+		if v := header.Get(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+/* Parse the standard error envelope ({"error":{"code":...,"message":...}})
+   out of a response body. Both values are empty if the body does not
+   contain one. */
+func parseErrorEnvelope(body string) (code string, message string) {
+	trimmed := strings.TrimSpace(body)
+	if !strings.HasPrefix(trimmed, "{") {
+		return "", ""
+	}
+
+	var envelope struct {
+		Error *struct {
+			Code    interface{} `json:"code"`
+			Message string      `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(trimmed), &envelope); err != nil || envelope.Error == nil {
+		return "", ""
+	}
+
+	if envelope.Error.Code != nil {
+		code = fmt.Sprintf("%v", envelope.Error.Code)
+	}
+	return code, envelope.Error.Message
+}
+
+/*Violation is a problem the API found with one field of what was sent */
+type Violation struct {
+	Field   string
+	Message string
+}
+
+func (v Violation) String() string {
+	if v.Field == "" {
+		return v.Message
+	}
+	return fmt.Sprintf("%s: %s", v.Field, v.Message)
+}
+
+/* Parse a list of field violations ({"errors":[{"field":...,"message":...}]})
+   out of a response body. Entries with neither are skipped */
+func parseViolations(body string) []Violation {
+	trimmed := strings.TrimSpace(body)
+	if !strings.HasPrefix(trimmed, "{") {
+		return nil
+	}
+
+	var envelope struct {
+		Errors []struct {
+			Field   string `json:"field"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal([]byte(trimmed), &envelope); err != nil {
+		return nil
+	}
+
+	var violations []Violation
+	for _, e := range envelope.Errors {
+		if e.Field != "" || e.Message != "" {
+			violations = append(violations, Violation{Field: e.Field, Message: e.Message})
+		}
+	}
+	return violations
+}
+
+/* Shorten a string to at most max characters, noting that it was truncated */
+func excerpt(s string, max int) string {
+	if max <= 0 || len(s) <= max {
+		return s
+	}
+	return fmt.Sprintf("%s... (%d more bytes)", s[:max], len(s)-max)
+}
+
+/* A 401 this soon after obtaining a token means the token
+   itself was not accepted rather than having expired */
+const freshTokenWindow = 30 * time.Second
+
+/* Whether the API answered 404. This is the only failure that means
+   an object is gone - anything else (403 included) says nothing about
+   whether it exists */
+func isNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+/*UnauthorizedError is returned when the API responds with a 401. It
+  explains which credentials were in use so the user knows where to look */
+type UnauthorizedError struct {
+	*APIError
+	AuthMethod string
+
+	/* When oauth is in use, how long before the request the token was obtained */
+	TokenFetched bool
+	TokenAge     time.Duration
+
+	/* Whether the token was rejected as invalid_token, replaced with a
+	   new one from the token endpoint and the new one rejected too */
+	TokenRefreshed bool
+}
+
+func (e *UnauthorizedError) Error() string {
+	var buffer bytes.Buffer
+	buffer.WriteString(e.APIError.Error())
+	buffer.WriteString(fmt.Sprintf("; the API did not accept the credentials provided (auth method: %s)", e.AuthMethod))
+
+	switch {
+	case e.TokenRefreshed:
+		buffer.WriteString("; the API rejected the oauth token as invalid_token, and the new token obtained from the token endpoint to replace it was rejected too, so the token endpoint is issuing tokens the API does not accept - check oauth_scopes and endpoint_params")
+	case e.TokenFetched && e.TokenAge < freshTokenWindow:
+		buffer.WriteString(fmt.Sprintf("; a token was obtained from the oauth token endpoint %s before this request and was still rejected, which usually means the token was issued for a different audience or lacks the required scopes - check oauth_scopes and endpoint_params", e.TokenAge.Round(time.Millisecond)))
+	case e.TokenFetched:
+		buffer.WriteString(fmt.Sprintf("; the cached oauth token was obtained from the token endpoint %s before this request and may have been revoked - the API did not reject it as invalid_token, so it was not refreshed", e.TokenAge.Round(time.Second)))
+	case e.AuthMethod == authMethodNone:
+		buffer.WriteString("; no credentials are configured - set username/password, an Authorization header or oauth_client_credentials")
+	default:
+		buffer.WriteString("; the credentials may be wrong or expired")
+	}
+	return buffer.String()
+}
+
+/*Unwrap returns the underlying APIError */
+func (e *UnauthorizedError) Unwrap() error {
+	return e.APIError
+}
+
+/*ForbiddenError is returned when the API responds with a 403. The
+  credentials were accepted but do not grant access to the path */
+type ForbiddenError struct {
+	*APIError
+	AuthMethod string
+	Path       string
+}
+
+func (e *ForbiddenError) Error() string {
+	return fmt.Sprintf("%s; the credentials provided (auth method: %s) are not permitted to access '%s' - check that the API key or token has the scopes required for this path", e.APIError.Error(), e.AuthMethod, e.Path)
+}
+
+/*Unwrap returns the underlying APIError */
+func (e *ForbiddenError) Unwrap() error {
+	return e.APIError
+}
@@ -0,0 +1,270 @@
+package restapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Mastercard/terraform-provider-restapi/fakeserver"
+)
+
+func TestAPIErrorFormatting(t *testing.T) {
+	resp := &http.Response{
+		StatusCode: 422,
+		Header:     http.Header{"X-Request-Id": []string{"abc-123"}},
+	}
+
+	err := newAPIError("PUT", "http://127.0.0.1/api/objects/1", resp, "this body is far too long to be shown in full", 12, nil)
+	msg := err.Error()
+
+	for _, expected := range []string{"PUT http://127.0.0.1/api/objects/1", "unexpected response code '422'", "request id abc-123", "this body is... (33 more bytes)"} {
+		if !strings.Contains(msg, expected) {
+			t.Errorf("api_error_test.go: expected '%s' to contain '%s'", msg, expected)
+		}
+	}
+
+	/* Transport level failures have no status */
+	err = newAPIError("GET", "http://127.0.0.1/api/objects/1", nil, "", 12, errors.New("connection refused"))
+	if err.Error() != "GET http://127.0.0.1/api/objects/1: connection refused" {
+		t.Errorf("api_error_test.go: unexpected transport error message '%s'", err.Error())
+	}
+}
+
+func TestAPIErrorEnvelope(t *testing.T) {
+	cases := []struct {
+		body    string
+		code    string
+		message string
+	}{
+		{`{"error":{"code":"NOT_FOUND","message":"no such object"}}`, "NOT_FOUND", "no such object"},
+		{`{"error":{"code":404,"message":"no such object"}}`, "404", "no such object"},
+		{`{"error":{"message":"just a message"}}`, "", "just a message"},
+		{`{"error":"a plain string"}`, "", ""},
+		{`{"id":"1234"}`, "", ""},
+		{`<html>Bad Gateway</html>`, "", ""},
+		{``, "", ""},
+	}
+
+	for _, c := range cases {
+		code, message := parseErrorEnvelope(c.body)
+		if code != c.code || message != c.message {
+			t.Errorf("api_error_test.go: parsing '%s' - expected ('%s', '%s') but got ('%s', '%s')", c.body, c.code, c.message, code, message)
+		}
+	}
+
+	resp := &http.Response{StatusCode: 404, Header: http.Header{}}
+	err := newAPIError("GET", "http://127.0.0.1/api/objects/1", resp, cases[0].body, 512, nil)
+	if !strings.HasSuffix(err.Error(), ": [NOT_FOUND] no such object") {
+		t.Errorf("api_error_test.go: expected the envelope message in '%s'", err.Error())
+	}
+}
+
+func TestAPIErrorViolations(t *testing.T) {
+	body := `{"errors":[{"field":"spec.ports[1].name","message":"must be unique"},{"message":"quota exceeded"},{}]}`
+	expected := []Violation{
+		{Field: "spec.ports[1].name", Message: "must be unique"},
+		{Message: "quota exceeded"},
+	}
+	violations := parseViolations(body)
+	if len(violations) != len(expected) {
+		t.Fatalf("api_error_test.go: expected %v but got %v", expected, violations)
+	}
+	for i := range expected {
+		if violations[i] != expected[i] {
+			t.Errorf("api_error_test.go: expected %v but got %v", expected[i], violations[i])
+		}
+	}
+
+	for _, other := range []string{`{"errors":"a plain string"}`, `{"error":{"message":"no such object"}}`, `[]`, ``} {
+		if v := parseViolations(other); v != nil {
+			t.Errorf("api_error_test.go: expected no violations in '%s' but got %v", other, v)
+		}
+	}
+
+	resp := &http.Response{StatusCode: 422, Header: http.Header{}}
+	err := newAPIError("POST", "http://127.0.0.1/api/objects", resp, body, 512, nil)
+	if !strings.HasSuffix(err.Error(), ": spec.ports[1].name: must be unique; quota exceeded") {
+		t.Errorf("api_error_test.go: expected the violations to be listed in '%s'", err.Error())
+	}
+}
+
+func TestAPIErrorDecodeContext(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-Id", "req-42")
+		w.Write([]byte(`{"id": "1234", oops}`))
+	}))
+	defer svr.Close()
+
+	client, err := NewAPIClient(&apiClientOpt{
+		uri:         svr.URL,
+		timeout:     2,
+		idAttribute: "id",
+		headers:     make(map[string]string),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	obj, err := NewAPIObject(client, &apiObjectOpts{
+		path: "/api/objects",
+		id:   "1234",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = obj.readObject(context.Background())
+	if err == nil {
+		t.Fatal("api_error_test.go: expected a decode error reading malformed JSON")
+	}
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("api_error_test.go: expected an APIError but got %T: %s", err, err)
+	}
+	var syntaxErr *json.SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		t.Fatalf("api_error_test.go: expected the JSON syntax error to be wrapped, got: %s", err)
+	}
+	if apiErr.Method != "GET" || !strings.HasSuffix(apiErr.URL, "/api/objects/1234") || apiErr.RequestID != "req-42" {
+		t.Errorf("api_error_test.go: missing HTTP context in error: %s", err)
+	}
+	if !strings.Contains(err.Error(), "at offset") {
+		t.Errorf("api_error_test.go: expected the parse position in '%s'", err)
+	}
+}
+
+func TestAPIErrorAuthFailures(t *testing.T) {
+	svr := fakeserver.NewFakeServer(0, map[string]map[string]interface{}{}, false, false, "")
+	httpSvr := httptest.NewServer(svr.Handler())
+	defer httpSvr.Close()
+
+	/* Issues tokens that svr knows nothing about, as an identity
+	   provider would for the wrong audience */
+	issuer := fakeserver.NewFakeServer(0, map[string]map[string]interface{}{}, false, false, "")
+	issuer.SetAuth(fakeserver.Auth{ClientID: "id", ClientSecret: "secret"})
+	issuerSvr := httptest.NewServer(issuer.Handler())
+	defer issuerSvr.Close()
+
+	oauth := fakeserver.Auth{ClientID: "id", ClientSecret: "secret"}
+	oauthOpt := func(tokenURL string) *apiClientOpt {
+		return &apiClientOpt{oauthClientID: "id", oauthClientSecret: "secret", oauthTokenURL: tokenURL + "/oauth/token"}
+	}
+
+	cases := []struct {
+		name     string
+		auth     fakeserver.Auth
+		status   int
+		opt      *apiClientOpt
+		prepare  func(client *APIClient)
+		forbid   bool
+		expected []string
+	}{
+		{
+			name:     "no_credentials",
+			auth:     fakeserver.Auth{Username: "user", Password: "pass"},
+			opt:      &apiClientOpt{},
+			expected: []string{"auth method: none", "no credentials are configured"},
+		},
+		{
+			name:     "basic",
+			auth:     fakeserver.Auth{Username: "user", Password: "pass"},
+			opt:      &apiClientOpt{username: "user", password: "wrong"},
+			expected: []string{"auth method: basic auth", "wrong or expired"},
+		},
+		{
+			name:     "header",
+			auth:     fakeserver.Auth{BearerToken: "s3cret"},
+			opt:      &apiClientOpt{headers: map[string]string{"authorization": "Bearer guess"}},
+			expected: []string{"auth method: Authorization header", "wrong or expired"},
+		},
+		{
+			/* A 401 with no invalid_token challenge is not retried */
+			name:     "oauth_fresh_token",
+			auth:     oauth,
+			status:   http.StatusUnauthorized,
+			opt:      oauthOpt(httpSvr.URL),
+			expected: []string{"auth method: oauth client credentials", "different audience or lacks the required scopes"},
+		},
+		{
+			name:   "oauth_stale_token",
+			auth:   oauth,
+			status: http.StatusUnauthorized,
+			opt:    oauthOpt(httpSvr.URL),
+			prepare: func(client *APIClient) {
+				client.oauthTokenSource.now = func() time.Time { return time.Now().Add(-10 * time.Minute) }
+			},
+			expected: []string{"auth method: oauth client credentials", "obtained from the token endpoint 10m0s before this request and may have been revoked"},
+		},
+		{
+			name:     "oauth_refreshed_token",
+			auth:     oauth,
+			opt:      oauthOpt(issuerSvr.URL),
+			expected: []string{"auth method: oauth client credentials", "rejected too", "check oauth_scopes and endpoint_params"},
+		},
+		{
+			name:     "forbidden",
+			auth:     fakeserver.Auth{Username: "user", Password: "pass"},
+			status:   http.StatusForbidden,
+			opt:      &apiClientOpt{username: "user", password: "pass"},
+			forbid:   true,
+			expected: []string{"unexpected response code '403'", "not permitted to access '/api/objects'", "scopes"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			svr.SetAuth(c.auth)
+			svr.ResetRules()
+			if c.status != 0 {
+				svr.AddRule(&fakeserver.Rule{Path: "/api/objects", Status: c.status})
+			}
+
+			c.opt.uri = httpSvr.URL
+			c.opt.timeout = 2
+			client, err := NewAPIClient(c.opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.prepare != nil {
+				c.prepare(client)
+			}
+
+			_, err = client.sendRequest("GET", "/api/objects", "")
+			if err == nil {
+				t.Fatalf("api_error_test.go: expected an error from '/api/objects'")
+			}
+			for _, expected := range c.expected {
+				if !strings.Contains(err.Error(), expected) {
+					t.Errorf("api_error_test.go: expected '%s' to contain '%s'", err, expected)
+				}
+			}
+
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Errorf("api_error_test.go: expected the error to unwrap to an APIError")
+			}
+			if c.forbid {
+				var forbiddenErr *ForbiddenError
+				if !errors.As(err, &forbiddenErr) {
+					t.Errorf("api_error_test.go: expected a ForbiddenError but got %T", err)
+				}
+			} else {
+				var authErr *UnauthorizedError
+				if !errors.As(err, &authErr) {
+					t.Errorf("api_error_test.go: expected an UnauthorizedError but got %T", err)
+				}
+			}
+		})
+	}
+
+	/* The rejected token was replaced once, and no more */
+	if issuer.TokensIssued() != 2 {
+		t.Errorf("api_error_test.go: expected the issuer to hand out two tokens but saw %d", issuer.TokensIssued())
+	}
+}
@@ -0,0 +1,243 @@
+package restapi
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/* Upper bounds of the latency histogram buckets. Anything
+   slower lands in a final overflow bucket */
+var latencyBuckets = []time.Duration{
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+/* Status classes tracked by the metrics. Transport level
+   failures (no response at all) are counted separately */
+const (
+	statusClassError = iota
+	statusClass1xx
+	statusClass2xx
+	statusClass3xx
+	statusClass4xx
+	statusClass5xx
+)
+
+var statusClassNames = []string{"errors", "1xx", "2xx", "3xx", "4xx", "5xx"}
+
+/* Path segments that look like identifiers are collapsed
+   to {id} so requests are grouped by the kind of object */
+var idSegment = regexp.MustCompile(`^([0-9]+|[0-9a-fA-F-]{16,})$`)
+
+/*apiMetrics keeps counters about the requests an APIClient makes.
+  It is safe for concurrent use */
+type apiMetrics struct {
+	requests      int64
+	totalNanos    int64
+	bytesReceived int64
+	cacheHits     int64
+	cacheMisses   int64
+	readHits      int64
+	readMisses    int64
+	byClass       [6]int64
+	latency       [9]int64
+
+	mutex    sync.Mutex
+	byMethod map[string]int64
+	byPath   map[string]*pathMetrics
+
+	logOnce sync.Once
+}
+
+type pathMetrics struct {
+	count int64
+	total time.Duration
+	max   time.Duration
+}
+
+func newAPIMetrics() *apiMetrics {
+	return &apiMetrics{
+		byMethod: make(map[string]int64),
+		byPath:   make(map[string]*pathMetrics),
+	}
+}
+
+/* Record a completed request. A status of 0 means no response was received */
+func (m *apiMetrics) record(method string, path string, status int, elapsed time.Duration) {
+	atomic.AddInt64(&m.requests, 1)
+	atomic.AddInt64(&m.totalNanos, int64(elapsed))
+
+	class := statusClassError
+	if status >= 100 && status < 600 {
+		class = status / 100
+	}
+	atomic.AddInt64(&m.byClass[class], 1)
+
+	bucket := len(latencyBuckets)
+	for i, limit := range latencyBuckets {
+		if elapsed <= limit {
+			bucket = i
+			break
+		}
+	}
+	atomic.AddInt64(&m.latency[bucket], 1)
+
+	template := method + " " + pathTemplate(path)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.byMethod[method]++
+	p, ok := m.byPath[template]
+	if !ok {
+		p = &pathMetrics{}
+		m.byPath[template] = p
+	}
+	p.count++
+	p.total += elapsed
+	if elapsed > p.max {
+		p.max = elapsed
+	}
+}
+
+/* Count the size of a response body as it came over the wire */
+func (m *apiMetrics) received(n int) {
+	atomic.AddInt64(&m.bytesReceived, int64(n))
+}
+
+/* Count a conditional request answered from (or missing) the ETag cache */
+func (m *apiMetrics) cacheResult(hit bool) {
+	if hit {
+		atomic.AddInt64(&m.cacheHits, 1)
+	} else {
+		atomic.AddInt64(&m.cacheMisses, 1)
+	}
+}
+
+/* Count a read answered from (or missing) the read cache */
+func (m *apiMetrics) readCacheResult(hit bool) {
+	if hit {
+		atomic.AddInt64(&m.readHits, 1)
+	} else {
+		atomic.AddInt64(&m.readMisses, 1)
+	}
+}
+
+/* Total number of requests recorded */
+func (m *apiMetrics) total() int64 {
+	return atomic.LoadInt64(&m.requests)
+}
+
+/* Number of requests recorded for the given HTTP method */
+func (m *apiMetrics) methodCount(method string) int64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.byMethod[method]
+}
+
+/* Number of requests that came back with the given status class
+   (such as statusClass2xx) */
+func (m *apiMetrics) classCount(class int) int64 {
+	return atomic.LoadInt64(&m.byClass[class])
+}
+
+/* A single line describing all of the requests made */
+func (m *apiMetrics) summary() string {
+	var buffer bytes.Buffer
+	buffer.WriteString(fmt.Sprintf("%d requests in %s", m.total(), time.Duration(atomic.LoadInt64(&m.totalNanos)).Round(time.Millisecond)))
+
+	m.mutex.Lock()
+	methods := make([]string, 0, len(m.byMethod))
+	for method, count := range m.byMethod {
+		methods = append(methods, fmt.Sprintf("%s=%d", method, count))
+	}
+	m.mutex.Unlock()
+	sort.Strings(methods)
+	buffer.WriteString(fmt.Sprintf(" (%s)", strings.Join(methods, " ")))
+
+	classes := make([]string, 0, len(statusClassNames))
+	for class, name := range statusClassNames {
+		if count := m.classCount(class); count > 0 {
+			classes = append(classes, fmt.Sprintf("%s=%d", name, count))
+		}
+	}
+	buffer.WriteString(fmt.Sprintf(" status: %s", strings.Join(classes, " ")))
+
+	buckets := make([]string, 0, len(latencyBuckets)+1)
+	for i, limit := range latencyBuckets {
+		buckets = append(buckets, fmt.Sprintf("<=%s:%d", limit, atomic.LoadInt64(&m.latency[i])))
+	}
+	buckets = append(buckets, fmt.Sprintf(">%s:%d", latencyBuckets[len(latencyBuckets)-1], atomic.LoadInt64(&m.latency[len(latencyBuckets)])))
+	buffer.WriteString(fmt.Sprintf(" latency: %s", strings.Join(buckets, " ")))
+	buffer.WriteString(fmt.Sprintf(" received: %d bytes", atomic.LoadInt64(&m.bytesReceived)))
+
+	hits, misses := atomic.LoadInt64(&m.cacheHits), atomic.LoadInt64(&m.cacheMisses)
+	if hits+misses > 0 {
+		buffer.WriteString(fmt.Sprintf(" cache: hits=%d misses=%d", hits, misses))
+	}
+
+	readHits, readMisses := atomic.LoadInt64(&m.readHits), atomic.LoadInt64(&m.readMisses)
+	if readHits+readMisses > 0 {
+		buffer.WriteString(fmt.Sprintf(" read_cache: hits=%d misses=%d", readHits, readMisses))
+	}
+
+	return buffer.String()
+}
+
+/* One line per method and path template, busiest first */
+func (m *apiMetrics) breakdown() []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	templates := make([]string, 0, len(m.byPath))
+	for template := range m.byPath {
+		templates = append(templates, template)
+	}
+	sort.Slice(templates, func(i, j int) bool {
+		if m.byPath[templates[i]].count != m.byPath[templates[j]].count {
+			return m.byPath[templates[i]].count > m.byPath[templates[j]].count
+		}
+		return templates[i] < templates[j]
+	})
+
+	lines := make([]string, 0, len(templates))
+	for _, template := range templates {
+		p := m.byPath[template]
+		avg := p.total / time.Duration(p.count)
+		lines = append(lines, fmt.Sprintf("%s: %d requests, avg %s, max %s", template, p.count, avg.Round(time.Millisecond), p.max.Round(time.Millisecond)))
+	}
+	return lines
+}
+
+/* Emit the summary at INFO and the breakdown at TRACE.
+   This only happens once per set of metrics */
+func (m *apiMetrics) logSummary() {
+	m.logOnce.Do(func() {
+		log.Printf("[INFO] api_client.go: API call summary: %s", m.summary())
+		for _, line := range m.breakdown() {
+			log.Printf("[TRACE] api_client.go:   %s", line)
+		}
+	})
+}
+
+/* Strip the query string and collapse id-like path segments */
+func pathTemplate(path string) string {
+	parts := strings.Split(stripQuery(path), "/")
+	for i, part := range parts {
+		if idSegment.MatchString(part) {
+			parts[i] = "{id}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
@@ -0,0 +1,85 @@
+package restapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPathTemplate(t *testing.T) {
+	cases := map[string]string{
+		"/api/objects":              "/api/objects",
+		"/api/objects/1234":         "/api/objects/{id}",
+		"/api/objects/1234?foo=bar": "/api/objects/{id}",
+		"/api/v1/things/5c3b9e4a-1a2b-4c3d-8e9f-0a1b2c3d4e5f": "/api/v1/things/{id}",
+		"/api/objects/name": "/api/objects/name",
+	}
+	for path, expected := range cases {
+		if res := pathTemplate(path); res != expected {
+			t.Errorf("api_metrics_test.go: expected '%s' to become '%s' but got '%s'", path, expected, res)
+		}
+	}
+}
+
+func TestAPIMetrics(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+
+	client, err := NewAPIClient(&apiClientOpt{
+		uri:     svr.URL,
+		timeout: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client.sendRequest("GET", "/api/objects/1", "")
+	client.sendRequest("GET", "/api/objects/2", "")
+	client.sendRequest("PUT", "/api/objects/2", `{}`)
+	client.sendRequest("GET", "/missing", "")
+
+	/* Transport failures are counted too */
+	svr.Close()
+	client.sendRequest("DELETE", "/api/objects/2", "")
+
+	m := client.metrics
+	if m.total() != 5 {
+		t.Errorf("api_metrics_test.go: expected 5 requests but got %d", m.total())
+	}
+	if m.methodCount("GET") != 3 || m.methodCount("PUT") != 1 || m.methodCount("DELETE") != 1 {
+		t.Errorf("api_metrics_test.go: unexpected per-method counts: %s", m.summary())
+	}
+	if m.classCount(statusClass2xx) != 3 || m.classCount(statusClass4xx) != 1 || m.classCount(statusClassError) != 1 {
+		t.Errorf("api_metrics_test.go: unexpected per-class counts: %s", m.summary())
+	}
+
+	summary := m.summary()
+	for _, expected := range []string{"5 requests", "GET=3", "2xx=3", "4xx=1", "errors=1", "<=50ms:"} {
+		if !strings.Contains(summary, expected) {
+			t.Errorf("api_metrics_test.go: expected summary '%s' to contain '%s'", summary, expected)
+		}
+	}
+
+	breakdown := m.breakdown()
+	if len(breakdown) != 4 || !strings.HasPrefix(breakdown[0], "GET /api/objects/{id}: 2 requests") {
+		t.Errorf("api_metrics_test.go: unexpected breakdown: %v", breakdown)
+	}
+}
+
+func TestAPIMetricsHistogram(t *testing.T) {
+	m := newAPIMetrics()
+	m.record("GET", "/a", 200, 10*time.Millisecond)
+	m.record("GET", "/a", 200, 300*time.Millisecond)
+	m.record("GET", "/a", 200, time.Minute)
+
+	if m.latency[0] != 1 || m.latency[3] != 1 || m.latency[len(latencyBuckets)] != 1 {
+		t.Errorf("api_metrics_test.go: unexpected histogram: %v", m.latency)
+	}
+}
@@ -2,10 +2,15 @@ package restapi
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"reflect"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/davecgh/go-spew/spew"
@@ -28,6 +33,17 @@ type apiObjectOpts struct {
 	id            string
 	idAttribute   string
 	data          string
+	destroyData   string
+
+	createQueryString  string
+	readQueryString    string
+	updateQueryString  string
+	destroyQueryString string
+
+	createResponseIDAttribute string
+
+	maxResponseBytes int64
+	cachedReads      bool
 }
 
 /*APIObject is the state holding struct for a restapi_object resource*/
@@ -47,6 +63,19 @@ type APIObject struct {
 	readSearch    map[string]string
 	id            string
 	idAttribute   string
+	destroyData   string
+
+	createQueryString  string
+	readQueryString    string
+	updateQueryString  string
+	destroyQueryString string
+
+	createResponseIDAttribute string
+
+	maxResponseBytes int64
+
+	/* Whether reads may be answered from the read cache */
+	cachedReads bool
 
 	/* Set internally */
 	data        map[string]interface{} /* Data as managed by the user */
@@ -82,6 +111,20 @@ func NewAPIObject(iClient *APIClient, opts *apiObjectOpts) (*APIObject, error) {
 		opts.destroyMethod = iClient.destroyMethod
 	}
 
+	/* Each operation uses query_string unless given one of its own */
+	if opts.createQueryString == "" {
+		opts.createQueryString = opts.queryString
+	}
+	if opts.readQueryString == "" {
+		opts.readQueryString = opts.queryString
+	}
+	if opts.updateQueryString == "" {
+		opts.updateQueryString = opts.queryString
+	}
+	if opts.destroyQueryString == "" {
+		opts.destroyQueryString = opts.queryString
+	}
+
 	if opts.postPath == "" {
 		opts.postPath = opts.path
 	}
@@ -114,8 +157,19 @@ func NewAPIObject(iClient *APIClient, opts *apiObjectOpts) (*APIObject, error) {
 		readSearch:    opts.readSearch,
 		id:            opts.id,
 		idAttribute:   opts.idAttribute,
+		destroyData:   opts.destroyData,
 		data:          make(map[string]interface{}),
 		apiData:       make(map[string]interface{}),
+
+		createQueryString:  opts.createQueryString,
+		readQueryString:    opts.readQueryString,
+		updateQueryString:  opts.updateQueryString,
+		destroyQueryString: opts.destroyQueryString,
+
+		createResponseIDAttribute: opts.createResponseIDAttribute,
+
+		maxResponseBytes: opts.maxResponseBytes,
+		cachedReads:      opts.cachedReads,
 	}
 
 	if opts.data != "" {
@@ -123,7 +177,7 @@ func NewAPIObject(iClient *APIClient, opts *apiObjectOpts) (*APIObject, error) {
 			log.Printf("api_object.go: Parsing data: '%s'", opts.data)
 		}
 
-		err := json.Unmarshal([]byte(opts.data), &obj.data)
+		err := decodeJSON(opts.data, &obj.data)
 		if err != nil {
 			return &obj, fmt.Errorf("api_object.go: error parsing data provided: %v", err.Error())
 		}
@@ -162,6 +216,10 @@ func (obj *APIObject) toString() string {
 	buffer.WriteString(fmt.Sprintf("put_path: %s\n", obj.putPath))
 	buffer.WriteString(fmt.Sprintf("delete_path: %s\n", obj.deletePath))
 	buffer.WriteString(fmt.Sprintf("query_string: %s\n", obj.queryString))
+	buffer.WriteString(fmt.Sprintf("create_query_string: %s\n", obj.createQueryString))
+	buffer.WriteString(fmt.Sprintf("read_query_string: %s\n", obj.readQueryString))
+	buffer.WriteString(fmt.Sprintf("update_query_string: %s\n", obj.updateQueryString))
+	buffer.WriteString(fmt.Sprintf("destroy_query_string: %s\n", obj.destroyQueryString))
 	buffer.WriteString(fmt.Sprintf("create_method: %s\n", obj.createMethod))
 	buffer.WriteString(fmt.Sprintf("read_method: %s\n", obj.readMethod))
 	buffer.WriteString(fmt.Sprintf("update_method: %s\n", obj.updateMethod))
@@ -181,12 +239,9 @@ func (obj *APIObject) updateState(state string) error {
 		log.Printf("api_object.go: Updating API object state to '%s'\n", state)
 	}
 
-	/* Other option - Decode as JSON Numbers instead of golang datatypes
-	d := json.NewDecoder(strings.NewReader(res_str))
-	d.UseNumber()
-	err = d.Decode(&obj.api_data)
-	*/
-	err := json.Unmarshal([]byte(state), &obj.apiData)
+	/* Decode as JSON Numbers instead of golang datatypes so
+	   numbers are not mangled on their way back to the API */
+	err := decodeJSON(state, &obj.apiData)
 	if err != nil {
 		return err
 	}
@@ -209,10 +264,21 @@ func (obj *APIObject) updateState(state string) error {
 	/* Any keys that come from the data we want to copy are done here */
 	if len(obj.apiClient.copyKeys) > 0 {
 		for _, key := range obj.apiClient.copyKeys {
+			path := normalizeKeyPath(obj.apiData, key)
+			val, err := GetObjectAtKey(obj.apiData, path, obj.debug)
+			if err != nil {
+				/* Nothing to copy - don't send a null in its place */
+				if obj.debug {
+					log.Printf("api_object.go: Not copying key '%s' as it is not in api_data: %s\n", key, err)
+				}
+				continue
+			}
 			if obj.debug {
-				log.Printf("api_object.go: Copying key '%s' from api_data (%v) to data (%v)\n", key, obj.apiData[key], obj.data[key])
+				log.Printf("api_object.go: Copying key '%s' from api_data (%v) to data\n", key, val)
+			}
+			if err := SetObjectAtKey(obj.data, path, val); err != nil {
+				return fmt.Errorf("api_object.go: Error copying key '%s': %s", key, err)
 			}
-			obj.data[key] = obj.apiData[key]
 		}
 	} else if obj.debug {
 		log.Printf("api_object.go: copy_keys is empty - not attempting to copy data")
@@ -224,37 +290,48 @@ func (obj *APIObject) updateState(state string) error {
 	return err
 }
 
-func (obj *APIObject) createObject() error {
+func (obj *APIObject) createObject(ctx context.Context) error {
 	/* Failsafe: The constructor should prevent this situation, but
 	   protect here also. If no id is set, and the API does not respond
 	   with the id of whatever gets created, we have no way to know what
 	   the object's id will be. Abandon this attempt */
-	if obj.id == "" && !obj.apiClient.writeReturnsObject && !obj.apiClient.createReturnsObject {
-		return fmt.Errorf("provided object does not have an id set and the client is not configured to read the object from a POST or PUT response; please set write_returns_object to true, or include an id in the object's data")
+	if obj.id == "" && !obj.apiClient.writeReturnsObject && !obj.apiClient.createReturnsObject && obj.createResponseIDAttribute == "" && !obj.hasReadSearch() {
+		return fmt.Errorf("provided object does not have an id set and the client is not configured to read the object from a POST or PUT response; please set write_returns_object to true, configure read_search, or include an id in the object's data")
 	}
 
 	b, _ := json.Marshal(obj.data)
 
 	postPath := obj.postPath
-	if obj.queryString != "" {
+	if obj.createQueryString != "" {
 		if obj.debug {
-			log.Printf("api_object.go: Adding query string '%s'", obj.queryString)
+			log.Printf("api_object.go: Adding query string '%s'", obj.createQueryString)
 		}
-		postPath = fmt.Sprintf("%s?%s", obj.postPath, obj.queryString)
+		postPath = appendQueryString(obj.postPath, obj.createQueryString)
 	}
 
-	resultString, err := obj.apiClient.sendRequest(obj.createMethod, strings.Replace(postPath, "{id}", obj.id, -1), string(b))
+	resp, err := obj.apiClient.doRequestBody(obj.createMethod, strings.Replace(postPath, "{id}", obj.id, -1), bytes.NewReader(b), withContext(ctx))
 	if err != nil {
 		return err
 	}
 
-	/* We will need to sync state as well as get the object's ID */
-	if obj.apiClient.writeReturnsObject || obj.apiClient.createReturnsObject {
+	returnsObject := obj.apiClient.writeReturnsObject || obj.apiClient.createReturnsObject
+	if obj.id == "" && (returnsObject || obj.createResponseIDAttribute != "") {
+		id, err := obj.idFromCreateResponse(resp)
+		if err != nil && !obj.hasReadSearch() {
+			return obj.apiClient.responseError(resp, err)
+		}
+		obj.id = id
+	}
+
+	/* We will need to sync state as well as get the object's ID. If the
+	   object is wrapped in the response or was not returned at all, only
+	   the ID can be taken from it and the object must be read back */
+	if returnsObject && obj.createResponseIDAttribute == "" && strings.TrimSpace(resp.body) != "" && obj.id != "" {
 		if obj.debug {
 			log.Printf("api_object.go: Parsing response from POST to update internal structures (write_returns_object=%t, create_returns_object=%t)...\n",
 				obj.apiClient.writeReturnsObject, obj.apiClient.createReturnsObject)
 		}
-		err = obj.updateState(resultString)
+		err = obj.apiClient.responseError(resp, obj.updateState(resp.body))
 		/* Yet another failsafe. In case something terrible went wrong internally,
 		   bail out so the user at least knows that the ID did not get set. */
 		if obj.id == "" {
@@ -265,62 +342,139 @@ func (obj *APIObject) createObject() error {
 			log.Printf("api_object.go: Requesting created object from API (write_returns_object=%t, create_returns_object=%t)...\n",
 				obj.apiClient.writeReturnsObject, obj.apiClient.createReturnsObject)
 		}
-		err = obj.readObject()
+		err = obj.readObject(ctx)
+		if err == nil && obj.id == "" {
+			err = fmt.Errorf("the object was created, but could not be found afterwards with read_search or at its read_path")
+		}
 	}
 	return err
 }
 
-func (obj *APIObject) readObject() error {
-	if obj.id == "" {
-		return fmt.Errorf("cannot read an object unless the ID has been set")
+/* Work out the id of a newly created object from the response to
+   creating it. The id is looked for at create_response_id_attribute
+   (or id_attribute) in the body, then as the last path segment of the
+   Location header */
+func (obj *APIObject) idFromCreateResponse(resp *apiResponse) (string, error) {
+	attribute := obj.createResponseIDAttribute
+	if attribute == "" {
+		attribute = obj.idAttribute
 	}
 
-	getPath := obj.getPath
-	if obj.queryString != "" {
-		if obj.debug {
-			log.Printf("api_object.go: Adding query string '%s'", obj.queryString)
+	var tried []string
+	if strings.TrimSpace(resp.body) == "" {
+		tried = append(tried, "the response body (it was empty)")
+	} else {
+		var data map[string]interface{}
+		if err := decodeJSON(resp.body, &data); err != nil {
+			tried = append(tried, fmt.Sprintf("the response body (it is not a JSON object: %s)", err))
+		} else if id, err := GetStringAtKey(data, attribute, obj.debug); err != nil || id == "" {
+			tried = append(tried, fmt.Sprintf("'%s' in the response body (it was not found)", attribute))
+		} else {
+			return id, nil
 		}
-		getPath = fmt.Sprintf("%s?%s", obj.getPath, obj.queryString)
 	}
 
-	resultString, err := obj.apiClient.sendRequest(obj.readMethod, strings.Replace(getPath, "{id}", obj.id, -1), "")
-	if err != nil {
-		if strings.Contains(err.Error(), "Unexpected response code '404'") {
-			log.Printf("api_object.go: 404 error while refreshing state for '%s' at path '%s'. Removing from state.", obj.id, obj.getPath)
-			obj.id = ""
-			return nil
+	location := resp.header.Get("Location")
+	if location == "" {
+		tried = append(tried, "the Location header (it was not set)")
+	} else if id := lastPathSegment(location); id == "" {
+		tried = append(tried, fmt.Sprintf("the Location header (could not find an id in '%s')", location))
+	} else {
+		if obj.debug {
+			log.Printf("api_object.go: Using id '%s' from the Location header '%s'", id, location)
 		}
-		return err
+		return id, nil
 	}
 
-	searchKey := obj.readSearch["search_key"]
-	searchValue := obj.readSearch["search_value"]
+	return "", fmt.Errorf("api_object.go: Could not determine the id of the created object; tried %s", strings.Join(tried, ", "))
+}
+
+/* The final segment of the path in a URL, such as 123 in /things/123 */
+func lastPathSegment(location string) string {
+	u, err := url.Parse(location)
+	if err != nil {
+		return ""
+	}
+	path := strings.TrimRight(u.Path, "/")
+	return path[strings.LastIndex(path, "/")+1:]
+}
 
-	if searchKey != "" && searchValue != "" {
+/* Whether read_search is configured to locate this object */
+func (obj *APIObject) hasReadSearch() bool {
+	return obj.readSearch["search_key"] != "" && obj.readSearch["search_value"] != ""
+}
 
-		obj.searchPath = strings.Replace(obj.getPath, "{id}", obj.id, -1)
+func (obj *APIObject) readObject(ctx context.Context) error {
+	search := obj.hasReadSearch()
+	if obj.id == "" && !search {
+		return fmt.Errorf("cannot read an object unless the ID has been set")
+	}
 
-		queryString := obj.readSearch["query_string"]
-		if obj.queryString != "" {
+	/* With an explicit search_path (or no id to read with), the
+	   search is all there is to do */
+	searchPath := obj.readSearch["search_path"]
+	if !search || (searchPath == "" && obj.id != "") {
+		getPath := obj.getPath
+		if obj.readQueryString != "" {
 			if obj.debug {
-				log.Printf("api_object.go: Adding query string '%s'", obj.queryString)
+				log.Printf("api_object.go: Adding query string '%s'", obj.readQueryString)
 			}
-			queryString = fmt.Sprintf("%s&%s", obj.readSearch["query_string"], obj.queryString)
+			getPath = appendQueryString(obj.getPath, obj.readQueryString)
 		}
-		resultsKey := obj.readSearch["results_key"]
-		objFound, err := obj.findObject(queryString, searchKey, searchValue, resultsKey)
+
+		resp, err := obj.apiClient.doRequest(obj.readMethod, strings.Replace(getPath, "{id}", obj.id, -1), "", withContext(ctx), withMaxResponseBytes(obj.maxResponseBytes), withCachedRead(obj.cachedReads))
 		if err != nil {
+			if isNotFound(err) {
+				log.Printf("api_object.go: 404 error while refreshing state for '%s' at path '%s'. Removing from state.", obj.id, obj.getPath)
+				obj.id = ""
+				return nil
+			}
+			return err
+		}
+
+		if !search {
+			return obj.apiClient.responseError(resp, obj.updateState(resp.body))
+		}
+		searchPath = obj.getPath
+	}
+
+	if searchPath == "" {
+		searchPath = obj.searchPath
+	}
+	if strings.Contains(searchPath, "{id}") {
+		if obj.id == "" {
+			return fmt.Errorf("read_search search_path '%s' uses {id}, but the id of the object is not known yet", searchPath)
+		}
+		searchPath = strings.Replace(searchPath, "{id}", obj.id, -1)
+	}
+	obj.searchPath = searchPath
+
+	queryString := obj.readSearch["query_string"]
+	if obj.readQueryString != "" {
+		if obj.debug {
+			log.Printf("api_object.go: Adding query string '%s'", obj.readQueryString)
+		}
+		if queryString != "" {
+			queryString = fmt.Sprintf("%s&%s", queryString, obj.readQueryString)
+		} else {
+			queryString = obj.readQueryString
+		}
+	}
+	resultsKey := obj.readSearch["results_key"]
+	objFound, err := obj.findObject(ctx, queryString, obj.readSearch["search_key"], obj.readSearch["search_value"], resultsKey)
+	if err != nil {
+		if _, ok := err.(*searchNotFoundError); ok {
+			log.Printf("api_object.go: %s. Removing from state.", err)
 			obj.id = ""
 			return nil
 		}
-		objFoundString, _ := json.Marshal(objFound)
-		return obj.updateState(string(objFoundString))
+		return err
 	}
-
-	return obj.updateState(resultString)
+	objFoundString, _ := json.Marshal(objFound)
+	return obj.updateState(string(objFoundString))
 }
 
-func (obj *APIObject) updateObject() error {
+func (obj *APIObject) updateObject(ctx context.Context) error {
 	if obj.id == "" {
 		return fmt.Errorf("cannot update an object unless the ID has been set")
 	}
@@ -328,47 +482,85 @@ func (obj *APIObject) updateObject() error {
 	b, _ := json.Marshal(obj.data)
 
 	putPath := obj.putPath
-	if obj.queryString != "" {
+	if obj.updateQueryString != "" {
 		if obj.debug {
-			log.Printf("api_object.go: Adding query string '%s'", obj.queryString)
+			log.Printf("api_object.go: Adding query string '%s'", obj.updateQueryString)
 		}
-		putPath = fmt.Sprintf("%s?%s", obj.putPath, obj.queryString)
+		putPath = appendQueryString(obj.putPath, obj.updateQueryString)
 	}
 
-	resultString, err := obj.apiClient.sendRequest(obj.updateMethod, strings.Replace(putPath, "{id}", obj.id, -1), string(b))
+	resp, err := obj.apiClient.doRequestBody(obj.updateMethod, strings.Replace(putPath, "{id}", obj.id, -1), bytes.NewReader(b), withContext(ctx))
 	if err != nil {
-		return err
+		return obj.explainWriteError(ctx, err)
 	}
 
 	if obj.apiClient.writeReturnsObject {
 		if obj.debug {
 			log.Printf("api_object.go: Parsing response from PUT to update internal structures (write_returns_object=true)...\n")
 		}
-		err = obj.updateState(resultString)
+		err = obj.apiClient.responseError(resp, obj.updateState(resp.body))
 	} else {
 		if obj.debug {
 			log.Printf("api_object.go: Requesting updated object from API (write_returns_object=false)...\n")
 		}
-		err = obj.readObject()
+		err = obj.readObject(ctx)
 	}
 	return err
 }
 
-func (obj *APIObject) deleteObject() error {
+/* With verbose_errors, a write the API rejected because of what was
+   sent gets a diff of the object on the server against the data sent.
+   The object is read again unless it was read for this update already */
+func (obj *APIObject) explainWriteError(ctx context.Context, err error) error {
+	var apiErr *APIError
+	if !obj.apiClient.verboseErrors || !errors.As(err, &apiErr) {
+		return err
+	}
+	if apiErr.StatusCode < 400 || apiErr.StatusCode >= 500 || apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden {
+		return err
+	}
+	if sensitive, _ := strconv.ParseBool(GetEnvOrDefault("API_DATA_IS_SENSITIVE", "false")); sensitive {
+		return err
+	}
+
+	server := obj.apiData
+	if len(server) == 0 {
+		getPath := obj.getPath
+		if obj.readQueryString != "" {
+			getPath = appendQueryString(obj.getPath, obj.readQueryString)
+		}
+		body, readErr := obj.apiClient.sendRequest(obj.readMethod, strings.Replace(getPath, "{id}", obj.id, -1), "", withContext(ctx), withMaxResponseBytes(obj.maxResponseBytes))
+		if readErr == nil {
+			readErr = decodeJSON(body, &server)
+		}
+		if readErr != nil {
+			log.Printf("api_object.go: Could not read '%s' to compare with the rejected update: %s", obj.id, readErr)
+			return err
+		}
+	}
+
+	diff := jsonDiff("server", server, "sent", obj.data, obj.apiClient.verboseErrorLines)
+	if diff == "" {
+		return fmt.Errorf("%w\n\nThe data sent is the same as the object on the server", err)
+	}
+	return fmt.Errorf("%w\n\nThe data sent differs from the object on the server:\n%s", err, diff)
+}
+
+func (obj *APIObject) deleteObject(ctx context.Context) error {
 	if obj.id == "" {
 		log.Printf("WARNING: Attempting to delete an object that has no id set. Assuming this is OK.\n")
 		return nil
 	}
 
 	deletePath := obj.deletePath
-	if obj.queryString != "" {
+	if obj.destroyQueryString != "" {
 		if obj.debug {
-			log.Printf("api_object.go: Adding query string '%s'", obj.queryString)
+			log.Printf("api_object.go: Adding query string '%s'", obj.destroyQueryString)
 		}
-		deletePath = fmt.Sprintf("%s?%s", obj.deletePath, obj.queryString)
+		deletePath = appendQueryString(obj.deletePath, obj.destroyQueryString)
 	}
 
-	_, err := obj.apiClient.sendRequest(obj.destroyMethod, strings.Replace(deletePath, "{id}", obj.id, -1), "")
+	_, err := obj.apiClient.sendRequest(obj.destroyMethod, strings.Replace(deletePath, "{id}", obj.id, -1), obj.destroyData, withContext(ctx))
 	if err != nil {
 		return err
 	}
@@ -376,10 +568,19 @@ func (obj *APIObject) deleteObject() error {
 	return nil
 }
 
-func (obj *APIObject) findObject(queryString string, searchKey string, searchValue string, resultsKey string) (map[string]interface{}, error) {
+/*searchNotFoundError means a search completed but nothing matched */
+type searchNotFoundError struct {
+	key   string
+	value string
+	path  string
+}
+
+func (e *searchNotFoundError) Error() string {
+	return fmt.Sprintf("failed to find an object with the '%s' key = '%s' at %s", e.key, e.value, e.path)
+}
+
+func (obj *APIObject) findObject(ctx context.Context, queryString string, searchKey string, searchValue string, resultsKey string) (map[string]interface{}, error) {
 	var objFound map[string]interface{}
-	var dataArray []interface{}
-	var ok bool
 
 	/*
 	   Issue a GET to the base path and expect results to come back
@@ -389,63 +590,25 @@ func (obj *APIObject) findObject(queryString string, searchKey string, searchVal
 		if obj.debug {
 			log.Printf("api_object.go: Adding query string '%s'", queryString)
 		}
-		searchPath = fmt.Sprintf("%s?%s", obj.searchPath, queryString)
+		searchPath = appendQueryString(obj.searchPath, queryString)
 	}
 
 	if obj.debug {
 		log.Printf("api_object.go: Calling API on path '%s'", searchPath)
 	}
-	resultString, err := obj.apiClient.sendRequest(obj.apiClient.readMethod, searchPath, "")
-	if err != nil {
-		return objFound, err
-	}
-
-	/*
-	   Parse it seeking JSON data
-	*/
-	if obj.debug {
-		log.Printf("api_object.go: Response received... parsing")
-	}
-	var result interface{}
-	err = json.Unmarshal([]byte(resultString), &result)
-	if err != nil {
-		return objFound, err
-	}
-
-	if resultsKey != "" {
-		var tmp interface{}
-
-		if obj.debug {
-			log.Printf("api_object.go: Locating '%s' in the results", resultsKey)
-		}
-
-		/* First verify the data we got back is a hash */
-		if _, ok = result.(map[string]interface{}); !ok {
-			return objFound, fmt.Errorf("api_object.go: The results of a GET to '%s' did not return a hash. Cannot search within for results_key '%s'", searchPath, resultsKey)
-		}
-
-		tmp, err = GetObjectAtKey(result.(map[string]interface{}), resultsKey, obj.debug)
-		if err != nil {
-			return objFound, fmt.Errorf("api_object.go: Error finding results_key: %s", err)
-		}
-		if dataArray, ok = tmp.([]interface{}); !ok {
-			return objFound, fmt.Errorf("api_object.go: The data at results_key location '%s' is not an array. It is a '%s'", resultsKey, reflect.TypeOf(tmp))
-		}
-	} else {
-		if obj.debug {
-			log.Printf("api_object.go: results_key is not set - coaxing data to array of interfaces")
-		}
-		if dataArray, ok = result.([]interface{}); !ok {
-			return objFound, fmt.Errorf("api_object.go: The results of a GET to '%s' did not return an array. It is a '%s'. Perhaps you meant to add a results_key?", searchPath, reflect.TypeOf(result))
-		}
-	}
 
-	/* Loop through all of the results seeking the specific record */
-	for _, item := range dataArray {
+	/* Loop through all of the results seeking the specific record.
+	   Every page is checked so a search_key that is not unique is
+	   caught rather than silently picking one of the objects. Only
+	   the matches are kept, so only they count toward the size limit */
+	var ids []string
+	retained := obj.apiClient.newRetainedBytes(obj.maxResponseBytes, searchPath)
+	err := obj.apiClient.eachItem(searchPath, resultsKey, func(item interface{}, size int64) (bool, error) {
 		var hash map[string]interface{}
+		var ok bool
 
 		if hash, ok = item.(map[string]interface{}); !ok {
-			return objFound, fmt.Errorf("api_object.go: The elements being searched for data are not a map of key value pairs")
+			return false, fmt.Errorf("api_object.go: The elements being searched for data are not a map of key value pairs")
 		}
 
 		if obj.debug {
@@ -455,31 +618,52 @@ func (obj *APIObject) findObject(queryString string, searchKey string, searchVal
 
 		tmp, err := GetStringAtKey(hash, searchKey, obj.debug)
 		if err != nil {
-			return objFound, (fmt.Errorf("failed to get the value of '%s' in the results array at '%s': %s", searchKey, resultsKey, err))
+			return false, (fmt.Errorf("failed to get the value of '%s' in the results array at '%s': %s", searchKey, resultsKey, err))
 		}
 
 		/* We found our record */
 		if tmp == searchValue {
-			objFound = hash
-			obj.id, err = GetStringAtKey(hash, obj.idAttribute, obj.debug)
+			id, err := GetStringAtKey(hash, obj.idAttribute, obj.debug)
 			if err != nil {
-				return objFound, (fmt.Errorf("failed to find id_attribute '%s' in the record: %s", obj.idAttribute, err))
+				return false, (fmt.Errorf("failed to find id_attribute '%s' in the record: %s", obj.idAttribute, err))
 			}
 
 			if obj.debug {
-				log.Printf("api_object.go: Found ID '%s'", obj.id)
+				log.Printf("api_object.go: Found ID '%s'", id)
 			}
 
 			/* But there is no id attribute??? */
-			if obj.id == "" {
-				return objFound, (fmt.Errorf(fmt.Sprintf("The object for '%s'='%s' did not have the id attribute '%s', or the value was empty.", searchKey, searchValue, obj.idAttribute)))
+			if id == "" {
+				return false, fmt.Errorf("The object for '%s'='%s' did not have the id attribute '%s', or the value was empty.", searchKey, searchValue, obj.idAttribute)
 			}
-			break
+			if err := retained.add(size); err != nil {
+				return false, err
+			}
+			objFound = hash
+			ids = append(ids, id)
 		}
-	}
-
-	if obj.id == "" {
-		return objFound, (fmt.Errorf("failed to find an object with the '%s' key = '%s' at %s", searchKey, searchValue, searchPath))
+		return false, nil
+	}, withContext(ctx), withMaxResponseBytes(obj.maxResponseBytes), withCachedRead(obj.cachedReads))
+	if pages, ok := err.(*maxPagesError); ok {
+		/* Not finding it in part of the list is not the same as it being gone */
+		if len(ids) == 0 {
+			return nil, fmt.Errorf("no object with the '%s' key = '%s' in the first %d pages at %s; raise max_pages to search further: %w", searchKey, searchValue, pages.maxPages, searchPath, err)
+		}
+		if len(ids) == 1 {
+			log.Printf("api_object.go: WARNING: %s - could not check that '%s' = '%s' is unique", err, searchKey, searchValue)
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	switch len(ids) {
+	case 0:
+		return nil, &searchNotFoundError{key: searchKey, value: searchValue, path: searchPath}
+	case 1:
+		obj.id = ids[0]
+	default:
+		sort.Strings(ids)
+		return nil, fmt.Errorf("found %d objects with the '%s' key = '%s' at %s (ids: %s); search_key must identify a single object", len(ids), searchKey, searchValue, searchPath, strings.Join(ids, ", "))
 	}
 
 	return objFound, nil
@@ -1,9 +1,13 @@
 package restapi
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 
@@ -180,7 +184,7 @@ func TestAPIObject(t *testing.T) {
 				if testDebug {
 					log.Printf("api_object_test.go: Getting data for '%s' test case from server\n", testCase)
 				}
-				err := testingObjects[testCase].readObject()
+				err := testingObjects[testCase].readObject(context.Background())
 				if err != nil {
 					t.Fatalf("api_object_test.go: Failed to read data for test case '%s': %s", testCase, err)
 				}
@@ -204,7 +208,7 @@ func TestAPIObject(t *testing.T) {
 			log.Printf("api_object_test.go: Testing update_object()")
 		}
 		testingObjects["minimal"].data["Thing"] = "spoon"
-		testingObjects["minimal"].updateObject()
+		testingObjects["minimal"].updateObject(context.Background())
 		if err != nil {
 			t.Fatalf("api_object_test.go: Failed in update_object() test: %s", err)
 		} else if testingObjects["minimal"].apiData["Thing"] != "spoon" {
@@ -213,19 +217,22 @@ func TestAPIObject(t *testing.T) {
 		}
 	})
 
-	/* Delete one and make sure a 404 follows */
+	/* Delete one and make sure the 404 that follows clears the id,
+	   which takes it out of state */
 	t.Run("delete_object", func(t *testing.T) {
 		if testDebug {
 			log.Printf("api_object_test.go: Testing delete_object()")
 		}
-		testingObjects["pet"].deleteObject()
-		err = testingObjects["pet"].readObject()
-		if err == nil {
-			t.Fatalf("api_object_test.go: 'pet' object deleted, but a subsequent read did not return an error!\n")
+		id := testingObjects["pet"].id
+		testingObjects["pet"].deleteObject(context.Background())
+		err = testingObjects["pet"].readObject(context.Background())
+		if err != nil {
+			t.Fatalf("api_object_test.go: 'pet' object deleted, but a subsequent read returned an error instead of noticing it is gone: %v\n", err)
 		}
-		if !strings.Contains(err.Error(), "404") {
-			t.Fatalf("api_object_test.go: 'pet' object deleted, but the resulting error was not a 404 during read! What came back was: %v\n", err)
+		if testingObjects["pet"].id != "" {
+			t.Fatalf("api_object_test.go: 'pet' object deleted, but a subsequent read did not clear its id\n")
 		}
+		testingObjects["pet"].id = id
 	})
 
 	/* Recreate the one we just got rid of */
@@ -234,7 +241,7 @@ func TestAPIObject(t *testing.T) {
 			log.Printf("api_object_test.go: Testing create_object()")
 		}
 		testingObjects["pet"].data["Thing"] = "dog"
-		err = testingObjects["pet"].createObject()
+		err = testingObjects["pet"].createObject(context.Background())
 		if err != nil {
 			t.Fatalf("api_object_test.go: Failed in create_object() test: %s", err)
 		} else if testingObjects["minimal"].apiData["Thing"] != "spoon" {
@@ -243,7 +250,7 @@ func TestAPIObject(t *testing.T) {
 		}
 
 		/* verify it's there */
-		err = testingObjects["pet"].readObject()
+		err = testingObjects["pet"].readObject(context.Background())
 		if err != nil {
 			t.Fatalf("api_object_test.go: Failed in read_object() test: %s", err)
 		} else if testingObjects["pet"].apiData["Thing"] != "dog" {
@@ -266,7 +273,7 @@ func TestAPIObject(t *testing.T) {
 		searchKey := "Thing"
 		searchValue := "dog"
 		resultsKey := ""
-		tmpObj, err := object.findObject(queryString, searchKey, searchValue, resultsKey)
+		tmpObj, err := object.findObject(context.Background(), queryString, searchKey, searchValue, resultsKey)
 		if err != nil {
 			t.Fatalf("api_object_test.go: Failed to find api_object: %s", searchValue)
 		}
@@ -288,3 +295,479 @@ func TestAPIObject(t *testing.T) {
 		log.Println("api_object_test.go: Done")
 	}
 }
+
+func TestAPIObjectNumericPrecision(t *testing.T) {
+	apiServerObjects := make(map[string]map[string]interface{})
+	svr := fakeserver.NewFakeServer(8087, apiServerObjects, true, httpServerDebug, "")
+	defer svr.Shutdown()
+
+	client, err := NewAPIClient(&apiClientOpt{
+		uri:                "http://127.0.0.1:8087/",
+		timeout:            2,
+		writeReturnsObject: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := `{"big":9007199254740993,"id":9223372036854775807,"precise":3.14159265358979323846264338327950288}`
+	obj, err := NewAPIObject(client, &apiObjectOpts{
+		path:  "/api/objects",
+		data:  data,
+		debug: apiObjectDebug,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.id != "9223372036854775807" {
+		t.Fatalf("api_object_test.go: expected a 19 digit id to be extracted verbatim but got '%s'", obj.id)
+	}
+
+	if err := obj.createObject(context.Background()); err != nil {
+		t.Fatalf("api_object_test.go: failed to create object: %s", err)
+	}
+	if err := obj.readObject(context.Background()); err != nil {
+		t.Fatalf("api_object_test.go: failed to read object: %s", err)
+	}
+	if err := obj.updateObject(context.Background()); err != nil {
+		t.Fatalf("api_object_test.go: failed to update object: %s", err)
+	}
+
+	if obj.apiResponse != data {
+		t.Errorf("api_object_test.go: numbers were not preserved through a read/update cycle.\nExpected: %s\nGot:      %s", data, obj.apiResponse)
+	}
+
+	stored, _ := json.Marshal(apiServerObjects["9223372036854775807"])
+	if string(stored) != data {
+		t.Errorf("api_object_test.go: numbers sent to the server were altered.\nExpected: %s\nGot:      %s", data, stored)
+	}
+}
+
+func TestAPIObjectCopyKeys(t *testing.T) {
+	apiServerObjects := map[string]map[string]interface{}{
+		"1": {
+			"id":       "1",
+			"name":     "before",
+			"revision": "7",
+			"meta":     map[string]interface{}{"created_by": "someone", "etag": "abc"},
+		},
+	}
+	svr := fakeserver.NewFakeServer(8090, apiServerObjects, true, httpServerDebug, "")
+	defer svr.Shutdown()
+
+	newObject := func(copyKeys []string) *APIObject {
+		client, err := NewAPIClient(&apiClientOpt{
+			uri:      "http://127.0.0.1:8090/",
+			timeout:  2,
+			copyKeys: copyKeys,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		obj, err := NewAPIObject(client, &apiObjectOpts{
+			path:  "/api/objects",
+			data:  `{"id": "1", "name": "after"}`,
+			debug: apiObjectDebug,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := obj.readObject(context.Background()); err != nil {
+			t.Fatalf("api_object_test.go: failed to read object: %s", err)
+		}
+		return obj
+	}
+
+	/* Without the revision, the server refuses the update */
+	if err := newObject(nil).updateObject(context.Background()); err == nil || !strings.Contains(err.Error(), "409") {
+		t.Fatalf("api_object_test.go: expected the server to reject an update without the revision but got: %v", err)
+	}
+
+	obj := newObject([]string{"revision", "meta.created_by", "meta/missing"})
+	if err := obj.updateObject(context.Background()); err != nil {
+		t.Fatalf("api_object_test.go: failed to update object: %s", err)
+	}
+
+	stored := apiServerObjects["1"]
+	meta, _ := stored["meta"].(map[string]interface{})
+	if stored["name"] != "after" || stored["revision"] != "7" || meta["created_by"] != "someone" {
+		t.Errorf("api_object_test.go: expected the copied keys to be sent with the update but the server has %v", stored)
+	}
+	if _, ok := meta["etag"]; ok {
+		t.Errorf("api_object_test.go: only the listed nested keys should be copied but the server has %v", meta)
+	}
+	if _, ok := meta["missing"]; ok {
+		t.Errorf("api_object_test.go: a key missing from the server copy should not be sent")
+	}
+}
+
+func TestAPIObjectReadSearch(t *testing.T) {
+	apiServerObjects := map[string]map[string]interface{}{
+		"1": {"id": "1", "name": "a", "group": "x"},
+		"2": {"id": "2", "name": "b", "group": "x"},
+		"3": {"id": "3", "name": "c", "group": "y"},
+	}
+	svr := fakeserver.NewFakeServer(8091, apiServerObjects, true, httpServerDebug, "")
+	defer svr.Shutdown()
+
+	client, err := NewAPIClient(&apiClientOpt{
+		uri:         "http://127.0.0.1:8091/",
+		timeout:     2,
+		idAttribute: "id",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	search := func(id string, key string, value string) (*APIObject, error) {
+		obj, err := NewAPIObject(client, &apiObjectOpts{
+			path:  "/api/objects",
+			id:    id,
+			data:  `{"name": "ignored"}`,
+			debug: apiObjectDebug,
+			readSearch: map[string]string{
+				"search_path":  "/api/objects",
+				"search_key":   key,
+				"search_value": value,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return obj, obj.readObject(context.Background())
+	}
+
+	/* The id is learned from the search */
+	obj, err := search("", "name", "b")
+	if err != nil {
+		t.Fatalf("api_object_test.go: %s", err)
+	}
+	if obj.id != "2" || obj.apiData["group"] != "x" {
+		t.Errorf("api_object_test.go: expected to find object 2 but got id '%s' with %v", obj.id, obj.apiData)
+	}
+
+	/* No match means the object is gone */
+	obj, err = search("3", "name", "missing")
+	if err != nil || obj.id != "" {
+		t.Errorf("api_object_test.go: expected no match to clear the id without an error but got id '%s', %v", obj.id, err)
+	}
+
+	/* More than one match is an error */
+	_, err = search("", "group", "x")
+	if err == nil || !strings.Contains(err.Error(), "found 2 objects with the 'group' key = 'x'") || !strings.Contains(err.Error(), "1, 2") {
+		t.Errorf("api_object_test.go: expected an error naming both matches but got: %v", err)
+	}
+
+	/* Objects created without a known id are located by searching */
+	var posted bool
+	mux := http.NewServeMux()
+	mux.HandleFunc("/things", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			posted = true
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		list := `[{"uuid": "a-1", "name": "other"}]`
+		if posted {
+			list = `[{"uuid": "a-1", "name": "other"}, {"uuid": "b-2", "name": "new"}]`
+		}
+		w.Write([]byte(list))
+	})
+	things := httptest.NewServer(mux)
+	defer things.Close()
+
+	thingsClient, err := NewAPIClient(&apiClientOpt{uri: things.URL, timeout: 2, idAttribute: "uuid"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err = NewAPIObject(thingsClient, &apiObjectOpts{
+		path:       "/things",
+		data:       `{"name": "new"}`,
+		debug:      apiObjectDebug,
+		readSearch: map[string]string{"search_key": "name", "search_value": "new"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := obj.createObject(context.Background()); err != nil {
+		t.Fatalf("api_object_test.go: %s", err)
+	}
+	if obj.id != "b-2" {
+		t.Errorf("api_object_test.go: expected the created object to be found as 'b-2' but got '%s'", obj.id)
+	}
+}
+
+func TestAPIObjectCreateResponses(t *testing.T) {
+	/* Each path answers a create in a different way; reading
+	   any object back gives the same stored copy */
+	mux := http.NewServeMux()
+	mux.HandleFunc("/full", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id": "11", "name": "full", "server": "set"}`))
+	})
+	mux.HandleFunc("/location", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "/location/22")
+		w.WriteHeader(http.StatusCreated)
+	})
+	mux.HandleFunc("/wrapped", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"status": "ok", "result": {"id": 33}}`))
+	})
+	mux.HandleFunc("/nothing", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		id := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		w.Write([]byte(fmt.Sprintf(`{"id": "%s", "name": "read back"}`, id)))
+	})
+	svr := httptest.NewServer(mux)
+	defer svr.Close()
+
+	client, err := NewAPIClient(&apiClientOpt{
+		uri:                 svr.URL,
+		timeout:             2,
+		idAttribute:         "id",
+		createReturnsObject: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	create := func(path string, idAttribute string) (*APIObject, error) {
+		obj, err := NewAPIObject(client, &apiObjectOpts{
+			path:                      path,
+			data:                      `{"name": "new"}`,
+			debug:                     apiObjectDebug,
+			createResponseIDAttribute: idAttribute,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return obj, obj.createObject(context.Background())
+	}
+
+	/* The full object is used as returned */
+	obj, err := create("/full", "")
+	if err != nil {
+		t.Fatalf("api_object_test.go: %s", err)
+	}
+	if obj.id != "11" || obj.apiData["server"] != "set" {
+		t.Errorf("api_object_test.go: expected the returned object 11 to be used but got id '%s' with %v", obj.id, obj.apiData)
+	}
+
+	/* An empty body falls back to the Location header */
+	obj, err = create("/location", "")
+	if err != nil {
+		t.Fatalf("api_object_test.go: %s", err)
+	}
+	if obj.id != "22" || obj.apiData["name"] != "read back" {
+		t.Errorf("api_object_test.go: expected object 22 to be read back but got id '%s' with %v", obj.id, obj.apiData)
+	}
+
+	/* A wrapped object gives its id from create_response_id_attribute */
+	obj, err = create("/wrapped", "result.id")
+	if err != nil {
+		t.Fatalf("api_object_test.go: %s", err)
+	}
+	if obj.id != "33" || obj.apiData["name"] != "read back" {
+		t.Errorf("api_object_test.go: expected object 33 to be read back but got id '%s' with %v", obj.id, obj.apiData)
+	}
+
+	/* Without either, the error says what was looked at */
+	_, err = create("/nothing", "")
+	if err == nil || !strings.Contains(err.Error(), "the response body (it was empty)") || !strings.Contains(err.Error(), "the Location header (it was not set)") {
+		t.Errorf("api_object_test.go: expected an error listing what was tried but got: %v", err)
+	}
+}
+
+func TestAPIObjectMethodOverrides(t *testing.T) {
+	svr := fakeserver.NewFakeServer(8092, map[string]map[string]interface{}{}, true, httpServerDebug, "")
+	defer svr.Shutdown()
+
+	client, err := NewAPIClient(&apiClientOpt{
+		uri:         "http://127.0.0.1:8092/",
+		timeout:     2,
+		idAttribute: "id",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	obj, err := NewAPIObject(client, &apiObjectOpts{
+		path:          "/api/objects",
+		data:          `{"id": "1", "name": "legacy"}`,
+		debug:         apiObjectDebug,
+		updateMethod:  "POST",
+		destroyMethod: "DELETE",
+		destroyData:   `{"reason": "cleanup"}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := obj.createObject(context.Background()); err != nil {
+		t.Fatalf("api_object_test.go: %s", err)
+	}
+	obj.data["name"] = "renamed"
+	if err := obj.updateObject(context.Background()); err != nil {
+		t.Fatalf("api_object_test.go: %s", err)
+	}
+	if err := obj.deleteObject(context.Background()); err != nil {
+		t.Fatalf("api_object_test.go: %s", err)
+	}
+
+	var got []string
+	for _, r := range svr.Requests() {
+		got = append(got, r.Method+" "+r.URL)
+	}
+	expected := []string{
+		"POST /api/objects",
+		"GET /api/objects/1",
+		"POST /api/objects/1",
+		"GET /api/objects/1",
+		"DELETE /api/objects/1",
+	}
+	if strings.Join(got, ", ") != strings.Join(expected, ", ") {
+		t.Errorf("api_object_test.go: expected requests %v but got %v", expected, got)
+	}
+
+	requests := svr.Requests()
+	if body := requests[len(requests)-1].Body; body != `{"reason": "cleanup"}` {
+		t.Errorf("api_object_test.go: expected the destroy_data to be sent with the DELETE but got '%s'", body)
+	}
+
+	if _, errs := validateHTTPMethod("PATCH", "update_method"); len(errs) != 0 {
+		t.Errorf("api_object_test.go: expected PATCH to be accepted but got %v", errs)
+	}
+	if _, errs := validateHTTPMethod("FETCH", "update_method"); len(errs) == 0 {
+		t.Errorf("api_object_test.go: expected FETCH to be rejected")
+	}
+}
+
+func TestAPIObjectQueryStrings(t *testing.T) {
+	svr := fakeserver.NewFakeServer(8093, map[string]map[string]interface{}{}, true, httpServerDebug, "")
+	defer svr.Shutdown()
+
+	client, err := NewAPIClient(&apiClientOpt{
+		uri:         "http://127.0.0.1:8093/",
+		timeout:     2,
+		idAttribute: "id",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	obj, err := NewAPIObject(client, &apiObjectOpts{
+		path:              "/api/objects",
+		getPath:           "/api/objects/{id}?expand=true",
+		data:              `{"id": "1", "name": "tenanted"}`,
+		debug:             apiObjectDebug,
+		queryString:       "tenant=a&api-version=1",
+		updateQueryString: "tenant=a&api-version=2",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := obj.createObject(context.Background()); err != nil {
+		t.Fatalf("api_object_test.go: %s", err)
+	}
+	if err := obj.updateObject(context.Background()); err != nil {
+		t.Fatalf("api_object_test.go: %s", err)
+	}
+	if err := obj.deleteObject(context.Background()); err != nil {
+		t.Fatalf("api_object_test.go: %s", err)
+	}
+
+	search, err := NewAPIObject(client, &apiObjectOpts{
+		path:            "/api/objects",
+		data:            `{"name": "found"}`,
+		debug:           apiObjectDebug,
+		queryString:     "tenant=a",
+		readQueryString: "tenant=b",
+		readSearch: map[string]string{
+			"search_key":   "name",
+			"search_value": "found",
+			"query_string": "sort=name",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := search.readObject(context.Background()); err != nil {
+		t.Fatalf("api_object_test.go: %s", err)
+	}
+
+	var got []string
+	for _, r := range svr.Requests() {
+		got = append(got, r.Method+" "+r.URL)
+	}
+	expected := []string{
+		"POST /api/objects?tenant=a&api-version=1",
+		"GET /api/objects/1?expand=true&tenant=a&api-version=1",
+		"PUT /api/objects/1?tenant=a&api-version=2",
+		"GET /api/objects/1?expand=true&tenant=a&api-version=1",
+		"DELETE /api/objects/1?tenant=a&api-version=1",
+		"GET /api/objects?sort=name&tenant=b",
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("api_object_test.go: unexpected request URLs.\nExpected:\n%s\nGot:\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+
+	if _, errs := validateQueryString("tenant=a&api-version=1", "query_string"); len(errs) != 0 {
+		t.Errorf("api_object_test.go: expected a valid query string to be accepted but got %v", errs)
+	}
+	if _, errs := validateQueryString("name=100%", "query_string"); len(errs) == 0 {
+		t.Errorf("api_object_test.go: expected a query string that is not URL encoded to be rejected")
+	}
+}
+
+func TestAPIObjectVerboseErrors(t *testing.T) {
+	ctx := context.Background()
+	svr := fakeserver.NewFakeServer(0, map[string]map[string]interface{}{
+		"1": {"id": "1", "name": "web", "port": 80},
+	}, false, httpServerDebug, "")
+	svr.AddRule(&fakeserver.Rule{Method: "PUT", Status: http.StatusUnprocessableEntity})
+
+	update := func(verbose bool) (int, error) {
+		client, err := NewAPIClient(&apiClientOpt{
+			uri:           "http://fakeserver.invalid",
+			timeout:       2,
+			verboseErrors: verbose,
+			transport:     handlerTransport{svr.Handler()},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		obj, err := NewAPIObject(client, &apiObjectOpts{
+			path:  "/api/objects",
+			id:    "1",
+			data:  `{"id": "1", "name": "web", "port": 8080}`,
+			debug: apiObjectDebug,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		before := len(svr.Requests())
+		err = obj.updateObject(ctx)
+		return len(svr.Requests()) - before, err
+	}
+
+	/* Off, the error is as it was and no more requests are made */
+	requests, err := update(false)
+	if err == nil || strings.Contains(err.Error(), "differs") || requests != 1 {
+		t.Errorf("api_object_test.go: expected the update to fail without a diff after 1 request but got %d requests and: %v", requests, err)
+	}
+
+	/* On, the object is read and compared with what was sent */
+	requests, err = update(true)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("api_object_test.go: expected the 422 to still be available but got: %v", err)
+	}
+	if requests != 2 || !strings.Contains(err.Error(), "-  \"port\": 80\n+  \"port\": 8080") {
+		t.Errorf("api_object_test.go: expected the error to include the diff after 2 requests but got %d requests and: %s", requests, err)
+	}
+}
@@ -0,0 +1,130 @@
+package restapi
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+/*circuitBreaker stops the client from hammering an API that is down.
+  After threshold consecutive transport level failures within window,
+  the circuit opens and requests fail immediately. Once every cooldown,
+  a single request is let through as a probe - if it succeeds, the
+  circuit closes again. It is safe for concurrent use */
+type circuitBreaker struct {
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+
+	mutex        sync.Mutex
+	failures     int
+	firstFailure time.Time
+	openedAt     time.Time
+	lastErr      error
+	probing      bool
+
+	/* Overridable for tests */
+	now func() time.Time
+}
+
+func newCircuitBreaker(threshold int, window time.Duration, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		window:    window,
+		cooldown:  cooldown,
+		now:       time.Now,
+	}
+}
+
+/* Returns an error if the circuit is open and the request should not be made */
+func (cb *circuitBreaker) allow() error {
+	_, err := cb.admit()
+	return err
+}
+
+/* As allow, also saying whether the request is the probe. A probe
+   must end in success, failure or abandon, or no other request will
+   ever be let through */
+func (cb *circuitBreaker) admit() (probe bool, err error) {
+	if cb == nil || cb.threshold <= 0 {
+		return false, nil
+	}
+
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	if cb.openedAt.IsZero() {
+		return false, nil
+	}
+
+	if !cb.probing && cb.now().Sub(cb.openedAt) >= cb.cooldown {
+		log.Printf("circuit_breaker.go: Circuit open since %s - allowing a probe request", cb.openedAt.Format(time.RFC3339))
+		cb.probing = true
+		return true, nil
+	}
+
+	return false, fmt.Errorf("circuit open since %s after %d consecutive connection failures, last error: %v", cb.openedAt.Format(time.RFC3339), cb.threshold, cb.lastErr)
+}
+
+/* Any response from the server, regardless of status, closes the circuit */
+func (cb *circuitBreaker) success() {
+	if cb == nil || cb.threshold <= 0 {
+		return
+	}
+
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	if !cb.openedAt.IsZero() {
+		log.Printf("circuit_breaker.go: API is reachable again - closing circuit")
+	}
+	cb.failures = 0
+	cb.openedAt = time.Time{}
+	cb.lastErr = nil
+	cb.probing = false
+}
+
+/* A probe whose context ended before it got an answer (while it waited
+   on the rate limiter or the server) tells us nothing, so let the next
+   request probe instead */
+func (cb *circuitBreaker) abandon() {
+	if cb == nil || cb.threshold <= 0 {
+		return
+	}
+
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	cb.probing = false
+}
+
+/* Record a transport level failure */
+func (cb *circuitBreaker) failure(err error) {
+	if cb == nil || cb.threshold <= 0 {
+		return
+	}
+
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	now := cb.now()
+	cb.lastErr = err
+
+	/* A failed probe re-opens the circuit for another cool-down */
+	if cb.probing {
+		cb.probing = false
+		cb.openedAt = now
+		return
+	}
+
+	if cb.failures == 0 || now.Sub(cb.firstFailure) > cb.window {
+		cb.failures = 0
+		cb.firstFailure = now
+	}
+	cb.failures++
+
+	if cb.failures >= cb.threshold && cb.openedAt.IsZero() {
+		log.Printf("circuit_breaker.go: WARNING: %d consecutive connection failures - opening circuit. Last error: %v", cb.failures, err)
+		cb.openedAt = now
+	}
+}
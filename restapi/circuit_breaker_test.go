@@ -0,0 +1,231 @@
+package restapi
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Mastercard/terraform-provider-restapi/fakeserver"
+)
+
+func TestCircuitBreakerStates(t *testing.T) {
+	now := time.Now()
+	cb := newCircuitBreaker(3, time.Minute, 30*time.Second)
+	cb.now = func() time.Time { return now }
+
+	failure := errors.New("connection refused")
+
+	/* Failures spread out beyond the window don't open the circuit */
+	cb.failure(failure)
+	cb.failure(failure)
+	now = now.Add(2 * time.Minute)
+	cb.failure(failure)
+	if err := cb.allow(); err != nil {
+		t.Fatalf("circuit_breaker_test.go: circuit opened for failures outside the window: %s", err)
+	}
+
+	/* A response in between resets the count */
+	cb.success()
+	cb.failure(failure)
+	cb.failure(failure)
+	if err := cb.allow(); err != nil {
+		t.Fatalf("circuit_breaker_test.go: circuit opened before reaching the threshold: %s", err)
+	}
+
+	cb.failure(failure)
+	err := cb.allow()
+	if err == nil {
+		t.Fatalf("circuit_breaker_test.go: circuit did not open after 3 consecutive failures")
+	}
+	if !strings.Contains(err.Error(), "circuit open since") || !strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("circuit_breaker_test.go: unexpected open circuit error: %s", err)
+	}
+
+	/* After the cool-down, exactly one probe is let through */
+	now = now.Add(31 * time.Second)
+	if err := cb.allow(); err != nil {
+		t.Fatalf("circuit_breaker_test.go: expected a probe to be allowed after the cool-down: %s", err)
+	}
+	if err := cb.allow(); err == nil {
+		t.Fatalf("circuit_breaker_test.go: expected only one probe to be allowed at a time")
+	}
+
+	/* A failed probe waits another full cool-down */
+	cb.failure(failure)
+	now = now.Add(10 * time.Second)
+	if err := cb.allow(); err == nil {
+		t.Fatalf("circuit_breaker_test.go: expected the circuit to stay open after a failed probe")
+	}
+
+	now = now.Add(30 * time.Second)
+	if err := cb.allow(); err != nil {
+		t.Fatalf("circuit_breaker_test.go: expected a second probe to be allowed: %s", err)
+	}
+	cb.success()
+	if err := cb.allow(); err != nil {
+		t.Fatalf("circuit_breaker_test.go: expected the circuit to close after a successful probe: %s", err)
+	}
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	cb := newCircuitBreaker(0, time.Minute, time.Second)
+	for i := 0; i < 10; i++ {
+		cb.failure(errors.New("connection refused"))
+	}
+	if err := cb.allow(); err != nil {
+		t.Fatalf("circuit_breaker_test.go: a threshold of 0 should disable the circuit breaker: %s", err)
+	}
+}
+
+func TestCircuitBreakerAgainstServer(t *testing.T) {
+	debug := false
+	apiServerObjects := map[string]map[string]interface{}{
+		"1": {"id": "1"},
+	}
+
+	svr := fakeserver.NewFakeServer(8086, apiServerObjects, true, debug, "")
+
+	client, err := NewAPIClient(&apiClientOpt{
+		uri:              "http://127.0.0.1:8086",
+		timeout:          2,
+		circuitThreshold: 2,
+		circuitWindow:    60,
+		circuitCooldown:  1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	/* HTTP errors do not count against the circuit */
+	for i := 0; i < 3; i++ {
+		if _, err := client.sendRequest("GET", "/api/objects/missing", ""); err == nil || strings.Contains(err.Error(), "circuit open") {
+			t.Fatalf("circuit_breaker_test.go: expected a plain 404 but got: %v", err)
+		}
+	}
+
+	svr.Shutdown()
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.sendRequest("GET", "/api/objects/1", ""); err == nil || strings.Contains(err.Error(), "circuit open") {
+			t.Fatalf("circuit_breaker_test.go: expected a connection failure but got: %v", err)
+		}
+	}
+
+	_, err = client.sendRequest("GET", "/api/objects/1", "")
+	if err == nil || !strings.Contains(err.Error(), "circuit open") {
+		t.Fatalf("circuit_breaker_test.go: expected the request to fail fast but got: %v", err)
+	}
+
+	svr = fakeserver.NewFakeServer(8086, apiServerObjects, true, debug, "")
+	defer svr.Shutdown()
+
+	/* The fakeserver takes a second to start, which is also our cool-down */
+	if _, err := client.sendRequest("GET", "/api/objects/1", ""); err != nil {
+		t.Fatalf("circuit_breaker_test.go: expected the probe to succeed once the server is back: %s", err)
+	}
+	if _, err := client.sendRequest("GET", "/api/objects/1", ""); err != nil {
+		t.Fatalf("circuit_breaker_test.go: expected the circuit to be closed: %s", err)
+	}
+}
+
+func TestCircuitBreakerCancelledProbe(t *testing.T) {
+	/* Nothing listens here, so every request is a connection failure */
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	uri := "http://" + l.Addr().String()
+	l.Close()
+
+	client, err := NewAPIClient(&apiClientOpt{
+		uri:              uri,
+		timeout:          2,
+		rateLimit:        100,
+		circuitThreshold: 1,
+		circuitWindow:    60,
+		circuitCooldown:  60,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.sendRequest("GET", "/api/objects/1", ""); err == nil || strings.Contains(err.Error(), "circuit open") {
+		t.Fatalf("circuit_breaker_test.go: expected a connection failure but got: %v", err)
+	}
+
+	/* Come back after the cool-down, but give up on the probe
+	   while it waits on the rate limiter */
+	now := time.Now().Add(61 * time.Second)
+	client.circuitBreaker.now = func() time.Time { return now }
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := client.doRequest("GET", "/api/objects/1", "", withContext(ctx)); err == nil || !errors.Is(err, context.Canceled) {
+		t.Fatalf("circuit_breaker_test.go: expected the cancelled probe to fail with its context but got: %v", err)
+	}
+
+	/* The next request is let through as the probe instead */
+	if _, err := client.sendRequest("GET", "/api/objects/1", ""); err == nil || strings.Contains(err.Error(), "circuit open") {
+		t.Fatalf("circuit_breaker_test.go: expected a probe after the cancelled one but got: %v", err)
+	}
+}
+
+func TestCircuitBreakerCancelledRequest(t *testing.T) {
+	/* /slow answers only once the client has gone away */
+	started := make(chan struct{}, 1)
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			started <- struct{}{}
+			<-r.Context().Done()
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer svr.Close()
+
+	client, err := NewAPIClient(&apiClientOpt{
+		uri:              svr.URL,
+		timeout:          5,
+		circuitThreshold: 1,
+		circuitWindow:    60,
+		circuitCooldown:  60,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cancelled := func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			<-started
+			cancel()
+		}()
+		if _, err := client.doRequest("GET", "/slow", "", withContext(ctx)); err == nil || !errors.Is(err, context.Canceled) {
+			t.Fatalf("circuit_breaker_test.go: expected the request to fail with its context but got: %v", err)
+		}
+	}
+
+	/* A cancelled request is not a connection failure */
+	cancelled()
+	if _, err := client.sendRequest("GET", "/fast", ""); err != nil {
+		t.Fatalf("circuit_breaker_test.go: expected the circuit to stay closed after a cancelled request but got: %v", err)
+	}
+
+	client.circuitBreaker.failure(errors.New("connection refused"))
+	if _, err := client.sendRequest("GET", "/fast", ""); err == nil || !strings.Contains(err.Error(), "circuit open") {
+		t.Fatalf("circuit_breaker_test.go: expected the circuit to be open but got: %v", err)
+	}
+
+	/* A probe cancelled while the server works on it leaves the
+	   next request to probe, rather than re-opening the circuit */
+	now := time.Now().Add(61 * time.Second)
+	client.circuitBreaker.now = func() time.Time { return now }
+	cancelled()
+	if _, err := client.sendRequest("GET", "/fast", ""); err != nil {
+		t.Fatalf("circuit_breaker_test.go: expected a probe after the cancelled one but got: %v", err)
+	}
+}
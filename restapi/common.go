@@ -1,25 +1,85 @@
 package restapi
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"math/big"
+	"net/url"
 	"os"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 
-	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 )
 
+/* The HTTP methods that may be given for the *_method options */
+var httpMethods = []string{"DELETE", "GET", "HEAD", "OPTIONS", "PATCH", "POST", "PUT"}
+
+var validateHTTPMethod = validation.StringInSlice(httpMethods, false)
+
 /* After any operation that returns API data, we'll stuff
    all the k,v pairs into the api_data map so users can
    consume the values elsewhere if they'd like */
-func setResourceState(obj *APIObject, d *schema.ResourceData) {
+func setResourceState(obj *APIObject, d *schema.ResourceData) diag.Diagnostics {
 	apiData := make(map[string]string)
 	for k, v := range obj.apiData {
 		apiData[k] = fmt.Sprintf("%v", v)
 	}
 	d.Set("api_data", apiData)
 	d.Set("api_response", obj.apiResponse)
+
+	extracted, missing := extractValues(obj.apiData, d.Get("extract").(map[string]interface{}), obj.debug)
+	d.Set("extracted", extracted)
+
+	var diags diag.Diagnostics
+	for _, name := range missing {
+		diags = append(diags, diag.Diagnostic{
+			Severity:      diag.Warning,
+			Summary:       fmt.Sprintf("Nothing to extract for '%s'", name),
+			Detail:        fmt.Sprintf("The object returned by the API has nothing at '%s', so extracted.%s is empty.", d.Get("extract").(map[string]interface{})[name], name),
+			AttributePath: cty.GetAttrPath("extract").IndexString(name),
+		})
+	}
+	return diags
+}
+
+/* Look up the path given for each output in extract. Scalars are
+   given as they are, and hashes and arrays as JSON. A path that is
+   not in the data gives an empty string, and is listed in missing */
+func extractValues(data map[string]interface{}, extract map[string]interface{}, debug bool) (extracted map[string]string, missing []string) {
+	extracted = make(map[string]string)
+	for name, path := range extract {
+		value, err := GetObjectAtKey(data, path.(string), debug)
+		if err != nil {
+			log.Printf("[WARN] common.go: Could not extract '%s' from path '%s': %s", name, path, err)
+			extracted[name] = ""
+			missing = append(missing, name)
+			continue
+		}
+
+		switch v := value.(type) {
+		case nil:
+			extracted[name] = ""
+		case string:
+			extracted[name] = v
+		case json.Number:
+			extracted[name] = formatNumber(v)
+		case bool:
+			extracted[name] = strconv.FormatBool(v)
+		default:
+			b, _ := json.Marshal(v)
+			extracted[name] = string(b)
+		}
+	}
+	sort.Strings(missing)
+	return extracted, missing
 }
 
 /*GetStringAtKey uses GetObjectAtKey to verify the resulting
@@ -31,14 +91,112 @@ func GetStringAtKey(data map[string]interface{}, path string, debug bool) (strin
 	}
 
 	/* JSON supports strings, numbers, objects and arrays. Allow a string OR number here */
-	t := fmt.Sprintf("%T", res)
-	if t == "string" {
-		return res.(string), nil
-	} else if t == "float64" {
-		return strconv.FormatFloat(res.(float64), 'f', -1, 64), nil
-	} else {
-		return "", fmt.Errorf("object at path '%s' is not a JSON string or number (float64) - the go fmt package says it is '%T'", path, res)
+	switch v := res.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return formatNumber(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	default:
+		return "", fmt.Errorf("object at path '%s' is not a JSON string or number - the go fmt package says it is '%T'", path, res)
+	}
+}
+
+/* Give numbers one string form no matter how they were written, so
+   an id of 1e3 is the same as 1000. Anything else is used verbatim
+   so large integers and precise decimals are not rounded */
+func formatNumber(n json.Number) string {
+	s := n.String()
+	if !strings.ContainsAny(s, "eE") {
+		return s
+	}
+	if r, ok := new(big.Rat).SetString(s); ok && r.IsInt() {
+		return r.Num().String()
+	}
+	if f, err := n.Float64(); err == nil {
+		return strconv.FormatFloat(f, 'f', -1, 64)
 	}
+	return s
+}
+
+/*decodeJSON unmarshals data the same way json.Unmarshal does, except
+  numbers are kept as json.Number. This way, large integer IDs and
+  high precision decimals are sent back to the API exactly as received
+  instead of being rounded through a float64 */
+func decodeJSON(data string, v interface{}) error {
+	decoder := json.NewDecoder(strings.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+
+	/* Be as strict as json.Unmarshal about trailing data */
+	if _, err := decoder.Token(); err != io.EOF {
+		return fmt.Errorf("invalid data after top-level JSON value at offset %d", decoder.InputOffset())
+	}
+	return nil
+}
+
+/* Two strings of JSON are equivalent if they decode to the same
+   thing, regardless of whitespace and key order. Anything that
+   does not decode is only equivalent if it is identical */
+func jsonEquivalent(a string, b string) bool {
+	if a == b {
+		return true
+	}
+
+	var aData, bData interface{}
+	if decodeJSON(a, &aData) != nil || decodeJSON(b, &bData) != nil {
+		return false
+	}
+	return reflect.DeepEqual(aData, bData)
+}
+
+/* Paths into objects are normally separated with /, but a
+   path with no / may use . instead - provided it is not the
+   name of a top level key itself */
+func normalizeKeyPath(data map[string]interface{}, path string) string {
+	if strings.Contains(path, "/") {
+		return path
+	}
+	if _, ok := data[path]; ok {
+		return path
+	}
+	return strings.Replace(path, ".", "/", -1)
+}
+
+/*SetObjectAtKey is the counterpart to GetObjectAtKey. It sets the value
+  at the path given, creating any maps needed along the way */
+func SetObjectAtKey(data map[string]interface{}, path string, value interface{}) error {
+	hash := data
+	parts := strings.Split(path, "/")
+	seen := ""
+
+	for len(parts) > 1 {
+		part := parts[0]
+		parts = parts[1:]
+
+		/* Protect against double slashes by mistake */
+		if part == "" {
+			continue
+		}
+		seen += "/" + part
+
+		switch tmp := hash[part].(type) {
+		case map[string]interface{}:
+			hash = tmp
+		case nil:
+			created := make(map[string]interface{})
+			hash[part] = created
+			hash = created
+		default:
+			return fmt.Errorf("SetObjectAtKey: Object at '%s' is not a map. Is this the right path?", seen)
+		}
+	}
+
+	hash[parts[0]] = value
+	return nil
 }
 
 /*GetObjectAtKey is a handy helper that will dig through a map and find something
@@ -58,9 +216,11 @@ func GetStringAtKey(data map[string]interface{}, path string, debug bool) (strin
 Result:
 attrs/id => 1234
 config/foo => "abc"
+attrs.id => 1234 (a path without any / may use . instead)
 */
 func GetObjectAtKey(data map[string]interface{}, path string, debug bool) (interface{}, error) {
 	hash := data
+	path = normalizeKeyPath(data, path)
 
 	parts := strings.Split(path, "/")
 	part := ""
@@ -162,3 +322,67 @@ func expandStringList(configured []interface{}) []string {
 	}
 	return vs
 }
+
+/* Of the paths given (in the format GetObjectAtKey takes, where
+   list elements are addressed by index such as tags/0), return
+   those whose value differs between two JSON objects. A path
+   present in only one of them counts as a difference */
+func changedKeys(oldData string, newData string, paths []string) ([]string, error) {
+	oldHash := make(map[string]interface{})
+	newHash := make(map[string]interface{})
+	if oldData != "" {
+		if err := decodeJSON(oldData, &oldHash); err != nil {
+			return nil, fmt.Errorf("common.go: Could not parse the previous data: %s", err)
+		}
+	}
+	if err := decodeJSON(newData, &newHash); err != nil {
+		return nil, fmt.Errorf("common.go: Could not parse the new data: %s", err)
+	}
+
+	changed := make([]string, 0)
+	for _, path := range paths {
+		oldValue, oldErr := GetObjectAtKey(oldHash, path, false)
+		newValue, newErr := GetObjectAtKey(newHash, path, false)
+		if (oldErr == nil) != (newErr == nil) || !reflect.DeepEqual(oldValue, newValue) {
+			changed = append(changed, path)
+		}
+	}
+	return changed, nil
+}
+
+/* Ensure a string attribute, if set, holds a JSON object */
+func validateJSONObject(val interface{}, key string) (warns []string, errs []error) {
+	v := val.(string)
+	if v != "" {
+		data := make(map[string]interface{})
+		err := json.Unmarshal([]byte(v), &data)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s attribute is invalid JSON: %v", key, err))
+		}
+	}
+	return warns, errs
+}
+
+/* Add a raw query string to a path, which may already have one */
+func appendQueryString(path string, queryString string) string {
+	queryString = strings.TrimPrefix(queryString, "?")
+	if queryString == "" {
+		return path
+	}
+	if !strings.Contains(path, "?") {
+		return path + "?" + queryString
+	}
+	if strings.HasSuffix(path, "?") || strings.HasSuffix(path, "&") {
+		return path + queryString
+	}
+	return path + "&" + queryString
+}
+
+/* Ensure a string attribute, if set, is a well formed query string */
+func validateQueryString(val interface{}, key string) (warns []string, errs []error) {
+	v := strings.TrimPrefix(val.(string), "?")
+	if _, err := url.ParseQuery(v); err != nil {
+		errs = append(errs, fmt.Errorf("%s attribute is not a valid query string (values must be URL encoded): %v", key, err))
+	}
+	return warns, errs
+}
@@ -1,13 +1,14 @@
 package restapi
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"strings"
 	"testing"
 
-	"github.com/hashicorp/terraform/helper/resource"
-	"github.com/hashicorp/terraform/terraform"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
 )
 
 func testAccCheckRestapiObjectExists(n string, id string, client *APIClient) resource.TestCheckFunc {
@@ -39,7 +40,7 @@ func testAccCheckRestapiObjectExists(n string, id string, client *APIClient) res
 			return err
 		}
 
-		err = obj.readObject()
+		err = obj.readObject(context.Background())
 		if err != nil {
 			return err
 		}
@@ -163,3 +164,189 @@ func TestGetListStringAtKey(t *testing.T) {
 		t.Fatalf("Error: Expected '2', but got %s", res)
 	}
 }
+
+func TestDecodeJSON(t *testing.T) {
+	var res map[string]interface{}
+	if err := decodeJSON(`{"id": 12345678901234567890}`, &res); err != nil {
+		t.Fatalf("Error decoding JSON: %s", err)
+	}
+	id, err := GetStringAtKey(res, "id", false)
+	if err != nil || id != "12345678901234567890" {
+		t.Fatalf("Error: Expected '12345678901234567890', but got '%s' (%v)", id, err)
+	}
+
+	if err := decodeJSON(`{"id": 1} trailing`, &res); err == nil {
+		t.Fatalf("Error: Expected trailing data to be rejected")
+	}
+	if err := decodeJSON(``, &res); err == nil {
+		t.Fatalf("Error: Expected empty input to be rejected")
+	}
+}
+
+func TestJSONEquivalent(t *testing.T) {
+	cases := []struct {
+		a        string
+		b        string
+		expected bool
+	}{
+		{a: `{"id": "1", "list": [1, 2]}`, b: `{"list":[1,2],"id":"1"}`, expected: true},
+		{a: `{"id": "1", "list": [1, 2]}`, b: `{"id": "1", "list": [2, 1]}`, expected: false},
+		{a: `{"id": 1}`, b: `{"id": "1"}`, expected: false},
+		{a: `{"nested": {"a": true, "b": null}}`, b: `{"nested": {"b": null, "a": true}}`, expected: true},
+		{a: `not json`, b: `not json`, expected: true},
+		{a: `not json`, b: `{}`, expected: false},
+	}
+
+	for _, c := range cases {
+		if got := jsonEquivalent(c.a, c.b); got != c.expected {
+			t.Errorf("Error: Expected jsonEquivalent(%s, %s) to be %t", c.a, c.b, c.expected)
+		}
+	}
+}
+
+func TestSetObjectAtKey(t *testing.T) {
+	data := map[string]interface{}{
+		"id":   "1",
+		"meta": map[string]interface{}{"revision": "1"},
+		"list": []interface{}{"a"},
+	}
+
+	if err := SetObjectAtKey(data, "meta/revision", "2"); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if err := SetObjectAtKey(data, "new/nested/key", "value"); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if val, _ := GetStringAtKey(data, "meta/revision", false); val != "2" {
+		t.Errorf("Error: Expected meta/revision to be '2' but got '%s'", val)
+	}
+	if val, _ := GetStringAtKey(data, "new/nested/key", false); val != "value" {
+		t.Errorf("Error: Expected new/nested/key to be 'value' but got '%s'", val)
+	}
+	if err := SetObjectAtKey(data, "id/child", "x"); err == nil {
+		t.Errorf("Error: Expected an error setting a key below a string")
+	}
+
+	if path := normalizeKeyPath(data, "meta.revision"); path != "meta/revision" {
+		t.Errorf("Error: Expected meta.revision to become meta/revision but got '%s'", path)
+	}
+	data["dotted.key"] = "x"
+	if path := normalizeKeyPath(data, "dotted.key"); path != "dotted.key" {
+		t.Errorf("Error: Expected a top level key with a dot to be left alone but got '%s'", path)
+	}
+}
+
+func TestGetStringAtKeyIDs(t *testing.T) {
+	var testObj map[string]interface{}
+	if err := decodeJSON(`{"id": 1e3, "uuid": "b2a7", "meta": {"self_id": 12345678901234567890, "ratio": 1.5e-3}, "list": [{"id": 7}]}`, &testObj); err != nil {
+		t.Fatalf("Error decoding JSON: %s", err)
+	}
+
+	cases := map[string]string{
+		"id":           "1000",
+		"uuid":         "b2a7",
+		"meta/self_id": "12345678901234567890",
+		"meta.self_id": "12345678901234567890",
+		"meta.ratio":   "0.0015",
+		"list/0/id":    "7",
+		"list.0.id":    "7",
+	}
+	for path, expected := range cases {
+		res, err := GetStringAtKey(testObj, path, false)
+		if err != nil {
+			t.Errorf("Error extracting '%s': %s", path, err)
+		} else if res != expected {
+			t.Errorf("Error: Expected '%s' at '%s', but got '%s'", expected, path, res)
+		}
+	}
+
+	/* Numbers decoded the usual way come out the same */
+	var floatObj map[string]interface{}
+	json.Unmarshal([]byte(`{"id": 1e3}`), &floatObj)
+	if res, _ := GetStringAtKey(floatObj, "id", false); res != "1000" {
+		t.Errorf("Error: Expected '1000' from a float64 id, but got '%s'", res)
+	}
+
+	_, err := GetStringAtKey(testObj, "meta.missing", false)
+	if err == nil || !strings.Contains(err.Error(), "does not have key 'missing'") || !strings.Contains(err.Error(), "self_id") {
+		t.Errorf("Error: Expected a missing key error listing the available keys but got: %v", err)
+	}
+	if _, err := GetStringAtKey(testObj, "meta", false); err == nil {
+		t.Errorf("Error: Expected an error for an id that is an object")
+	}
+}
+
+func TestChangedKeys(t *testing.T) {
+	cases := []struct {
+		path     string
+		old      string
+		new      string
+		expected bool
+	}{
+		{path: "name", old: `{"name": "a"}`, new: `{"name": "a", "other": 1}`, expected: false},
+		{path: "name", old: `{"name": "a"}`, new: `{"name": "b"}`, expected: true},
+		{path: "config/size", old: `{"config": {"size": 1, "x": 1}}`, new: `{"config": {"x": 2, "size": 1}}`, expected: false},
+		{path: "config.size", old: `{"config": {"size": 1}}`, new: `{"config": {"size": 2}}`, expected: true},
+		{path: "tags/1", old: `{"tags": ["a", "b"]}`, new: `{"tags": ["c", "b"]}`, expected: false},
+		{path: "tags/0", old: `{"tags": ["a", "b"]}`, new: `{"tags": ["c", "b"]}`, expected: true},
+		{path: "disks/0/size", old: `{"disks": [{"size": 10}]}`, new: `{"disks": [{"size": 20}]}`, expected: true},
+		{path: "spec", old: `{"spec": {"a": [1, 2]}}`, new: `{"spec": {"a": [1, 2]}}`, expected: false},
+		{path: "spec", old: `{"spec": {"a": [1, 2]}}`, new: `{"spec": {"a": [2, 1]}}`, expected: true},
+		{path: "missing", old: `{"name": "a"}`, new: `{"name": "b"}`, expected: false},
+		{path: "added", old: `{}`, new: `{"added": true}`, expected: true},
+		{path: "removed", old: `{"removed": null}`, new: `{}`, expected: true},
+	}
+
+	for _, c := range cases {
+		changed, err := changedKeys(c.old, c.new, []string{c.path})
+		if err != nil {
+			t.Fatalf("common_test.go: %s", err)
+		}
+		if got := len(changed) == 1; got != c.expected {
+			t.Errorf("common_test.go: Expected changedKeys for '%s' from %s to %s to be %t", c.path, c.old, c.new, c.expected)
+		}
+	}
+
+	if _, err := changedKeys(`{}`, `not json`, []string{"name"}); err == nil {
+		t.Errorf("common_test.go: Expected invalid new data to be an error")
+	}
+}
+
+func TestExtractValues(t *testing.T) {
+	var data map[string]interface{}
+	decodeJSON(`{"id": 12, "token": "s3cret", "enabled": true, "gone": null, "network": {"ips": ["10.0.0.1", "10.0.0.2"], "dns": {"primary": "ns1"}}, "disks": [{"size": 1e3}]}`, &data)
+
+	extracted, missing := extractValues(data, map[string]interface{}{
+		"id":      "id",
+		"token":   "token",
+		"enabled": "enabled",
+		"gone":    "gone",
+		"dns":     "network/dns/primary",
+		"dotted":  "network.dns.primary",
+		"ip":      "network/ips/1",
+		"size":    "disks/0/size",
+		"ips":     "network/ips",
+		"missing": "network/ips/5",
+	}, false)
+
+	expected := map[string]string{
+		"id":      "12",
+		"token":   "s3cret",
+		"enabled": "true",
+		"gone":    "",
+		"dns":     "ns1",
+		"dotted":  "ns1",
+		"ip":      "10.0.0.2",
+		"size":    "1000",
+		"ips":     `["10.0.0.1","10.0.0.2"]`,
+		"missing": "",
+	}
+	for name, value := range expected {
+		if extracted[name] != value {
+			t.Errorf("common_test.go: Expected '%s' to be extracted as '%s' but got '%s'", name, value, extracted[name])
+		}
+	}
+	if len(missing) != 1 || missing[0] != "missing" {
+		t.Errorf("common_test.go: Expected only 'missing' to be reported as missing but got %v", missing)
+	}
+}
@@ -0,0 +1,127 @@
+package restapi
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/Mastercard/terraform-provider-restapi/fakeserver"
+)
+
+/* Terraform shares one client between all of its parallel operations.
+   Run this with -race (as scripts/test.sh does) - the point is as much
+   what the race detector sees as what the assertions check */
+func TestAPIClientConcurrent(t *testing.T) {
+	const workers = 50
+	debug := false
+
+	apiServerObjects := map[string]map[string]interface{}{
+		"shared": {"id": "shared", "name": "shared"},
+	}
+	svr := fakeserver.NewFakeServer(0, apiServerObjects, false, debug, "")
+	svr.SetAuth(fakeserver.Auth{ClientID: "id", ClientSecret: "secret"})
+	svr.SetPageSize(10)
+	httpSvr := httptest.NewServer(svr.Handler())
+	defer httpSvr.Close()
+
+	/* Everything that keeps state on the client is turned on */
+	client, err := NewAPIClient(&apiClientOpt{
+		uri:               httpSvr.URL,
+		timeout:           10,
+		rateLimit:         1000,
+		useCookies:        true,
+		enableHTTPCache:   true,
+		enableReadCache:   true,
+		circuitThreshold:  100,
+		circuitWindow:     60,
+		circuitCooldown:   60,
+		oauthClientID:     "id",
+		oauthClientSecret: "secret",
+		oauthTokenURL:     httpSvr.URL + "/oauth/token",
+		debug:             debug,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := fmt.Sprintf("worker-%02d", i)
+			lifecycle := func() error {
+				obj, err := NewAPIObject(client, &apiObjectOpts{
+					path:  "/api/objects",
+					id:    id,
+					data:  fmt.Sprintf(`{"id": "%s", "name": "%s", "step": "created"}`, id, id),
+					debug: debug,
+				})
+				if err != nil {
+					return err
+				}
+				if err := obj.createObject(ctx); err != nil {
+					return fmt.Errorf("create: %s", err)
+				}
+				if err := obj.readObject(ctx); err != nil {
+					return fmt.Errorf("read: %s", err)
+				}
+
+				obj.data["step"] = "updated"
+				if err := obj.updateObject(ctx); err != nil {
+					return fmt.Errorf("update: %s", err)
+				}
+				if err := obj.readObject(ctx); err != nil {
+					return fmt.Errorf("read after update: %s", err)
+				}
+				if obj.apiData["step"] != "updated" {
+					return fmt.Errorf("expected to read back the update but got %v", obj.apiData)
+				}
+
+				/* Lookups the way a data source does them, through the
+				   shared caches and across pages of the list */
+				lookup, err := NewAPIObject(client, &apiObjectOpts{path: "/api/objects", cachedReads: true, debug: debug})
+				if err != nil {
+					return err
+				}
+				if _, err := lookup.findObject(ctx, "", "name", "shared", ""); err != nil {
+					return fmt.Errorf("search: %s", err)
+				}
+				if _, err := client.sendRequest("GET", "/api/objects/shared", ""); err != nil {
+					return fmt.Errorf("shared read: %s", err)
+				}
+				client.metrics.summary()
+
+				if err := obj.deleteObject(ctx); err != nil {
+					return fmt.Errorf("delete: %s", err)
+				}
+				return nil
+			}
+			if err := lifecycle(); err != nil {
+				errs <- fmt.Errorf("%s: %s", id, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("concurrency_test.go: %s", err)
+	}
+
+	if len(apiServerObjects) != 1 {
+		t.Errorf("concurrency_test.go: expected only the shared object to be left but the server holds %d objects", len(apiServerObjects))
+	}
+	if sent, received := client.metrics.total(), int64(len(svr.Requests())); sent != received {
+		t.Errorf("concurrency_test.go: the client counted %d requests but the server received %d", sent, received)
+	}
+	if svr.TokensIssued() != 1 {
+		t.Errorf("concurrency_test.go: expected every worker to share one oauth token but %d were issued", svr.TokensIssued())
+	}
+	if client.metrics.classCount(statusClass5xx) != 0 || client.metrics.classCount(statusClassError) != 0 {
+		t.Errorf("concurrency_test.go: unexpected failures: %s", client.metrics.summary())
+	}
+}
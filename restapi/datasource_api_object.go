@@ -1,19 +1,22 @@
 package restapi
 
 import (
+	"context"
+	"fmt"
 	"log"
 
-	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 )
 
 func dataSourceRestAPI() *schema.Resource {
 	return &schema.Resource{
-		Read: dataSourceRestAPIRead,
+		ReadContext: traced("read", searchAddress, dataSourceRestAPIRead),
 
 		Schema: map[string]*schema.Schema{
 			"path": {
 				Type:        schema.TypeString,
-				Description: "The API path on top of the base URL set in the provider that represents objects of this type on the API server.",
+				Description: "The API path on top of the base URL set in the provider that represents objects of this type on the API server. Without `search_key` and `search_value`, this is the path of the object itself, which is read with a GET.",
 				Required:    true,
 			},
 			"search_path": {
@@ -22,9 +25,10 @@ func dataSourceRestAPI() *schema.Resource {
 				Optional:    true,
 			},
 			"query_string": {
-				Type:        schema.TypeString,
-				Description: "An optional query string to send when performing the search.",
-				Optional:    true,
+				Type:         schema.TypeString,
+				Description:  "An optional query string to send when performing the search.",
+				Optional:     true,
+				ValidateFunc: validateQueryString,
 			},
 			"read_query_string": {
 				Type: schema.TypeString,
@@ -36,14 +40,16 @@ func dataSourceRestAPI() *schema.Resource {
 				Optional:    true,
 			},
 			"search_key": {
-				Type:        schema.TypeString,
-				Description: "When reading search results from the API, this key is used to identify the specific record to read. This should be a unique record such as 'name'. Similar to results_key, the value may be in the format of 'field/field/field' to search for data deeper in the returned object.",
-				Required:    true,
+				Type:         schema.TypeString,
+				Description:  "When reading search results from the API, this key is used to identify the specific record to read. This should be a unique record such as 'name'. If more than one record matches, an error is returned. Similar to results_key, the value may be in the format of 'field/field/field' to search for data deeper in the returned object. If not set (along with `search_value`), the object at `path` is read directly.",
+				Optional:     true,
+				RequiredWith: []string{"search_value"},
 			},
 			"search_value": {
-				Type:        schema.TypeString,
-				Description: "The value of 'search_key' will be compared to this value to determine if the correct object was found. Example: if 'search_key' is 'name' and 'search_value' is 'foo', the record in the array returned by the API with name=foo will be used.",
-				Required:    true,
+				Type:         schema.TypeString,
+				Description:  "The value of 'search_key' will be compared to this value to determine if the correct object was found. Example: if 'search_key' is 'name' and 'search_value' is 'foo', the record in the array returned by the API with name=foo will be used.",
+				Optional:     true,
+				RequiredWith: []string{"search_key"},
 			},
 			"results_key": {
 				Type:        schema.TypeString,
@@ -60,12 +66,29 @@ func dataSourceRestAPI() *schema.Resource {
 				Description: "Whether to emit verbose debug output while working with the API object on the server.",
 				Optional:    true,
 			},
+			"max_response_bytes": {
+				Type:        schema.TypeInt,
+				Description: "Defaults to `max_response_bytes` set on the provider. Allows a bigger (or smaller) object to be read than the provider-wide limit. The search results are decoded as they arrive, so however large the collection is, only the matching object counts toward it.",
+				Optional:    true,
+			},
 			"api_data": {
 				Type:        schema.TypeMap,
 				Elem:        &schema.Schema{Type: schema.TypeString},
 				Description: "After data from the API server is read, this map will include k/v pairs usable in other terraform resources as readable objects. Currently the value is the golang fmt package's representation of the value (simple primitives are set as expected, but complex types like arrays and maps contain golang formatting).",
 				Computed:    true,
 			},
+			"extract": {
+				Type:        schema.TypeMap,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Optional:    true,
+				Description: "A map of output names to paths within the object returned by the API server (in the same format as `id_attribute`, such as `network/ips/0`). After the object is read, the value at each path is available under the output name in `extracted`.",
+			},
+			"extracted": {
+				Type:        schema.TypeMap,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Computed:    true,
+				Description: "The values found for each of the paths in `extract`. Scalars are given as they are, and hashes and arrays as JSON. A path that is not in the object gives an empty string.",
+			},
 			"api_response": {
 				Type:        schema.TypeString,
 				Description: "The raw body of the HTTP response from the last read of the object.",
@@ -76,7 +99,7 @@ func dataSourceRestAPI() *schema.Resource {
 	}
 }
 
-func dataSourceRestAPIRead(d *schema.ResourceData, meta interface{}) error {
+func dataSourceRestAPIRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	path := d.Get("path").(string)
 	searchPath := d.Get("search_path").(string)
 	queryString := d.Get("query_string").(string)
@@ -106,15 +129,35 @@ func dataSourceRestAPIRead(d *schema.ResourceData, meta interface{}) error {
 		debug:       debug,
 		queryString: readQueryString,
 		idAttribute: idAttribute,
+
+		maxResponseBytes: int64(d.Get("max_response_bytes").(int)),
+
+		/* Unlike a resource, nothing is lost if a data source
+		   sees what was read earlier in the run */
+		cachedReads: true,
+	}
+
+	/* Without a search, path is the object itself */
+	if searchKey == "" {
+		opts.getPath = path
 	}
 
 	obj, err := NewAPIObject(client, opts)
 	if err != nil {
-		return err
+		return errorDiagnostics("Invalid restapi_object data source configuration", err)
 	}
 
-	if _, err := obj.findObject(queryString, searchKey, searchValue, resultsKey); err != nil {
-		return err
+	if searchKey == "" {
+		if err := readObjectAtPath(ctx, obj); err != nil {
+			return errorDiagnostics(fmt.Sprintf("Could not read the object at '%s'", path), err)
+		}
+		log.Printf("datasource_api_object.go: Data resource. Read '%s' with id '%s'\n", path, obj.id)
+		d.SetId(obj.id)
+		return setResourceState(obj, d)
+	}
+
+	if _, err := obj.findObject(ctx, queryString, searchKey, searchValue, resultsKey); err != nil {
+		return errorDiagnostics(fmt.Sprintf("Could not find the object with '%s' = '%s' at '%s'", searchKey, searchValue, obj.searchPath), err)
 	}
 
 	/* Back to terraform-specific stuff. Create an api_object with the ID and refresh it object */
@@ -124,12 +167,37 @@ func dataSourceRestAPIRead(d *schema.ResourceData, meta interface{}) error {
 
 	d.SetId(obj.id)
 
-	err = obj.readObject()
-	if err == nil {
-		/* Setting terraform ID tells terraform the object was created or it exists */
-		log.Printf("datasource_api_object.go: Data resource. Returned id is '%s'\n", obj.id)
-		d.SetId(obj.id)
-		setResourceState(obj, d)
+	id := obj.id
+	if err := obj.readObject(ctx); err != nil {
+		return errorDiagnostics(fmt.Sprintf("Could not read the object '%s'", id), err)
+	}
+	/* A resource forgets an object that has gone, but there is
+	   nothing a data source can usefully say about one */
+	if obj.id == "" {
+		return errorDiagnostics(fmt.Sprintf("Could not read the object '%s'", id), fmt.Errorf("it was found at '%s' but is no longer there", obj.searchPath))
+	}
+
+	/* Setting terraform ID tells terraform the object was created or it exists */
+	log.Printf("datasource_api_object.go: Data resource. Returned id is '%s'\n", obj.id)
+	d.SetId(obj.id)
+	return setResourceState(obj, d)
+}
+
+/* Read the object at the path the data source was given, with no
+   search. Its id is the id_attribute of what comes back, or the path
+   itself if it has none (such as a settings document) */
+func readObjectAtPath(ctx context.Context, obj *APIObject) error {
+	resp, err := obj.apiClient.doRequest(obj.readMethod, appendQueryString(obj.getPath, obj.readQueryString), "", withContext(ctx), withMaxResponseBytes(obj.maxResponseBytes), withCachedRead(obj.cachedReads))
+	if err != nil {
+		return err
+	}
+
+	obj.id = obj.getPath
+	if err := obj.apiClient.responseError(resp, obj.updateState(resp.body)); err != nil {
+		return err
+	}
+	if id, err := GetStringAtKey(obj.apiData, obj.idAttribute, obj.debug); err == nil && id != "" {
+		obj.id = id
 	}
-	return err
+	return nil
 }
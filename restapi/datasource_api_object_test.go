@@ -9,11 +9,15 @@ package restapi
 
 import (
 	"fmt"
+	"net/http/httptest"
 	"os"
+	"regexp"
+	"strings"
 	"testing"
 
 	"github.com/Mastercard/terraform-provider-restapi/fakeserver"
-	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
 )
 
 func TestAccRestapiobject_Basic(t *testing.T) {
@@ -89,8 +93,8 @@ func TestAccRestapiobject_Basic(t *testing.T) {
 	*/
 
 	resource.UnitTest(t, resource.TestCase{
-		Providers: testAccProviders,
-		PreCheck:  func() { svr.StartInBackground() },
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		PreCheck:                 func() { svr.StartInBackground() },
 		Steps: []resource.TestStep{
 			{
 				Config: fmt.Sprintf(`
@@ -168,3 +172,173 @@ func TestAccRestapiobject_Basic(t *testing.T) {
 
 	svr.Shutdown()
 }
+
+func TestAccRestapiobject_Paginated(t *testing.T) {
+	debug := false
+	apiServerObjects := make(map[string]map[string]interface{})
+	for i := 1; i <= 6; i++ {
+		id := fmt.Sprintf("%d", i)
+		apiServerObjects[id] = map[string]interface{}{"id": id, "name": "object" + id, "team": "odd"}
+		if i%2 == 0 {
+			apiServerObjects[id]["team"] = "even"
+		}
+	}
+
+	svr := fakeserver.NewFakeServer(0, apiServerObjects, false, debug, "")
+	httpSvr := httptest.NewServer(svr.Handler())
+	defer httpSvr.Close()
+	os.Setenv("REST_API_URI", httpSvr.URL)
+
+	/* How many different pages of the list were read since the last
+	   check. The data source is read more than once in each step */
+	seen := 0
+	expectListPages := func(path string, expected int) resource.TestCheckFunc {
+		return func(s *terraform.State) error {
+			pages := make(map[string]bool)
+			requests := svr.Requests()
+			for _, r := range requests[seen:] {
+				if r.Method == "GET" && strings.HasPrefix(r.URL, path+"?") {
+					pages[r.URL] = true
+				}
+			}
+			seen = len(requests)
+			if len(pages) != expected {
+				return fmt.Errorf("datasource_api_object_test.go: expected %d pages of '%s' to be read but got %v", expected, path, pages)
+			}
+			return nil
+		}
+	}
+
+	resource.UnitTest(t, resource.TestCase{
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				/* The match is on the last of three pages linked with Link headers */
+				Config: fmt.Sprintf(`
+            data "restapi_object" "Last" {
+               path = "/api/objects"
+               query_string = "per_page=2"
+               search_key = "name"
+               search_value = "object6"
+               debug = %t
+            }
+          `, debug),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("data.restapi_object.Last", "id", "6"),
+					resource.TestCheckResourceAttr("data.restapi_object.Last", "api_data.team", "even"),
+					expectListPages("/api/objects", 3),
+				),
+			},
+			{
+				/* The same, through the envelope's results */
+				Config: fmt.Sprintf(`
+            data "restapi_object" "Enveloped" {
+               path = "/api/objects"
+               search_path = "/api/object_pages"
+               query_string = "per_page=2"
+               results_key = "results"
+               search_key = "name"
+               search_value = "object5"
+               debug = %t
+            }
+          `, debug),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("data.restapi_object.Enveloped", "id", "5"),
+					expectListPages("/api/object_pages", 3),
+				),
+			},
+			{
+				/* The server filters down to the even objects, which fit on one page */
+				Config: fmt.Sprintf(`
+            data "restapi_object" "Filtered" {
+               path = "/api/objects"
+               query_string = "per_page=3&search_key=team&search_value=even"
+               search_key = "name"
+               search_value = "object4"
+               debug = %t
+            }
+          `, debug),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("data.restapi_object.Filtered", "id", "4"),
+					expectListPages("/api/objects", 1),
+				),
+			},
+		},
+	})
+}
+
+func TestAccRestapiobject_Direct(t *testing.T) {
+	debug := false
+	apiServerObjects := map[string]map[string]interface{}{
+		"flags": {
+			"id": "flags",
+			"features": map[string]interface{}{
+				"beta":   true,
+				"limits": map[string]interface{}{"max": 10},
+			},
+		},
+	}
+
+	svr := fakeserver.NewFakeServer(0, apiServerObjects, false, debug, "")
+	httpSvr := httptest.NewServer(svr.Handler())
+	defer httpSvr.Close()
+	os.Setenv("REST_API_URI", httpSvr.URL)
+
+	expectRead := func(url string) resource.TestCheckFunc {
+		return func(s *terraform.State) error {
+			for _, r := range svr.Requests() {
+				if r.Method == "GET" && r.URL == url {
+					return nil
+				}
+			}
+			return fmt.Errorf("datasource_api_object_test.go: expected a GET of '%s' but got %v", url, svr.Requests())
+		}
+	}
+
+	resource.UnitTest(t, resource.TestCase{
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				/* No search - the path is the object */
+				Config: fmt.Sprintf(`
+            data "restapi_object" "Flags" {
+               path = "/api/objects/flags"
+               query_string = "version=2"
+               extract = {
+                 beta = "features/beta"
+                 max  = "features/limits/max"
+               }
+               debug = %t
+            }
+          `, debug),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("data.restapi_object.Flags", "id", "flags"),
+					resource.TestCheckResourceAttr("data.restapi_object.Flags", "extracted.beta", "true"),
+					resource.TestCheckResourceAttr("data.restapi_object.Flags", "extracted.max", "10"),
+					resource.TestCheckResourceAttr("data.restapi_object.Flags", "api_response", `{"features":{"beta":true,"limits":{"max":10}},"id":"flags"}`),
+					expectRead("/api/objects/flags?version=2"),
+				),
+			},
+			{
+				/* Without an id in what comes back, the path is the id */
+				Config: fmt.Sprintf(`
+            data "restapi_object" "Flags" {
+               path = "/api/objects/flags"
+               id_attribute = "name"
+               debug = %t
+            }
+          `, debug),
+				Check: resource.TestCheckResourceAttr("data.restapi_object.Flags", "id", "/api/objects/flags"),
+			},
+			{
+				Config: fmt.Sprintf(`
+            data "restapi_object" "Missing" {
+               path = "/api/objects/missing"
+               debug = %t
+            }
+          `, debug),
+				ExpectError: regexp.MustCompile(`(?s)Could not read the object at '/api/objects/missing'.*404`),
+			},
+		},
+	})
+}
@@ -0,0 +1,77 @@
+package restapi
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/datasource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+/*providerInfoDataSource reports on the provider itself. It is the
+  first data source served by the framework half of the provider */
+type providerInfoDataSource struct {
+	data *frameworkProviderData
+}
+
+type providerInfoModel struct {
+	ID        types.String `tfsdk:"id"`
+	Version   types.String `tfsdk:"version"`
+	UserAgent types.String `tfsdk:"user_agent"`
+}
+
+func newProviderInfoDataSource() datasource.DataSource {
+	return &providerInfoDataSource{}
+}
+
+func (ds *providerInfoDataSource) Metadata(ctx context.Context, req datasource.MetadataRequest, resp *datasource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_provider_info"
+}
+
+func (ds *providerInfoDataSource) Schema(ctx context.Context, req datasource.SchemaRequest, resp *datasource.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		Description: "Information about the running restapi provider.",
+		Attributes: map[string]schema.Attribute{
+			"id": schema.StringAttribute{
+				Description: "Always `restapi`.",
+				Computed:    true,
+			},
+			"version": schema.StringAttribute{
+				Description: "The version of the provider.",
+				Computed:    true,
+			},
+			"user_agent": schema.StringAttribute{
+				Description: "The User-Agent the provider sends with requests to the API, unless it is overridden in `headers`.",
+				Computed:    true,
+			},
+		},
+	}
+}
+
+func (ds *providerInfoDataSource) Configure(ctx context.Context, req datasource.ConfigureRequest, resp *datasource.ConfigureResponse) {
+	/* Not set when terraform validates the configuration */
+	if req.ProviderData == nil {
+		return
+	}
+	data, ok := req.ProviderData.(*frameworkProviderData)
+	if !ok {
+		resp.Diagnostics.AddError("Unexpected provider data", fmt.Sprintf("datasource_provider_info.go: Expected *frameworkProviderData but got %T", req.ProviderData))
+		return
+	}
+	ds.data = data
+}
+
+func (ds *providerInfoDataSource) Read(ctx context.Context, req datasource.ReadRequest, resp *datasource.ReadResponse) {
+	if ds.data == nil {
+		resp.Diagnostics.AddError("Provider not configured", "datasource_provider_info.go: The provider must be configured before restapi_provider_info can be read")
+		return
+	}
+
+	state := providerInfoModel{
+		ID:        types.StringValue("restapi"),
+		Version:   types.StringValue(ds.data.version),
+		UserAgent: types.StringValue(ds.data.userAgent),
+	}
+	resp.Diagnostics.Append(resp.State.Set(ctx, &state)...)
+}
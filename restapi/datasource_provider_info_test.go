@@ -0,0 +1,67 @@
+package restapi
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+func TestAccRestapiProviderInfo(t *testing.T) {
+	/* Remembers the User-Agent the SDK half sent */
+	var mutex sync.Mutex
+	sent := ""
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		sent = r.Header.Get("User-Agent")
+		if r.Method == "DELETE" {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{ "id": "1", "name": "Foo" }`))
+	}))
+	defer svr.Close()
+
+	resource.UnitTest(t, resource.TestCase{
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: fmt.Sprintf(`
+provider "restapi" {
+  uri = "%s"
+}
+
+resource "restapi_object" "Foo" {
+  path = "/api/objects"
+  data = "{ \"id\": \"1\", \"name\": \"Foo\" }"
+}
+
+data "restapi_provider_info" "info" {
+  depends_on = [restapi_object.Foo]
+}
+`, svr.URL),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("data.restapi_provider_info.info", "version", "test"),
+					func(s *terraform.State) error {
+						mutex.Lock()
+						defer mutex.Unlock()
+						got := s.RootModule().Resources["data.restapi_provider_info.info"].Primary.Attributes["user_agent"]
+						if got != sent {
+							return fmt.Errorf("datasource_provider_info_test.go: Expected user_agent '%s' to be what was sent to the API, '%s'", got, sent)
+						}
+						if !strings.HasSuffix(got, " terraform-provider-restapi/test") {
+							return fmt.Errorf("datasource_provider_info_test.go: Unexpected user_agent '%s'", got)
+						}
+						return nil
+					},
+				),
+			},
+		},
+	})
+}
@@ -0,0 +1,90 @@
+package restapi
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+/*getDelta compares the data terraform has recorded for an object with
+  what the API server returned, and gives back the recorded data with
+  any differences made on the server applied to it, along with whether
+  there were any. Keys only the server has count as differences, and
+  keys the server dropped are removed. Paths in ignoreList (in the
+  format GetObjectAtKey takes) are left as they were recorded, and
+  neither their server value nor their absence counts as a change */
+func getDelta(recorded map[string]interface{}, actual map[string]interface{}, ignoreList []string) (map[string]interface{}, bool) {
+	ignore := make(map[string]bool)
+	for _, path := range ignoreList {
+		path = strings.Trim(normalizeKeyPath(actual, path), "/")
+		if path != "" {
+			ignore[path] = true
+		}
+	}
+
+	return deltaMap(recorded, actual, ignore, "")
+}
+
+func deltaMap(recorded map[string]interface{}, actual map[string]interface{}, ignore map[string]bool, prefix string) (map[string]interface{}, bool) {
+	result := make(map[string]interface{})
+	changed := false
+
+	for key, recordedValue := range recorded {
+		path := prefix + key
+		if ignore[path] {
+			result[key] = recordedValue
+			continue
+		}
+		actualValue, ok := actual[key]
+		if !ok {
+			changed = true
+			continue
+		}
+		value, valueChanged := deltaValue(recordedValue, actualValue, ignore, path)
+		result[key] = value
+		changed = changed || valueChanged
+	}
+
+	for key, actualValue := range actual {
+		if _, ok := recorded[key]; ok || ignore[prefix+key] {
+			continue
+		}
+		result[key] = actualValue
+		changed = true
+	}
+
+	return result, changed
+}
+
+/* Hashes, and lists of the same length, are compared element by element
+   so paths inside them can be ignored. Anything else is taken from the
+   server if it differs at all */
+func deltaValue(recorded interface{}, actual interface{}, ignore map[string]bool, path string) (interface{}, bool) {
+	switch recordedValue := recorded.(type) {
+	case map[string]interface{}:
+		if actualValue, ok := actual.(map[string]interface{}); ok {
+			return deltaMap(recordedValue, actualValue, ignore, path+"/")
+		}
+	case []interface{}:
+		if actualValue, ok := actual.([]interface{}); ok && len(actualValue) == len(recordedValue) {
+			result := make([]interface{}, len(recordedValue))
+			changed := false
+			for i := range recordedValue {
+				elemPath := fmt.Sprintf("%s/%d", path, i)
+				if ignore[elemPath] {
+					result[i] = recordedValue[i]
+					continue
+				}
+				value, elemChanged := deltaValue(recordedValue[i], actualValue[i], ignore, elemPath)
+				result[i] = value
+				changed = changed || elemChanged
+			}
+			return result, changed
+		}
+	}
+
+	if reflect.DeepEqual(recorded, actual) {
+		return recorded, false
+	}
+	return actual, true
+}
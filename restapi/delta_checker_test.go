@@ -0,0 +1,42 @@
+package restapi
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestGetDelta(t *testing.T) {
+	cases := []struct {
+		recorded string
+		actual   string
+		ignore   []string
+		expected string
+		changed  bool
+	}{
+		{recorded: `{"a": 1}`, actual: `{"a": 1}`, expected: `{"a": 1}`, changed: false},
+		{recorded: `{"a": 1}`, actual: `{"a": 2}`, expected: `{"a": 2}`, changed: true},
+		{recorded: `{"a": 1}`, actual: `{"a": 1, "updated_at": "now"}`, expected: `{"a": 1, "updated_at": "now"}`, changed: true},
+		{recorded: `{"a": 1}`, actual: `{"a": 1, "updated_at": "now"}`, ignore: []string{"updated_at"}, expected: `{"a": 1}`, changed: false},
+		{recorded: `{"a": 1, "updated_at": "then"}`, actual: `{"a": 1, "updated_at": "now"}`, ignore: []string{"updated_at"}, expected: `{"a": 1, "updated_at": "then"}`, changed: false},
+		{recorded: `{"a": 1, "b": 2}`, actual: `{"a": 1}`, expected: `{"a": 1}`, changed: true},
+		{recorded: `{"a": 1, "b": 2}`, actual: `{"a": 1}`, ignore: []string{"b"}, expected: `{"a": 1, "b": 2}`, changed: false},
+		{recorded: `{"meta": {"name": "x"}}`, actual: `{"meta": {"name": "x", "hash": "abc"}}`, ignore: []string{"meta/hash"}, expected: `{"meta": {"name": "x"}}`, changed: false},
+		{recorded: `{"meta": {"name": "x"}}`, actual: `{"meta": {"name": "y", "hash": "abc"}}`, ignore: []string{"meta.hash"}, expected: `{"meta": {"name": "y"}}`, changed: true},
+		{recorded: `{"list": [{"n": 1}]}`, actual: `{"list": [{"n": 1, "seen": true}]}`, ignore: []string{"list/0/seen"}, expected: `{"list": [{"n": 1}]}`, changed: false},
+		{recorded: `{"list": [1, 2]}`, actual: `{"list": [1, 2, 3]}`, expected: `{"list": [1, 2, 3]}`, changed: true},
+	}
+
+	for _, c := range cases {
+		var recorded, actual, expected map[string]interface{}
+		decodeJSON(c.recorded, &recorded)
+		decodeJSON(c.actual, &actual)
+		decodeJSON(c.expected, &expected)
+
+		got, changed := getDelta(recorded, actual, c.ignore)
+		gotJSON, _ := json.Marshal(got)
+		expectedJSON, _ := json.Marshal(expected)
+		if changed != c.changed || string(gotJSON) != string(expectedJSON) {
+			t.Errorf("delta_checker_test.go: getDelta(%s, %s, %v) gave %s (changed: %t) but expected %s (changed: %t)", c.recorded, c.actual, c.ignore, gotJSON, changed, expectedJSON, c.changed)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package restapi
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+)
+
+/* Turn an error into a diagnostic with the given summary. When the
+   error came from the API, the status code and request id are called
+   out at the top of the detail so they are easy to quote to whoever
+   runs the API */
+func errorDiagnostics(summary string, err error) diag.Diagnostics {
+	if err == nil {
+		return nil
+	}
+
+	var buffer bytes.Buffer
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		if apiErr.StatusCode != 0 {
+			buffer.WriteString(fmt.Sprintf("Status code: %d\n", apiErr.StatusCode))
+		}
+		if apiErr.RequestID != "" {
+			buffer.WriteString(fmt.Sprintf("Request ID: %s\n", apiErr.RequestID))
+		}
+		if buffer.Len() > 0 {
+			buffer.WriteString("\n")
+		}
+	}
+	buffer.WriteString(err.Error())
+
+	if _, advice := classifyConnectivity(err); advice != "" {
+		buffer.WriteString("\n\n")
+		buffer.WriteString(advice)
+	}
+
+	return diag.Diagnostics{{
+		Severity: diag.Error,
+		Summary:  summary,
+		Detail:   buffer.String(),
+	}}
+}
+
+/* The same as errorDiagnostics, for an error writing data. When the API
+   listed the fields it objected to, each one that can be found in data
+   gets a diagnostic pointing at the data attribute. If any cannot be
+   found, the error (listing them all) is reported for the resource too */
+func writeDiagnostics(summary string, err error, data map[string]interface{}) diag.Diagnostics {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || len(apiErr.Violations) == 0 {
+		return errorDiagnostics(summary, err)
+	}
+
+	var diags diag.Diagnostics
+	unplaced := false
+	for _, v := range apiErr.Violations {
+		path := fieldKeyPath(v.Field)
+		if path == "" {
+			unplaced = true
+			continue
+		}
+		if _, lookupErr := GetObjectAtKey(data, path, false); lookupErr != nil {
+			unplaced = true
+			continue
+		}
+		diags = append(diags, diag.Diagnostic{
+			Severity:      diag.Error,
+			Summary:       summary,
+			Detail:        fmt.Sprintf("The API rejected the value of '%s' in data: %s", path, v.Message),
+			AttributePath: cty.GetAttrPath("data"),
+		})
+	}
+	if unplaced {
+		diags = append(diags, errorDiagnostics(summary, err)...)
+	}
+	return diags
+}
+
+/* Turn the name an API gives a field (such as `spec.ports[2].name`,
+   `$.spec.ports.2.name` or `/spec/ports/2/name`) into the path format
+   GetObjectAtKey takes (`spec/ports/2/name`) */
+func fieldKeyPath(field string) string {
+	field = strings.TrimSpace(field)
+
+	/* A JSON pointer */
+	if strings.HasPrefix(field, "/") {
+		parts := strings.Split(strings.TrimPrefix(field, "/"), "/")
+		for i, part := range parts {
+			parts[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(part)
+		}
+		return strings.Join(parts, "/")
+	}
+
+	field = strings.TrimPrefix(strings.TrimPrefix(field, "$"), ".")
+	var parts []string
+	var part strings.Builder
+	for i := 0; i < len(field); i++ {
+		switch c := field[i]; c {
+		case '.':
+			if part.Len() > 0 {
+				parts = append(parts, part.String())
+				part.Reset()
+			}
+		case '[':
+			if part.Len() > 0 {
+				parts = append(parts, part.String())
+				part.Reset()
+			}
+			end := strings.IndexByte(field[i:], ']')
+			if end < 0 {
+				return ""
+			}
+			index := field[i+1 : i+end]
+			if unquoted, err := strconv.Unquote(index); err == nil {
+				index = unquoted
+			} else if strings.HasPrefix(index, "'") && strings.HasSuffix(index, "'") && len(index) > 1 {
+				index = index[1 : len(index)-1]
+			}
+			parts = append(parts, index)
+			i += end
+		default:
+			part.WriteByte(c)
+		}
+	}
+	if part.Len() > 0 {
+		parts = append(parts, part.String())
+	}
+	return strings.Join(parts, "/")
+}
+
+/* The values read_failure_mode takes */
+const (
+	readFailureError         = "error"
+	readFailureWarnKeepState = "warn_keep_state"
+)
+
+/* With read_failure_mode set to warn_keep_state, refreshing an object
+   that failed because the API was unavailable (a 5xx, or a request that
+   got no response at all) is only a warning, and the caller leaves the
+   state as it was. keep is false for any other failure - including ones
+   that stopped the request being sent, such as no oauth token or an open
+   circuit - which the caller reports as usual */
+func readFailureDiagnostics(client *APIClient, what string, err error) (diags diag.Diagnostics, keep bool) {
+	var apiErr *APIError
+	if client.readFailureMode != readFailureWarnKeepState || !errors.As(err, &apiErr) {
+		return nil, false
+	}
+	if !apiErr.NoResponse && apiErr.StatusCode < 500 {
+		return nil, false
+	}
+
+	diags = errorDiagnostics(fmt.Sprintf("%s was NOT refreshed", what), err)
+	diags[0].Severity = diag.Warning
+	diags[0].Detail += "\n\nThe values from the last refresh have been kept (read_failure_mode = \"warn_keep_state\"), so changes made to it outside of terraform since then are not part of this plan."
+	return diags, true
+}
+
+/* Summaries for the ways reaching the API can fail. They are kept
+   distinct (and stable) so they can be searched for in CI logs */
+const (
+	summaryConfigure   = "Could not configure the restapi provider"
+	summaryDNS         = "Could not resolve the API host"
+	summaryRefused     = "The API refused the connection"
+	summaryTimeout     = "The API did not respond in time"
+	summaryCertificate = "Could not verify the API's TLS certificate"
+	summaryHTTP        = "The API returned an error"
+)
+
+/* The same as errorDiagnostics, for an error configuring the provider.
+   When that was a failure to reach the API, the summary says how */
+func configureDiagnostics(err error) diag.Diagnostics {
+	if err == nil {
+		return nil
+	}
+	summary, _ := classifyConnectivity(err)
+	if summary == "" {
+		summary = summaryConfigure
+	}
+	return errorDiagnostics(summary, err)
+}
+
+/* Work out why a request could not reach the API (or what the API said
+   when it did) and what to do about it. Both are empty for anything else */
+func classifyConnectivity(err error) (summary string, advice string) {
+	var dnsErr *net.DNSError
+	var unknownAuthority x509.UnknownAuthorityError
+	var invalidCert x509.CertificateInvalidError
+	var hostnameErr x509.HostnameError
+	var verifyErr *tls.CertificateVerificationError
+	var netErr net.Error
+	var apiErr *APIError
+
+	switch {
+	case errors.As(err, &dnsErr):
+		return summaryDNS, fmt.Sprintf("The host '%s' could not be found. Check the hostname in uri (or oauth_token_endpoint) is spelled correctly and can be resolved from this machine (or map it with host_overrides).", dnsErr.Name)
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return summaryRefused, "Nothing accepted the connection. Check the port in uri is the one the API listens on, that the API is running, and that no firewall is rejecting connections from this machine."
+	case errors.As(err, &unknownAuthority):
+		return summaryCertificate, certificateAdvice("is signed by an authority this machine does not trust", unknownAuthority.Cert)
+	case errors.As(err, &hostnameErr):
+		return summaryCertificate, certificateAdvice(fmt.Sprintf("is not valid for the host '%s'", hostnameErr.Host), hostnameErr.Certificate)
+	case errors.As(err, &invalidCert):
+		return summaryCertificate, certificateAdvice(fmt.Sprintf("is not valid (%s)", invalidCert.Error()), invalidCert.Cert)
+	case errors.As(err, &verifyErr):
+		var cert *x509.Certificate
+		if len(verifyErr.UnverifiedCertificates) > 0 {
+			cert = verifyErr.UnverifiedCertificates[0]
+		}
+		return summaryCertificate, certificateAdvice(fmt.Sprintf("could not be verified (%s)", verifyErr.Err), cert)
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return summaryTimeout, "The request timed out. Check that the API is reachable from this machine (and not behind a proxy that needs configuring), or raise timeout if it is just slow."
+	case errors.As(err, &apiErr) && apiErr.StatusCode != 0 && (apiErr.StatusCode < 200 || apiErr.StatusCode >= 300):
+		return fmt.Sprintf("%s (%d)", summaryHTTP, apiErr.StatusCode), ""
+	}
+	return "", ""
+}
+
+/* Describes the certificate that failed verification */
+func certificateAdvice(problem string, cert *x509.Certificate) string {
+	var buffer bytes.Buffer
+	buffer.WriteString(fmt.Sprintf("The certificate the API presented %s.", problem))
+	if cert != nil {
+		buffer.WriteString(fmt.Sprintf("\n  Subject: %s\n  Issuer:  %s", cert.Subject, cert.Issuer))
+		if len(cert.DNSNames) > 0 {
+			buffer.WriteString(fmt.Sprintf("\n  Names:   %s", strings.Join(cert.DNSNames, ", ")))
+		}
+	}
+	buffer.WriteString("\nIf the API uses a private CA, add it to the trust store of this machine (or point SSL_CERT_FILE at it). Setting insecure skips verification entirely and should only be used for testing.")
+	return buffer.String()
+}
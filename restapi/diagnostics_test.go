@@ -0,0 +1,259 @@
+package restapi
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+/* A timeout the way net reports one */
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestClassifyConnectivity(t *testing.T) {
+	cert := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "api.internal"},
+		Issuer:   pkix.Name{CommonName: "Example Private CA"},
+		DNSNames: []string{"api.internal"},
+	}
+	transportErr := func(err error) error {
+		return newAPIError("GET", "https://api.example.com/status", nil, "", defaultErrorBodyLength,
+			&url.Error{Op: "Get", URL: "https://api.example.com/status", Err: err})
+	}
+
+	cases := []struct {
+		name    string
+		err     error
+		summary string
+		advice  []string
+	}{
+		{
+			name:    "dns",
+			err:     transportErr(&net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "api.exmaple.com", IsNotFound: true}}),
+			summary: summaryDNS,
+			advice:  []string{"'api.exmaple.com'", "hostname in uri"},
+		},
+		{
+			name:    "refused",
+			err:     transportErr(&net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}),
+			summary: summaryRefused,
+			advice:  []string{"port in uri", "firewall"},
+		},
+		{
+			name:    "unknown authority",
+			err:     transportErr(x509.UnknownAuthorityError{Cert: cert}),
+			summary: summaryCertificate,
+			advice:  []string{"authority this machine does not trust", "Subject: CN=api.internal", "Issuer:  CN=Example Private CA", "insecure"},
+		},
+		{
+			name:    "hostname",
+			err:     transportErr(x509.HostnameError{Certificate: cert, Host: "api.example.com"}),
+			summary: summaryCertificate,
+			advice:  []string{"not valid for the host 'api.example.com'", "Names:   api.internal"},
+		},
+		{
+			name:    "expired",
+			err:     transportErr(x509.CertificateInvalidError{Cert: cert, Reason: x509.Expired}),
+			summary: summaryCertificate,
+			advice:  []string{"is not valid", "Subject: CN=api.internal"},
+		},
+		{
+			name:    "timeout",
+			err:     transportErr(timeoutError{}),
+			summary: summaryTimeout,
+			advice:  []string{"raise timeout"},
+		},
+		{
+			name:    "http",
+			err:     newAPIError("GET", "https://api.example.com/status", &http.Response{StatusCode: 503, Header: http.Header{}}, "down for maintenance", defaultErrorBodyLength, nil),
+			summary: summaryHTTP + " (503)",
+		},
+		{
+			name: "other",
+			err:  errors.New("could not load the client certificate"),
+		},
+	}
+
+	for _, c := range cases {
+		summary, advice := classifyConnectivity(c.err)
+		if summary != c.summary {
+			t.Errorf("diagnostics_test.go: %s: expected the summary '%s' but got '%s'", c.name, c.summary, summary)
+		}
+		for _, expected := range c.advice {
+			if !strings.Contains(advice, expected) {
+				t.Errorf("diagnostics_test.go: %s: expected the advice to mention '%s' but got: %s", c.name, expected, advice)
+			}
+		}
+		if len(c.advice) == 0 && advice != "" {
+			t.Errorf("diagnostics_test.go: %s: expected no advice but got: %s", c.name, advice)
+		}
+
+		/* Resources pass their own summary, but get the advice too */
+		diags := errorDiagnostics("Could not read the object", c.err)
+		if len(c.advice) > 0 && !strings.Contains(diags[0].Detail, advice) {
+			t.Errorf("diagnostics_test.go: %s: expected the advice in the detail but got: %s", c.name, diags[0].Detail)
+		}
+	}
+}
+
+func TestConfigureDiagnostics(t *testing.T) {
+	/* A port that was just listening is (almost certainly) refused now */
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedURI := "http://" + listener.Addr().String()
+	listener.Close()
+
+	/* A certificate nothing trusts */
+	tlsSvr := httptest.NewTLSServer(http.NotFoundHandler())
+	defer tlsSvr.Close()
+
+	/* And an API that is up, but not answering the test path */
+	httpSvr := httptest.NewServer(http.NotFoundHandler())
+	defer httpSvr.Close()
+
+	cases := map[string]string{
+		closedURI:   summaryRefused,
+		tlsSvr.URL:  summaryCertificate,
+		httpSvr.URL: summaryHTTP + " (404)",
+	}
+	for uri, summary := range cases {
+		raw := map[string]interface{}{
+			"uri":                       uri,
+			"test_path":                 "/status",
+			"timeout":                   2,
+			"circuit_breaker_threshold": 0,
+		}
+		diags := Provider().Configure(context.Background(), terraform.NewResourceConfigRaw(raw))
+		if !diags.HasError() || diags[0].Summary != summary {
+			t.Errorf("diagnostics_test.go: %s: expected the summary '%s' but got %v", uri, summary, diags)
+		}
+	}
+}
+
+/* The same, for errors that come back from a real client rather
+   than being put together by hand */
+func TestConfigureDiagnosticsFromClient(t *testing.T) {
+	release := make(chan struct{})
+	slowSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slowSvr.Close()
+	defer close(release)
+
+	cases := []struct {
+		name    string
+		raw     map[string]interface{}
+		summary string
+	}{
+		{
+			name: "timeout",
+			raw: map[string]interface{}{
+				"uri":       slowSvr.URL,
+				"test_path": "/status",
+				"timeout":   1,
+			},
+			summary: summaryTimeout,
+		},
+		{
+			/* .invalid never resolves */
+			name: "token endpoint",
+			raw: map[string]interface{}{
+				"uri":       slowSvr.URL,
+				"test_path": "/status",
+				"timeout":   2,
+				"oauth_client_credentials": []interface{}{map[string]interface{}{
+					"oauth_client_id":      "id",
+					"oauth_client_secret":  "secret",
+					"oauth_token_endpoint": "http://token.invalid/token",
+				}},
+			},
+			summary: summaryDNS,
+		},
+	}
+	for _, c := range cases {
+		diags := Provider().Configure(context.Background(), terraform.NewResourceConfigRaw(c.raw))
+		if !diags.HasError() || diags[0].Summary != c.summary {
+			t.Errorf("diagnostics_test.go: %s: expected the summary '%s' but got %v", c.name, c.summary, diags)
+		}
+	}
+
+	/* And the error itself can still be picked apart */
+	client, err := NewAPIClient(&apiClientOpt{uri: slowSvr.URL, timeout: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.sendRequest("GET", "/status", "")
+	var netErr net.Error
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("diagnostics_test.go: expected the timeout to be in the error chain but got: %v", err)
+	}
+}
+
+func TestFieldKeyPath(t *testing.T) {
+	cases := map[string]string{
+		"name":                     "name",
+		"radius.reply[2].value":    "radius/reply/2/value",
+		"$.radius.reply.2.value":   "radius/reply/2/value",
+		"/radius/reply/2/value":    "radius/reply/2/value",
+		"/labels/app~1name":        "labels/app/name",
+		`labels["app.name"]`:       "labels/app.name",
+		"labels['team'].owners[0]": "labels/team/owners/0",
+		"matrix[1][2]":             "matrix/1/2",
+		"broken[1":                 "",
+		"":                         "",
+	}
+	for field, expected := range cases {
+		if path := fieldKeyPath(field); path != expected {
+			t.Errorf("diagnostics_test.go: expected '%s' to become '%s' but got '%s'", field, expected, path)
+		}
+	}
+}
+
+func TestWriteDiagnostics(t *testing.T) {
+	var data map[string]interface{}
+	if err := decodeJSON(`{"name": "web", "radius": {"reply": [{"value": "a"}, {"value": "b"}, {"value": "c"}]}}`, &data); err != nil {
+		t.Fatal(err)
+	}
+	resp := &http.Response{StatusCode: 422, Header: http.Header{}}
+	violation := func(body string) error {
+		return newAPIError("PUT", "http://127.0.0.1/api/objects/1", resp, body, defaultErrorBodyLength, nil)
+	}
+
+	/* Each field that is in data gets a diagnostic of its own */
+	diags := writeDiagnostics("Could not update", violation(`{"errors":[{"field":"radius.reply[2].value","message":"invalid IP"},{"field":"name","message":"taken"}]}`), data)
+	if len(diags) != 2 {
+		t.Fatalf("diagnostics_test.go: expected a diagnostic per field but got %v", diags)
+	}
+	if diags[0].Detail != "The API rejected the value of 'radius/reply/2/value' in data: invalid IP" || !diags[0].AttributePath.Equals(cty.GetAttrPath("data")) {
+		t.Errorf("diagnostics_test.go: unexpected diagnostic %+v", diags[0])
+	}
+
+	/* A field that is not is reported with the rest for the resource */
+	diags = writeDiagnostics("Could not update", violation(`{"errors":[{"field":"name","message":"taken"},{"field":"radius.reply[5].value","message":"invalid IP"}]}`), data)
+	if len(diags) != 2 || diags[1].AttributePath != nil || !strings.Contains(diags[1].Detail, "name: taken; radius.reply[5].value: invalid IP") {
+		t.Errorf("diagnostics_test.go: expected the unknown field to be reported for the resource but got %v", diags)
+	}
+
+	/* Anything else is as errorDiagnostics reports it */
+	diags = writeDiagnostics("Could not update", violation(`{"error":{"message":"nope"}}`), data)
+	if len(diags) != 1 || diags[0].AttributePath != nil {
+		t.Errorf("diagnostics_test.go: expected a single diagnostic for the resource but got %v", diags)
+	}
+}
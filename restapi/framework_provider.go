@@ -0,0 +1,200 @@
+package restapi
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/provider"
+	"github.com/hashicorp/terraform-plugin-framework/provider/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov5"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+)
+
+/*frameworkProvider is the terraform-plugin-framework half of the
+  provider. It is served alongside the SDK half, so new resources can
+  be written with the framework while the existing ones stay as they are */
+type frameworkProvider struct {
+	version string
+	commit  string
+}
+
+/* What the framework half hands to its resources and data sources */
+type frameworkProviderData struct {
+	version   string
+	userAgent string
+}
+
+/*NewFrameworkProvider returns a function that builds the framework
+  half of the provider for the given release version and commit */
+func NewFrameworkProvider(version string, commit string) func() provider.Provider {
+	return func() provider.Provider {
+		return &frameworkProvider{version: version, commit: commit}
+	}
+}
+
+func (p *frameworkProvider) Metadata(ctx context.Context, req provider.MetadataRequest, resp *provider.MetadataResponse) {
+	resp.TypeName = "restapi"
+	resp.Version = p.version
+}
+
+/* Terraform only accepts the two halves if their provider schemas are
+   identical, so this one is built from the SDK half's rather than kept
+   in step by hand */
+func (p *frameworkProvider) Schema(ctx context.Context, req provider.SchemaRequest, resp *provider.SchemaResponse) {
+	sdkResp, err := New(p.version, p.commit)().GRPCProvider().GetProviderSchema(ctx, &tfprotov5.GetProviderSchemaRequest{})
+	if err != nil {
+		resp.Diagnostics.AddError("Could not read the provider schema", err.Error())
+		return
+	}
+	for _, d := range sdkResp.Diagnostics {
+		if d.Severity == tfprotov5.DiagnosticSeverityError {
+			resp.Diagnostics.AddError(d.Summary, d.Detail)
+		}
+	}
+	if resp.Diagnostics.HasError() || sdkResp.Provider == nil {
+		return
+	}
+
+	attributes, blocks, diags := frameworkSchemaBlock(sdkResp.Provider.Block)
+	resp.Diagnostics.Append(diags...)
+	resp.Schema = schema.Schema{
+		Attributes:  attributes,
+		Blocks:      blocks,
+		Description: sdkResp.Provider.Block.Description,
+	}
+}
+
+/* The settings themselves are used by the SDK half, which talks to the API */
+func (p *frameworkProvider) Configure(ctx context.Context, req provider.ConfigureRequest, resp *provider.ConfigureResponse) {
+	data := &frameworkProviderData{
+		version:   p.version,
+		userAgent: userAgent(req.TerraformVersion, p.version, p.commit),
+	}
+	resp.DataSourceData = data
+	resp.ResourceData = data
+}
+
+func (p *frameworkProvider) DataSources(ctx context.Context) []func() datasource.DataSource {
+	return []func() datasource.DataSource{
+		newProviderInfoDataSource,
+	}
+}
+
+func (p *frameworkProvider) Resources(ctx context.Context) []func() resource.Resource {
+	return []func() resource.Resource{}
+}
+
+/* Convert a block of the SDK's protocol schema into framework attributes and blocks */
+func frameworkSchemaBlock(block *tfprotov5.SchemaBlock) (map[string]schema.Attribute, map[string]schema.Block, diag.Diagnostics) {
+	var diags diag.Diagnostics
+	attributes := make(map[string]schema.Attribute)
+	blocks := make(map[string]schema.Block)
+
+	for _, a := range block.Attributes {
+		attribute, err := frameworkSchemaAttribute(a)
+		if err != nil {
+			diags.AddError("Could not convert the provider schema", err.Error())
+			continue
+		}
+		attributes[a.Name] = attribute
+	}
+
+	for _, b := range block.BlockTypes {
+		nestedAttributes, nestedBlocks, nestedDiags := frameworkSchemaBlock(b.Block)
+		diags.Append(nestedDiags...)
+		nested := schema.NestedBlockObject{
+			Attributes: nestedAttributes,
+			Blocks:     nestedBlocks,
+		}
+		deprecation := deprecationMessage(b.Block.Deprecated)
+		switch b.Nesting {
+		case tfprotov5.SchemaNestedBlockNestingModeList:
+			blocks[b.TypeName] = schema.ListNestedBlock{NestedObject: nested, Description: b.Block.Description, DeprecationMessage: deprecation}
+		case tfprotov5.SchemaNestedBlockNestingModeSet:
+			blocks[b.TypeName] = schema.SetNestedBlock{NestedObject: nested, Description: b.Block.Description, DeprecationMessage: deprecation}
+		case tfprotov5.SchemaNestedBlockNestingModeSingle:
+			blocks[b.TypeName] = schema.SingleNestedBlock{Attributes: nestedAttributes, Blocks: nestedBlocks, Description: b.Block.Description, DeprecationMessage: deprecation}
+		default:
+			diags.AddError("Could not convert the provider schema", fmt.Sprintf("framework_provider.go: Block '%s' has unsupported nesting mode %s", b.TypeName, b.Nesting))
+		}
+	}
+
+	return attributes, blocks, diags
+}
+
+func frameworkSchemaAttribute(a *tfprotov5.SchemaAttribute) (schema.Attribute, error) {
+	deprecation := deprecationMessage(a.Deprecated)
+
+	switch {
+	case a.Type.Is(tftypes.String):
+		return schema.StringAttribute{Description: a.Description, Required: a.Required, Optional: a.Optional, Sensitive: a.Sensitive, DeprecationMessage: deprecation}, nil
+	case a.Type.Is(tftypes.Bool):
+		return schema.BoolAttribute{Description: a.Description, Required: a.Required, Optional: a.Optional, Sensitive: a.Sensitive, DeprecationMessage: deprecation}, nil
+	case a.Type.Is(tftypes.Number):
+		return schema.NumberAttribute{Description: a.Description, Required: a.Required, Optional: a.Optional, Sensitive: a.Sensitive, DeprecationMessage: deprecation}, nil
+	}
+
+	elemType, err := frameworkAttrType(a.Type)
+	if err != nil {
+		return nil, fmt.Errorf("framework_provider.go: Attribute '%s': %s", a.Name, err)
+	}
+	switch t := elemType.(type) {
+	case types.MapType:
+		return schema.MapAttribute{ElementType: t.ElemType, Description: a.Description, Required: a.Required, Optional: a.Optional, Sensitive: a.Sensitive, DeprecationMessage: deprecation}, nil
+	case types.ListType:
+		return schema.ListAttribute{ElementType: t.ElemType, Description: a.Description, Required: a.Required, Optional: a.Optional, Sensitive: a.Sensitive, DeprecationMessage: deprecation}, nil
+	case types.SetType:
+		return schema.SetAttribute{ElementType: t.ElemType, Description: a.Description, Required: a.Required, Optional: a.Optional, Sensitive: a.Sensitive, DeprecationMessage: deprecation}, nil
+	case types.ObjectType:
+		return schema.ObjectAttribute{AttributeTypes: t.AttrTypes, Description: a.Description, Required: a.Required, Optional: a.Optional, Sensitive: a.Sensitive, DeprecationMessage: deprecation}, nil
+	}
+	return nil, fmt.Errorf("framework_provider.go: Attribute '%s' has unsupported type %s", a.Name, a.Type)
+}
+
+func frameworkAttrType(t tftypes.Type) (attr.Type, error) {
+	switch {
+	case t.Is(tftypes.String):
+		return types.StringType, nil
+	case t.Is(tftypes.Bool):
+		return types.BoolType, nil
+	case t.Is(tftypes.Number):
+		return types.NumberType, nil
+	}
+
+	switch t := t.(type) {
+	case tftypes.Map:
+		elemType, err := frameworkAttrType(t.ElementType)
+		return types.MapType{ElemType: elemType}, err
+	case tftypes.List:
+		elemType, err := frameworkAttrType(t.ElementType)
+		return types.ListType{ElemType: elemType}, err
+	case tftypes.Set:
+		elemType, err := frameworkAttrType(t.ElementType)
+		return types.SetType{ElemType: elemType}, err
+	case tftypes.Object:
+		attrTypes := make(map[string]attr.Type)
+		for name, attrType := range t.AttributeTypes {
+			converted, err := frameworkAttrType(attrType)
+			if err != nil {
+				return nil, err
+			}
+			attrTypes[name] = converted
+		}
+		return types.ObjectType{AttrTypes: attrTypes}, nil
+	}
+	return nil, fmt.Errorf("unsupported type %s", t)
+}
+
+/* The protocol only carries whether something is deprecated, which
+   the framework derives from there being a message */
+func deprecationMessage(deprecated bool) string {
+	if deprecated {
+		return "Deprecated"
+	}
+	return ""
+}
@@ -0,0 +1,68 @@
+package restapi
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+/*hostOverrideDialer returns a DialContext that connects to the address
+  configured in host_overrides instead of whatever the hostname resolves
+  to. Only the connection target changes - the URL, Host header and TLS
+  ServerName all still use the real hostname, so certificates are
+  validated against it as usual */
+func hostOverrideDialer(overrides map[string]string, debug bool) func(ctx context.Context, network string, addr string) (net.Conn, error) {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+
+	return func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		target := overrideAddress(overrides, addr)
+		if target != addr && debug {
+			log.Printf("host_overrides.go: Connecting to %s for %s", target, addr)
+		}
+		return dialer.DialContext(ctx, network, target)
+	}
+}
+
+/* An override may be given for host:port or just host. If the
+   override has no port, the one being dialled is kept */
+func overrideAddress(overrides map[string]string, addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	target, ok := overrides[strings.ToLower(addr)]
+	if !ok {
+		target, ok = overrides[strings.ToLower(host)]
+	}
+	if !ok {
+		return addr
+	}
+
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		return net.JoinHostPort(target, port)
+	}
+	return target
+}
+
+/* Normalize and sanity check the host_overrides map */
+func validateHostOverrides(overrides map[string]string) (map[string]string, error) {
+	result := make(map[string]string, len(overrides))
+	for host, target := range overrides {
+		address := target
+		if h, _, err := net.SplitHostPort(target); err == nil {
+			address = h
+		}
+		if net.ParseIP(strings.Trim(address, "[]")) == nil {
+			return nil, fmt.Errorf("host_overrides.go: The override for '%s' must be an ip or ip:port, but is '%s'", host, target)
+		}
+		result[strings.ToLower(host)] = target
+	}
+	return result, nil
+}
@@ -0,0 +1,93 @@
+package restapi
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOverrideAddress(t *testing.T) {
+	overrides := map[string]string{
+		"api.example.com":      "10.0.0.1",
+		"api.example.com:8443": "10.0.0.2:443",
+		"v6.example.com":       "::1",
+	}
+
+	cases := map[string]string{
+		"api.example.com:443":  "10.0.0.1:443",
+		"API.example.com:80":   "10.0.0.1:80",
+		"api.example.com:8443": "10.0.0.2:443",
+		"v6.example.com:443":   "[::1]:443",
+		"other.example.com:80": "other.example.com:80",
+	}
+	for addr, expected := range cases {
+		if got := overrideAddress(overrides, addr); got != expected {
+			t.Errorf("host_overrides_test.go: expected '%s' to dial '%s' but got '%s'", addr, expected, got)
+		}
+	}
+
+	if _, err := validateHostOverrides(map[string]string{"api.example.com": "not-an-ip:443"}); err == nil {
+		t.Errorf("host_overrides_test.go: expected an error for an override that is not an ip")
+	}
+}
+
+func TestHostOverridesTLS(t *testing.T) {
+	var apiHost, tokenHost string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		tokenHost = r.Host
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token": "abc", "token_type": "bearer", "expires_in": 3600}`))
+	})
+	mux.HandleFunc("/api/objects/1", func(w http.ResponseWriter, r *http.Request) {
+		apiHost = r.Host
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": "1"}`))
+	})
+
+	/* The test certificate is valid for example.com, but the
+	   server only listens on 127.0.0.1 */
+	svr := httptest.NewTLSServer(mux)
+	defer svr.Close()
+	port := svr.URL[strings.LastIndex(svr.URL, ":")+1:]
+	roots := x509.NewCertPool()
+	roots.AddCert(svr.Certificate())
+
+	newClient := func(hostname string) *APIClient {
+		client, err := NewAPIClient(&apiClientOpt{
+			uri:               "https://" + hostname + ":" + port,
+			timeout:           2,
+			hostOverrides:     map[string]string{hostname: "127.0.0.1"},
+			oauthClientID:     "id",
+			oauthClientSecret: "secret",
+			oauthTokenURL:     "https://" + hostname + ":" + port + "/token",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		client.httpClient.Transport.(*http.Transport).TLSClientConfig.RootCAs = roots
+		client.oauthHTTPClient.Transport.(*http.Transport).TLSClientConfig = &tls.Config{RootCAs: roots}
+		return client
+	}
+
+	client := newClient("example.com")
+	if _, err := client.sendRequest("GET", "/api/objects/1", ""); err != nil {
+		t.Fatalf("host_overrides_test.go: %s", err)
+	}
+	if apiHost != "example.com:"+port {
+		t.Errorf("host_overrides_test.go: expected the Host header to be the real hostname but got '%s'", apiHost)
+	}
+	if tokenHost != "example.com:"+port {
+		t.Errorf("host_overrides_test.go: expected the token request to use the override but got Host '%s'", tokenHost)
+	}
+
+	/* Certificates are still checked against the hostname */
+	client = newClient("wrong.example.org")
+	_, err := client.sendRequest("GET", "/api/objects/1", "")
+	if err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Errorf("host_overrides_test.go: expected a certificate error for a hostname not on the certificate but got: %v", err)
+	}
+}
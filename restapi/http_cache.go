@@ -0,0 +1,179 @@
+package restapi
+
+import (
+	"container/list"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+/* The most responses the ETag cache will hold at once */
+const httpCacheSize = 1000
+
+/*etagCache remembers the last body and ETag seen for each URL
+  so we can ask the server whether anything changed with If-None-Match.
+  Entries are evicted least recently used first. It is safe for
+  concurrent use */
+type etagCache struct {
+	mutex    sync.Mutex
+	capacity int
+	entries  map[string]*list.Element
+	order    *list.List
+}
+
+type etagEntry struct {
+	url    string
+	etag   string
+	body   string
+	header http.Header
+}
+
+func newETagCache(capacity int) *etagCache {
+	return &etagCache{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func (c *etagCache) get(url string) (*etagEntry, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	elem, ok := c.entries[url]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*etagEntry), true
+}
+
+func (c *etagCache) put(url string, etag string, body string, header http.Header) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry := &etagEntry{url: url, etag: etag, body: body, header: header}
+	if elem, ok := c.entries[url]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[url] = c.order.PushFront(entry)
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*etagEntry).url)
+	}
+}
+
+/* Forget anything cached for this URL, regardless of query string */
+func (c *etagCache) invalidate(url string) {
+	if c == nil {
+		return
+	}
+
+	base := stripQuery(url)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for key, elem := range c.entries {
+		if stripQuery(key) == base {
+			c.order.Remove(elem)
+			delete(c.entries, key)
+		}
+	}
+}
+
+func (c *etagCache) len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.order.Len()
+}
+
+func stripQuery(url string) string {
+	if i := strings.Index(url, "?"); i != -1 {
+		return url[:i]
+	}
+	return url
+}
+
+/*readCache remembers whole responses to reads for the life of the
+  provider, so lookups that many resources share (such as data sources
+  searching the same list) only reach the API once. Entries are never
+  evicted, only invalidated by writes. It is safe for concurrent use */
+type readCache struct {
+	mutex   sync.Mutex
+	entries map[string]*apiResponse
+}
+
+func newReadCache() *readCache {
+	return &readCache{entries: make(map[string]*apiResponse)}
+}
+
+func (c *readCache) get(method string, url string) (*apiResponse, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	resp, ok := c.entries[method+" "+url]
+	if !ok {
+		return nil, false
+	}
+	copied := *resp
+	return &copied, true
+}
+
+func (c *readCache) put(resp *apiResponse) {
+	if c == nil {
+		return
+	}
+
+	copied := *resp
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries[resp.method+" "+resp.url] = &copied
+}
+
+/* Forget every response for a path at, above or below the one
+   written to. A write to /objects/1 changes both /objects/1 and
+   the /objects list, whatever their query strings */
+func (c *readCache) invalidate(url string) {
+	if c == nil {
+		return
+	}
+
+	written := stripQuery(url)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for key, resp := range c.entries {
+		cached := stripQuery(resp.url)
+		if pathWithin(cached, written) || pathWithin(written, cached) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+func (c *readCache) len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.entries)
+}
+
+/* Whether path is parent or the same as it, going by whole segments */
+func pathWithin(path string, parent string) bool {
+	parent = strings.TrimSuffix(parent, "/")
+	return path == parent || strings.HasPrefix(path, parent+"/")
+}
@@ -0,0 +1,217 @@
+package restapi
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Mastercard/terraform-provider-restapi/fakeserver"
+)
+
+func TestETagCacheEviction(t *testing.T) {
+	cache := newETagCache(2)
+
+	cache.put("/api/objects/1", `"a"`, "one", nil)
+	cache.put("/api/objects/2", `"b"`, "two", nil)
+
+	/* Touch 1 so that 2 is the least recently used */
+	if _, ok := cache.get("/api/objects/1"); !ok {
+		t.Fatalf("http_cache_test.go: expected /api/objects/1 to be cached")
+	}
+	cache.put("/api/objects/3", `"c"`, "three", nil)
+
+	if cache.len() != 2 {
+		t.Fatalf("http_cache_test.go: expected 2 entries but found %d", cache.len())
+	}
+	if _, ok := cache.get("/api/objects/2"); ok {
+		t.Errorf("http_cache_test.go: expected /api/objects/2 to be evicted")
+	}
+	for _, url := range []string{"/api/objects/1", "/api/objects/3"} {
+		if _, ok := cache.get(url); !ok {
+			t.Errorf("http_cache_test.go: expected %s to still be cached", url)
+		}
+	}
+
+	/* Replacing an entry does not grow the cache */
+	cache.put("/api/objects/3", `"d"`, "three again", nil)
+	entry, _ := cache.get("/api/objects/3")
+	if cache.len() != 2 || entry.etag != `"d"` || entry.body != "three again" {
+		t.Errorf("http_cache_test.go: unexpected cache state after replacing an entry: len=%d entry=%+v", cache.len(), entry)
+	}
+}
+
+func TestETagCacheInvalidate(t *testing.T) {
+	cache := newETagCache(10)
+	cache.put("/api/objects/1", `"a"`, "one", nil)
+	cache.put("/api/objects/1?fields=all", `"b"`, "one", nil)
+	cache.put("/api/objects/2", `"c"`, "two", nil)
+
+	cache.invalidate("/api/objects/1?force=true")
+
+	if cache.len() != 1 {
+		t.Fatalf("http_cache_test.go: expected only /api/objects/2 to remain but found %d entries", cache.len())
+	}
+	if _, ok := cache.get("/api/objects/2"); !ok {
+		t.Errorf("http_cache_test.go: invalidating one path removed another")
+	}
+
+	/* A nil cache is simply disabled */
+	var disabled *etagCache
+	disabled.put("/api/objects/1", `"a"`, "one", nil)
+	if _, ok := disabled.get("/api/objects/1"); ok {
+		t.Errorf("http_cache_test.go: a nil cache should never return entries")
+	}
+}
+
+func TestAPIClientHTTPCache(t *testing.T) {
+	debug := false
+	apiServerObjects := map[string]map[string]interface{}{
+		"1": {"id": "1", "description": fmt.Sprintf("%01000d", 0)},
+	}
+
+	svr := fakeserver.NewFakeServer(0, apiServerObjects, false, debug, "")
+
+	client, err := NewAPIClient(&apiClientOpt{
+		uri:             "http://fakeserver.invalid",
+		timeout:         2,
+		enableHTTPCache: true,
+		transport:       handlerTransport{svr.Handler()},
+		debug:           debug,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := client.sendRequest("GET", "/api/objects/1", "")
+	if err != nil {
+		t.Fatalf("http_cache_test.go: %s", err)
+	}
+	received := client.metrics.bytesReceived
+
+	second, err := client.sendRequest("GET", "/api/objects/1", "")
+	if err != nil {
+		t.Fatalf("http_cache_test.go: %s", err)
+	}
+	if second != first {
+		t.Fatalf("http_cache_test.go: cached response '%s' does not match the original '%s'", second, first)
+	}
+	if client.metrics.bytesReceived != received {
+		t.Errorf("http_cache_test.go: expected no body on a 304 but received %d more bytes", client.metrics.bytesReceived-received)
+	}
+	if client.metrics.cacheHits != 1 || client.metrics.cacheMisses != 1 {
+		t.Errorf("http_cache_test.go: expected 1 hit and 1 miss but got %d hits and %d misses", client.metrics.cacheHits, client.metrics.cacheMisses)
+	}
+
+	/* A write must not let the old copy be served again */
+	if _, err := client.sendRequest("PUT", "/api/objects/1", `{"id":"1","description":"changed"}`); err != nil {
+		t.Fatalf("http_cache_test.go: %s", err)
+	}
+	third, err := client.sendRequest("GET", "/api/objects/1", "")
+	if err != nil {
+		t.Fatalf("http_cache_test.go: %s", err)
+	}
+	if third != `{"description":"changed","id":"1"}` {
+		t.Errorf("http_cache_test.go: expected the updated object after a write but got '%s'", third)
+	}
+	if client.metrics.cacheHits != 1 || client.metrics.cacheMisses != 2 {
+		t.Errorf("http_cache_test.go: expected 1 hit and 2 misses but got %d hits and %d misses", client.metrics.cacheHits, client.metrics.cacheMisses)
+	}
+}
+
+func TestReadCacheInvalidate(t *testing.T) {
+	cache := newReadCache()
+	for _, url := range []string{"/api/objects", "/api/objects?page=2", "/api/objects/1", "/api/objects/1/children", "/api/objects/2", "/api/objects_archive"} {
+		cache.put(&apiResponse{method: "GET", url: url, body: url})
+	}
+
+	if resp, ok := cache.get("GET", "/api/objects/2"); !ok || resp.body != "/api/objects/2" {
+		t.Fatalf("http_cache_test.go: expected the cached response for /api/objects/2 but got %v", resp)
+	}
+	if _, ok := cache.get("POST", "/api/objects/2"); ok {
+		t.Errorf("http_cache_test.go: responses should be cached per method")
+	}
+
+	/* The object, what is below it and the list it is in all change */
+	cache.invalidate("/api/objects/1?force=true")
+	for _, url := range []string{"/api/objects/2", "/api/objects_archive"} {
+		if _, ok := cache.get("GET", url); !ok {
+			t.Errorf("http_cache_test.go: %s should not have been affected by a write to /api/objects/1", url)
+		}
+	}
+	if cache.len() != 2 {
+		t.Errorf("http_cache_test.go: expected 2 entries to survive the write but %d did", cache.len())
+	}
+
+	var disabled *readCache
+	disabled.put(&apiResponse{method: "GET", url: "/api/objects/1"})
+	if _, ok := disabled.get("GET", "/api/objects/1"); ok {
+		t.Errorf("http_cache_test.go: a nil cache should never return entries")
+	}
+}
+
+func TestAPIClientReadCache(t *testing.T) {
+	debug := false
+	apiServerObjects := map[string]map[string]interface{}{
+		"1": {"id": "1", "name": "one"},
+		"2": {"id": "2", "name": "two"},
+	}
+
+	svr := fakeserver.NewFakeServer(0, apiServerObjects, false, debug, "")
+	client, err := NewAPIClient(&apiClientOpt{
+		uri:             "http://fakeserver.invalid",
+		timeout:         2,
+		enableReadCache: true,
+		transport:       handlerTransport{svr.Handler()},
+		debug:           debug,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requests := 0
+	expectRequests := func(step string, expected int) {
+		t.Helper()
+		requests += expected
+		if got := len(svr.Requests()); got != requests {
+			t.Errorf("http_cache_test.go: %s: expected the server to have seen %d requests but it saw %d", step, requests, got)
+			requests = got
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		client.sendRequest("GET", "/api/objects", "", withCachedRead(true))
+		client.sendRequest("GET", "/api/objects/2", "", withCachedRead(true))
+	}
+	expectRequests("repeated cached reads", 2)
+
+	/* A caller that needs to see the API is never answered from the cache */
+	client.sendRequest("GET", "/api/objects/2", "")
+	expectRequests("uncached read", 1)
+
+	/* Writing to an object makes the list stale too */
+	if _, err := client.sendRequest("PUT", "/api/objects/1", `{"id": "1", "name": "uno"}`); err != nil {
+		t.Fatalf("http_cache_test.go: %s", err)
+	}
+	expectRequests("write", 1)
+	list, err := client.sendRequest("GET", "/api/objects", "", withCachedRead(true))
+	if err != nil {
+		t.Fatalf("http_cache_test.go: %s", err)
+	}
+	if !strings.Contains(list, "uno") {
+		t.Errorf("http_cache_test.go: expected the list read after the write to include the change but got %s", list)
+	}
+	client.sendRequest("GET", "/api/objects/2", "", withCachedRead(true))
+	expectRequests("reads after the write", 1)
+
+	/* Failures are not remembered */
+	client.sendRequest("GET", "/api/objects/3", "", withCachedRead(true))
+	client.sendRequest("GET", "/api/objects/3", "", withCachedRead(true))
+	expectRequests("missing object", 2)
+
+	if client.metrics.readHits != 5 || client.metrics.readMisses != 5 {
+		t.Errorf("http_cache_test.go: expected 5 hits and 5 misses but got %d hits and %d misses", client.metrics.readHits, client.metrics.readMisses)
+	}
+	if summary := client.metrics.summary(); !strings.Contains(summary, "read_cache: hits=5 misses=5") {
+		t.Errorf("http_cache_test.go: expected the read cache in the summary but got '%s'", summary)
+	}
+}
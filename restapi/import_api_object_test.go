@@ -1,22 +1,23 @@
 package restapi
 
 import (
-	"os"
 	"testing"
 
 	"github.com/Mastercard/terraform-provider-restapi/fakeserver"
-	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 )
 
 func TestAccRestApiObject_importBasic(t *testing.T) {
 	debug := false
 	apiServerObjects := make(map[string]map[string]interface{})
 
-	svr := fakeserver.NewFakeServer(8082, apiServerObjects, true, debug, "")
-	os.Setenv("REST_API_URI", "http://127.0.0.1:8082")
+	svr := fakeserver.NewFakeServer(0, apiServerObjects, false, debug, "")
 
+	/* The provider shares this client, which reaches the
+	   fakeserver through its handler rather than a port */
 	opt := &apiClientOpt{
-		uri:                 "http://127.0.0.1:8082/",
+		uri:                 "http://fakeserver.invalid/",
 		insecure:            false,
 		username:            "",
 		password:            "",
@@ -26,6 +27,7 @@ func TestAccRestApiObject_importBasic(t *testing.T) {
 		copyKeys:            make([]string, 0),
 		writeReturnsObject:  false,
 		createReturnsObject: false,
+		transport:           handlerTransport{svr.Handler()},
 		debug:               debug,
 	}
 	client, err := NewAPIClient(opt)
@@ -35,8 +37,11 @@ func TestAccRestApiObject_importBasic(t *testing.T) {
 	client.sendRequest("POST", "/api/objects", `{ "id": "1234", "first": "Foo", "last": "Bar" }`)
 
 	resource.UnitTest(t, resource.TestCase{
-		Providers: testAccProviders,
-		PreCheck:  func() { svr.StartInBackground() },
+		ProviderFactories: map[string]func() (*schema.Provider, error){
+			"restapi": func() (*schema.Provider, error) {
+				return NewProviderWithClient(client), nil
+			},
+		},
 		Steps: []resource.TestStep{
 			{
 				Config: generateTestResource(
@@ -56,6 +61,4 @@ func TestAccRestApiObject_importBasic(t *testing.T) {
 			},
 		},
 	})
-
-	svr.Shutdown()
 }
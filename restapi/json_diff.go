@@ -0,0 +1,233 @@
+package restapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+/* The number of lines of a verbose_errors diff shown unless set */
+const defaultVerboseErrorLines = 50
+
+/* Lines of unchanged JSON shown around each change */
+const diffContext = 3
+
+/* Beyond this many lines (on each side, once what they start and end
+   with in common is set aside) the changed part is shown as replaced
+   rather than worked out line by line */
+const maxDiffLines = 2000
+
+/* Values of keys that contain any of these (ignoring case)
+   are never shown in a diff */
+var sensitiveKeys = []string{"password", "passwd", "secret", "token", "api_key", "apikey", "private_key", "credential"}
+
+const redacted = "(redacted)"
+
+func isSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range sensitiveKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+/* A copy of v with the values of sensitive keys replaced, at any depth */
+func redactSensitive(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, val := range v {
+			if isSensitiveKey(key) {
+				result[key] = redacted
+			} else {
+				result[key] = redactSensitive(val)
+			}
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, val := range v {
+			result[i] = redactSensitive(val)
+		}
+		return result
+	}
+	return v
+}
+
+/* Render a unified diff of two JSON documents, with sensitive values
+   redacted. Keys are sorted and each value is on a line of its own
+   so the changes line up. Only the first maxLines lines are kept.
+   The result is empty if the two are the same */
+func jsonDiff(fromName string, from interface{}, toName string, to interface{}, maxLines int) string {
+	fromLines := jsonLines(redactSensitive(from))
+	toLines := jsonLines(redactSensitive(to))
+
+	ops := diffLines(fromLines, toLines)
+	changed := false
+	for _, op := range ops {
+		if op.kind != ' ' {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return ""
+	}
+
+	lines := []string{"--- " + fromName, "+++ " + toName}
+	lines = append(lines, unifiedHunks(ops)...)
+
+	if maxLines > 0 && len(lines) > maxLines {
+		more := len(lines) - maxLines
+		lines = append(lines[:maxLines], fmt.Sprintf("... (%d more lines)", more))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func jsonLines(v interface{}) []string {
+	var buffer bytes.Buffer
+	enc := json.NewEncoder(&buffer)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return []string{fmt.Sprintf("%v", v)}
+	}
+	return strings.Split(strings.TrimRight(buffer.String(), "\n"), "\n")
+}
+
+/* One line of a diff: ' ' if both sides have it, '-' if only
+   the first does and '+' if only the second does */
+type diffOp struct {
+	kind     byte
+	line     string
+	from, to int /* Line numbers (from 1) on each side */
+}
+
+/* Work out the lines to keep, remove and add to turn a into b, from
+   the longest run of lines they have in common */
+func diffLines(a []string, b []string) []diffOp {
+	var ops []diffOp
+
+	/* What they start and end with in common needs no working out */
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	for i := 0; i < prefix; i++ {
+		ops = append(ops, diffOp{kind: ' ', line: a[i], from: i + 1, to: i + 1})
+	}
+
+	midA := a[prefix : len(a)-suffix]
+	midB := b[prefix : len(b)-suffix]
+	emit := func(kind byte, line string, i int, j int) {
+		ops = append(ops, diffOp{kind: kind, line: line, from: prefix + i + 1, to: prefix + j + 1})
+	}
+
+	if len(midA) > maxDiffLines || len(midB) > maxDiffLines {
+		for i, line := range midA {
+			emit('-', line, i, 0)
+		}
+		for j, line := range midB {
+			emit('+', line, len(midA), j)
+		}
+	} else {
+		/* lcs[i][j] is the length of the longest common run of midA[i:] and midB[j:] */
+		lcs := make([][]int, len(midA)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(midB)+1)
+		}
+		for i := len(midA) - 1; i >= 0; i-- {
+			for j := len(midB) - 1; j >= 0; j-- {
+				if midA[i] == midB[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else if lcs[i+1][j] >= lcs[i][j+1] {
+					lcs[i][j] = lcs[i+1][j]
+				} else {
+					lcs[i][j] = lcs[i][j+1]
+				}
+			}
+		}
+
+		i, j := 0, 0
+		for i < len(midA) || j < len(midB) {
+			switch {
+			case i < len(midA) && j < len(midB) && midA[i] == midB[j]:
+				emit(' ', midA[i], i, j)
+				i++
+				j++
+			case j == len(midB) || (i < len(midA) && lcs[i+1][j] >= lcs[i][j+1]):
+				emit('-', midA[i], i, j)
+				i++
+			default:
+				emit('+', midB[j], i, j)
+				j++
+			}
+		}
+	}
+
+	for k := suffix; k > 0; k-- {
+		ops = append(ops, diffOp{kind: ' ', line: a[len(a)-k], from: len(a) - k + 1, to: len(b) - k + 1})
+	}
+	return ops
+}
+
+/* Group the changes into hunks with a little unchanged JSON around them */
+func unifiedHunks(ops []diffOp) []string {
+	var lines []string
+	for start := 0; start < len(ops); {
+		/* Find the next change */
+		for start < len(ops) && ops[start].kind == ' ' {
+			start++
+		}
+		if start == len(ops) {
+			break
+		}
+
+		/* A hunk runs on until there are more than twice the
+		   context lines unchanged, or the ops run out */
+		first := start - diffContext
+		if first < 0 {
+			first = 0
+		}
+		end := start
+		for unchanged := 0; end < len(ops) && unchanged <= 2*diffContext; end++ {
+			if ops[end].kind == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+		}
+		/* Back up to leave only the trailing context */
+		last := end
+		for last > start && ops[last-1].kind == ' ' {
+			last--
+		}
+		last += diffContext
+		if last > len(ops) {
+			last = len(ops)
+		}
+
+		fromCount, toCount := 0, 0
+		var body []string
+		for _, op := range ops[first:last] {
+			if op.kind != '+' {
+				fromCount++
+			}
+			if op.kind != '-' {
+				toCount++
+			}
+			body = append(body, string(op.kind)+op.line)
+		}
+		lines = append(lines, fmt.Sprintf("@@ -%d,%d +%d,%d @@", ops[first].from, fromCount, ops[first].to, toCount))
+		lines = append(lines, body...)
+		start = last
+	}
+	return lines
+}
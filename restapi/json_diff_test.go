@@ -0,0 +1,81 @@
+package restapi
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestJSONDiff(t *testing.T) {
+	server := map[string]interface{}{
+		"id":   "1",
+		"name": "web",
+		"spec": map[string]interface{}{
+			"replicas": 2,
+			"ports":    []interface{}{80, 443},
+			"labels":   map[string]interface{}{"env": "prod", "team": "a"},
+		},
+		"credentials": map[string]interface{}{"user": "admin"},
+		"password":    "hunter2",
+	}
+	sent := map[string]interface{}{
+		"id":   "1",
+		"name": "web",
+		"spec": map[string]interface{}{
+			"replicas": 3,
+			"ports":    []interface{}{80, 8443},
+			"labels":   map[string]interface{}{"env": "prod", "team": "a"},
+		},
+		"credentials": map[string]interface{}{"user": "root"},
+		"password":    "correct horse",
+	}
+
+	expected := strings.Join([]string{
+		"--- server",
+		"+++ sent",
+		"@@ -10,8 +10,8 @@",
+		`     },`,
+		`     "ports": [`,
+		`       80,`,
+		`-      443`,
+		`+      8443`,
+		`     ],`,
+		`-    "replicas": 2`,
+		`+    "replicas": 3`,
+		`   }`,
+		` }`,
+	}, "\n")
+	if diff := jsonDiff("server", server, "sent", sent, 0); diff != expected {
+		t.Errorf("json_diff_test.go: unexpected diff:\n%s\nexpected:\n%s", diff, expected)
+	}
+
+	/* The changes far from each other get hunks of their own */
+	long := func(last string) map[string]interface{} {
+		m := map[string]interface{}{"first": "a", "zlast": last}
+		for _, k := range []string{"b", "c", "d", "e", "f", "g", "h", "i", "j", "k"} {
+			m["middle_"+k] = k
+		}
+		return m
+	}
+	from, to := long("x"), long("y")
+	from["first"] = "z"
+	diff := jsonDiff("server", from, "sent", to, 0)
+	if strings.Count(diff, "@@ -") != 2 || !strings.Contains(diff, "@@ -1,5 +1,5 @@") || !strings.Contains(diff, "@@ -10,5 +10,5 @@") {
+		t.Errorf("json_diff_test.go: expected two hunks but got:\n%s", diff)
+	}
+
+	/* Truncation */
+	diff = jsonDiff("server", from, "sent", to, 4)
+	if lines := strings.Split(diff, "\n"); len(lines) != 5 || lines[4] != "... (12 more lines)" {
+		t.Errorf("json_diff_test.go: expected the diff to be cut to 4 lines but got:\n%s", diff)
+	}
+
+	/* Nothing to show */
+	if diff := jsonDiff("server", server, "sent", server, 0); diff != "" {
+		t.Errorf("json_diff_test.go: expected no diff for the same object but got:\n%s", diff)
+	}
+
+	/* Redaction leaves the original alone */
+	if server["password"] != "hunter2" {
+		t.Errorf("json_diff_test.go: redaction should not change the object it was given")
+	}
+}
@@ -0,0 +1,98 @@
+package restapi
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+/* Tokens are treated as expired this long before they say they
+   expire unless overridden, to allow for clocks that run fast */
+const defaultOAuthExpirySkew = 120
+
+/*skewTokenSource hands out the same oauth token until it is within
+  skew of expiring, then fetches a new one. The oauth2 library only
+  allows 10 seconds, which is not enough when the clock on the machine
+  running terraform is a couple of minutes off from the identity
+  provider's. It is safe for concurrent use */
+type skewTokenSource struct {
+	fetch func() (*oauth2.Token, error)
+	skew  time.Duration
+
+	mutex     sync.Mutex
+	token     *oauth2.Token
+	fetchedAt time.Time
+
+	/* Overridable for tests */
+	now func() time.Time
+}
+
+func newSkewTokenSource(fetch func() (*oauth2.Token, error), skew time.Duration) *skewTokenSource {
+	return &skewTokenSource{
+		fetch: fetch,
+		skew:  skew,
+		now:   time.Now,
+	}
+}
+
+/* Returns a token that is valid (allowing for skew), along with
+   when it was fetched from the token endpoint */
+func (ts *skewTokenSource) Token() (*oauth2.Token, time.Time, error) {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+
+	if ts.valid() {
+		return ts.token, ts.fetchedAt, nil
+	}
+
+	token, err := ts.fetch()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	ts.token = token
+	ts.fetchedAt = ts.now()
+	return ts.token, ts.fetchedAt, nil
+}
+
+/* Whether we currently hold a token we believe is valid */
+func (ts *skewTokenSource) valid() bool {
+	if ts.token == nil || ts.token.AccessToken == "" {
+		return false
+	}
+	if ts.token.Expiry.IsZero() {
+		return true
+	}
+	return ts.now().Before(ts.token.Expiry.Add(-ts.skewFor(ts.token)))
+}
+
+/* The skew to allow for token. A token that lives no longer than the
+   skew would be expired as soon as it arrived, and fetched again for
+   every request, so it is never allowed more than half its lifetime */
+func (ts *skewTokenSource) skewFor(token *oauth2.Token) time.Duration {
+	half := token.Expiry.Sub(ts.fetchedAt) / 2
+	if half < 0 {
+		return 0
+	}
+	if half < ts.skew {
+		return half
+	}
+	return ts.skew
+}
+
+/* Throw away the held token if it is still the one given. This
+   lets a request that was rejected force a refresh without
+   discarding a token another request has already refreshed */
+func (ts *skewTokenSource) invalidate(token *oauth2.Token) {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+
+	if ts.token != token {
+		return
+	}
+	if ts.valid() && !ts.token.Expiry.IsZero() {
+		log.Printf("[WARN] oauth_token.go: The API rejected an oauth token that is valid for another %s by the local clock. If this keeps happening, check that the clock on this machine is in sync (NTP) or increase oauth_expiry_skew.", ts.token.Expiry.Sub(ts.now()).Round(time.Second))
+	}
+	ts.token = nil
+}
@@ -0,0 +1,250 @@
+package restapi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func TestSkewTokenSource(t *testing.T) {
+	now := time.Now()
+	fetches := 0
+	ts := newSkewTokenSource(func() (*oauth2.Token, error) {
+		fetches++
+		return &oauth2.Token{AccessToken: fmt.Sprintf("token-%d", fetches), Expiry: now.Add(5 * time.Minute)}, nil
+	}, 2*time.Minute)
+	ts.now = func() time.Time { return now }
+
+	first, fetchedAt, err := ts.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fetchedAt.Equal(now) {
+		t.Errorf("oauth_token_test.go: expected the fetch time to come from the clock")
+	}
+
+	/* Still more than the skew away from expiring */
+	now = now.Add(2*time.Minute + 59*time.Second)
+	if token, _, _ := ts.Token(); token != first || fetches != 1 {
+		t.Fatalf("oauth_token_test.go: expected the token to be reused but it was fetched %d times", fetches)
+	}
+
+	/* Within the skew of expiring */
+	now = now.Add(2 * time.Second)
+	second, _, _ := ts.Token()
+	if second == first || fetches != 2 {
+		t.Fatalf("oauth_token_test.go: expected a new token within the skew of expiry but it was fetched %d times", fetches)
+	}
+
+	/* Invalidating a token that has already been replaced does nothing */
+	ts.invalidate(first)
+	if token, _, _ := ts.Token(); token != second || fetches != 2 {
+		t.Errorf("oauth_token_test.go: invalidating an old token should not discard the current one")
+	}
+
+	ts.invalidate(second)
+	if token, _, _ := ts.Token(); token == second || fetches != 3 {
+		t.Errorf("oauth_token_test.go: expected a new token after invalidating the current one")
+	}
+
+	failing := newSkewTokenSource(func() (*oauth2.Token, error) {
+		return nil, errors.New("token endpoint unavailable")
+	}, time.Minute)
+	if _, _, err := failing.Token(); err == nil {
+		t.Errorf("oauth_token_test.go: expected fetch errors to be returned")
+	}
+}
+
+func TestSkewTokenSourceShortLivedToken(t *testing.T) {
+	now := time.Now()
+	fetches := 0
+	ts := newSkewTokenSource(func() (*oauth2.Token, error) {
+		fetches++
+		return &oauth2.Token{AccessToken: fmt.Sprintf("token-%d", fetches), Expiry: now.Add(time.Minute)}, nil
+	}, defaultOAuthExpirySkew*time.Second)
+	ts.now = func() time.Time { return now }
+
+	/* A token that lives for less than the skew is still used
+	   for the first half of its life */
+	first, _, err := ts.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(29 * time.Second)
+	if token, _, _ := ts.Token(); token != first || fetches != 1 {
+		t.Fatalf("oauth_token_test.go: expected a 60s token to be reused for 30s but it was fetched %d times", fetches)
+	}
+
+	now = now.Add(2 * time.Second)
+	if token, _, _ := ts.Token(); token == first || fetches != 2 {
+		t.Errorf("oauth_token_test.go: expected a 60s token to be replaced after 30s but it was fetched %d times", fetches)
+	}
+}
+
+func TestOAuthTokenFetchTimeout(t *testing.T) {
+	release := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		/* Never answers until the test is over */
+		<-release
+	})
+	svr := httptest.NewServer(mux)
+	defer svr.Close()
+	defer close(release)
+
+	client, err := NewAPIClient(&apiClientOpt{
+		uri:               svr.URL,
+		timeout:           1,
+		oauthClientID:     "id",
+		oauthClientSecret: "secret",
+		oauthTokenURL:     svr.URL + "/token",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	_, err = client.sendRequest("GET", "/api/objects/1", "")
+	if err == nil || !strings.Contains(err.Error(), "deadline exceeded") {
+		t.Fatalf("oauth_token_test.go: expected the token fetch to time out but got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("oauth_token_test.go: expected the token fetch to give up after the 1s timeout but it took %s", elapsed)
+	}
+}
+
+func TestOAuthInvalidTokenRetry(t *testing.T) {
+	tokenRequests := 0
+	rejectAll := false
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		tokenRequests++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(fmt.Sprintf(`{"access_token": "token-%d", "token_type": "bearer", "expires_in": 3600}`, tokenRequests)))
+	})
+	mux.HandleFunc("/api/objects/1", func(w http.ResponseWriter, r *http.Request) {
+		/* Only the first token handed out is considered expired */
+		if rejectAll || r.Header.Get("Authorization") == "Bearer token-1" {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token", error_description="The access token expired"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": "1"}`))
+	})
+	svr := httptest.NewServer(mux)
+	defer svr.Close()
+
+	client, err := NewAPIClient(&apiClientOpt{
+		uri:               svr.URL,
+		timeout:           2,
+		oauthClientID:     "id",
+		oauthClientSecret: "secret",
+		oauthTokenURL:     svr.URL + "/token",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.sendRequest("GET", "/api/objects/1", ""); err != nil {
+		t.Fatalf("oauth_token_test.go: expected the request to succeed after refreshing the token: %s", err)
+	}
+	if tokenRequests != 2 || client.metrics.total() != 2 {
+		t.Errorf("oauth_token_test.go: expected 2 token requests and 2 API requests but got %d and %d", tokenRequests, client.metrics.total())
+	}
+
+	/* The refreshed token is reused */
+	if _, err := client.sendRequest("GET", "/api/objects/1", ""); err != nil {
+		t.Fatalf("oauth_token_test.go: %s", err)
+	}
+	if tokenRequests != 2 {
+		t.Errorf("oauth_token_test.go: expected the token to be reused but %d were requested", tokenRequests)
+	}
+
+	/* Only one retry is made */
+	rejectAll = true
+	_, err = client.sendRequest("GET", "/api/objects/1", "")
+	var authErr *UnauthorizedError
+	if !errors.As(err, &authErr) {
+		t.Fatalf("oauth_token_test.go: expected an UnauthorizedError but got: %v", err)
+	}
+	if tokenRequests != 3 || client.metrics.total() != 5 {
+		t.Errorf("oauth_token_test.go: expected a single retry but got %d token requests and %d API requests", tokenRequests, client.metrics.total())
+	}
+}
+
+func TestOAuthConcurrentTokenFetch(t *testing.T) {
+	var tokenRequests int64
+	var expired atomic.Value
+	expired.Store("")
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&tokenRequests, 1)
+		/* Slow enough that every caller arrives while it is in flight */
+		time.Sleep(200 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(fmt.Sprintf(`{"access_token": "token-%d", "token_type": "bearer", "expires_in": 3600}`, n)))
+	})
+	mux.HandleFunc("/api/objects/1", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "Bearer "+expired.Load().(string) {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": "1"}`))
+	})
+	svr := httptest.NewServer(mux)
+	defer svr.Close()
+
+	client, err := NewAPIClient(&apiClientOpt{
+		uri:               svr.URL,
+		timeout:           5,
+		oauthClientID:     "id",
+		oauthClientSecret: "secret",
+		oauthTokenURL:     svr.URL + "/token",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	/* Send 20 requests at once, as terraform's parallelism would */
+	wave := func() {
+		var wg sync.WaitGroup
+		errs := make(chan error, 20)
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := client.sendRequest("GET", "/api/objects/1", ""); err != nil {
+					errs <- err
+				}
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Errorf("oauth_token_test.go: %s", err)
+		}
+	}
+
+	wave()
+	if n := atomic.LoadInt64(&tokenRequests); n != 1 {
+		t.Errorf("oauth_token_test.go: expected the parallel requests to share one token request but %d were made", n)
+	}
+
+	/* Every request is rejected with the same token, and they share one refresh */
+	expired.Store("token-1")
+	wave()
+	if n := atomic.LoadInt64(&tokenRequests); n != 2 {
+		t.Errorf("oauth_token_test.go: expected the parallel requests to share one token refresh but %d token requests were made in all", n)
+	}
+}
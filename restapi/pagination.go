@@ -0,0 +1,377 @@
+package restapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/* The most pages a list will be followed through unless overridden */
+const defaultMaxPages = 100
+
+/* Matches one entry of an RFC 5988 Link header, such as
+   <https://api.example.com/objects?page=2>; rel="next" */
+var linkEntry = regexp.MustCompile(`<([^>]*)>((?:\s*;\s*[^;,]+)*)`)
+
+/*getPaginated issues a GET to path and follows the server's pagination
+  until there are no more pages (or max_pages is reached), returning all
+  of the results concatenated. If resultsKey is set, each page is expected
+  to be a hash with the results array at that key; otherwise each page
+  must itself be an array. The results may not add up to more than
+  max_response_bytes, however many pages they came from. Hitting
+  max_pages is only a warning, and the results so far are returned */
+func (client *APIClient) getPaginated(path string, resultsKey string, options ...requestOption) ([]interface{}, error) {
+	results := make([]interface{}, 0)
+	retained := client.newRetainedBytes(0, path)
+	err := client.eachItem(path, resultsKey, func(item interface{}, size int64) (bool, error) {
+		if err := retained.add(size); err != nil {
+			return false, err
+		}
+		results = append(results, item)
+		return false, nil
+	}, options...)
+	if _, ok := err.(*maxPagesError); ok {
+		log.Printf("pagination.go: WARNING: %s", err)
+		return results, nil
+	}
+	return results, err
+}
+
+/*maxPagesError means there were more pages than max_pages allows, so
+  not every result was seen. A search must not take that to mean the
+  object it is looking for is gone */
+type maxPagesError struct {
+	path     string
+	maxPages int
+}
+
+func (e *maxPagesError) Error() string {
+	return fmt.Sprintf("stopped following pages at '%s' after max_pages (%d) was reached, so the results are incomplete", e.path, e.maxPages)
+}
+
+/*eachItem fetches path and each following page, passing every result
+  to fn in turn as it is decoded, along with its size in bytes. Results
+  are decoded straight from the response, so a large list is never held
+  in memory all at once - fn decides what is kept. fn returns true to
+  stop early. The next page is found from a Link header with rel="next",
+  or failing that, an X-Next-Page header giving the value of the page
+  query parameter. Each page is a separate request, so the rate limiter
+  applies between them. If there are more than max_pages pages, a
+  *maxPagesError is returned once those have been passed to fn */
+func (client *APIClient) eachItem(path string, resultsKey string, fn func(interface{}, int64) (bool, error), options ...requestOption) error {
+	seen := make(map[string]bool)
+	for page := 1; path != ""; page++ {
+		if page > client.maxPages {
+			return &maxPagesError{path: path, maxPages: client.maxPages}
+		}
+		if seen[path] {
+			return fmt.Errorf("pagination.go: Pagination loop detected - page %d points back to '%s'", page, path)
+		}
+		seen[path] = true
+
+		if client.debug {
+			log.Printf("pagination.go: Fetching page %d from '%s'", page, path)
+		}
+		stopped := false
+		stream := withStream(func(body io.Reader) error {
+			return streamResults(body, resultsKey, path, client.debug, func(item interface{}, size int64) (bool, error) {
+				stop, err := fn(item, size)
+				stopped = stop
+				return stop, err
+			})
+		})
+		resp, err := client.doRequest(client.readMethod, path, "", append(options, stream)...)
+		if err != nil || stopped {
+			return err
+		}
+
+		path, err = client.nextPage(resp, path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/* Decode the results array of one page from body, passing each result
+   and roughly the size of its JSON to fn until fn asks to stop. Only one result
+   is held at a time. A results_key given with dots rather than slashes
+   can only be told apart from a key containing dots once the whole page
+   has been seen, so that page is decoded in full */
+func streamResults(body io.Reader, resultsKey string, path string, debug bool, fn func(interface{}, int64) (bool, error)) error {
+	decoder := json.NewDecoder(body)
+
+	if resultsKey != "" && !strings.Contains(resultsKey, "/") && strings.Contains(resultsKey, ".") {
+		var result interface{}
+		decoder.UseNumber()
+		if err := decoder.Decode(&result); err != nil {
+			return err
+		}
+		items, err := resultsArray(result, resultsKey, path, debug)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			b, _ := json.Marshal(item)
+			if stop, err := fn(item, int64(len(b))); err != nil || stop {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := seekResults(decoder, resultsKey, path); err != nil {
+		return err
+	}
+
+	decoder.UseNumber()
+	for decoder.More() {
+		start := decoder.InputOffset()
+		var item interface{}
+		if err := decoder.Decode(&item); err != nil {
+			return err
+		}
+		/* Near enough the size of the result - it includes the comma before it */
+		if stop, err := fn(item, decoder.InputOffset()-start); err != nil || stop {
+			return err
+		}
+	}
+	_, err := decoder.Token()
+	return err
+}
+
+/* Move the decoder to just inside the results array, following
+   resultsKey through the hashes (and arrays, by index) above it */
+func seekResults(decoder *json.Decoder, resultsKey string, path string) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+
+	seen := ""
+	for _, part := range strings.Split(resultsKey, "/") {
+		/* Protect against double slashes by mistake */
+		if part == "" {
+			continue
+		}
+
+		if seen == "" && token != json.Delim('{') {
+			return fmt.Errorf("pagination.go: The results of a GET to '%s' did not return a hash. Cannot search within for results_key '%s'", path, resultsKey)
+		}
+
+		found := false
+		switch token {
+		case json.Delim('{'):
+			for decoder.More() {
+				key, err := decoder.Token()
+				if err != nil {
+					return err
+				}
+				if key == part {
+					found = true
+					break
+				}
+				if err := skipValue(decoder); err != nil {
+					return err
+				}
+			}
+		case json.Delim('['):
+			index, err := strconv.Atoi(part)
+			if err != nil {
+				return fmt.Errorf("pagination.go: Error finding results_key: Object at '%s' is a list, so '%s' must be an index", seen, part)
+			}
+			for i := 0; decoder.More(); i++ {
+				if i == index {
+					found = true
+					break
+				}
+				if err := skipValue(decoder); err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("pagination.go: Error finding results_key: Object at '%s' is not a map. Is this the right path?", seen)
+		}
+		if !found {
+			return fmt.Errorf("pagination.go: Error finding results_key: Failed to find '%s' in returned data structure after finding '%s'", part, seen)
+		}
+		seen += "/" + part
+
+		if token, err = decoder.Token(); err != nil {
+			return err
+		}
+	}
+
+	if token != json.Delim('[') {
+		if resultsKey == "" {
+			return fmt.Errorf("pagination.go: The results of a GET to '%s' did not return an array. It is a '%s'. Perhaps you meant to add a results_key?", path, jsonKind(token))
+		}
+		return fmt.Errorf("pagination.go: The data at results_key location '%s' is not an array. It is a '%s'", resultsKey, jsonKind(token))
+	}
+	return nil
+}
+
+/* Skip over the next value, however deeply nested */
+func skipValue(decoder *json.Decoder) error {
+	depth := 0
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+/* Describe the value a token starts, the way reflect would name it once decoded */
+func jsonKind(token json.Token) string {
+	switch token.(type) {
+	case json.Delim:
+		if token == json.Delim('{') {
+			return "map[string]interface {}"
+		}
+		return "[]interface {}"
+	case nil:
+		return "<nil>"
+	}
+	return reflect.TypeOf(token).String()
+}
+
+/*retainedBytes counts the size of the list results a caller keeps, so
+  max_response_bytes limits what is held in memory rather than how much
+  of a list was streamed past */
+type retainedBytes struct {
+	limit int64
+	total int64
+	path  string
+}
+
+/* Start counting against limit, or the client's max_response_bytes if it is 0 */
+func (client *APIClient) newRetainedBytes(limit int64, path string) *retainedBytes {
+	if limit <= 0 {
+		limit = client.maxResponseBytes
+	}
+	return &retainedBytes{limit: limit, path: path}
+}
+
+func (r *retainedBytes) add(n int64) error {
+	r.total += n
+	if r.total > r.limit {
+		return fmt.Errorf("pagination.go: The results kept from '%s' exceeded the max_response_bytes limit of %d bytes", r.path, r.limit)
+	}
+	return nil
+}
+
+/* Work out the path of the page after this one, or "" if this was the last */
+func (client *APIClient) nextPage(resp *apiResponse, path string) (string, error) {
+	current, err := url.Parse(resp.url)
+	if err != nil {
+		return "", err
+	}
+
+	var next *url.URL
+	if link := nextLink(resp.header.Values("Link")); link != "" {
+		ref, err := url.Parse(link)
+		if err != nil {
+			return "", fmt.Errorf("pagination.go: Invalid next link '%s' from '%s': %s", link, path, err)
+		}
+		next = current.ResolveReference(ref)
+	} else if page := resp.header.Get("X-Next-Page"); page != "" {
+		next = &url.URL{}
+		*next = *current
+		query := next.Query()
+		query.Set("page", page)
+		next.RawQuery = query.Encode()
+	} else {
+		return "", nil
+	}
+
+	/* Pages are requested through the client, so with its credentials.
+	   Only follow links to the same scheme and host as the configured
+	   API, beneath its path - comparing the strings would let through
+	   https://api.example.com.evil.net or https://api.example.com@evil.net */
+	base, err := url.Parse(client.uri)
+	if err != nil {
+		return "", err
+	}
+	basePath := base.EscapedPath()
+	nextPath := next.EscapedPath()
+	if next.Scheme != base.Scheme || !strings.EqualFold(next.Host, base.Host) || next.User != nil ||
+		(nextPath != basePath && !strings.HasPrefix(nextPath, basePath+"/")) {
+		return "", fmt.Errorf("pagination.go: Refusing to follow the next page link '%s' as it is not under the API uri '%s'", next.Redacted(), client.uri)
+	}
+
+	relative := strings.TrimPrefix(nextPath, basePath)
+	if next.RawQuery != "" {
+		relative += "?" + next.RawQuery
+	}
+	return relative, nil
+}
+
+/* Find the rel="next" target in a set of Link headers */
+func nextLink(headers []string) string {
+	for _, header := range headers {
+		for _, match := range linkEntry.FindAllStringSubmatch(header, -1) {
+			for _, param := range strings.Split(match[2], ";") {
+				param = strings.TrimSpace(param)
+				if !strings.HasPrefix(strings.ToLower(param), "rel=") {
+					continue
+				}
+				for _, rel := range strings.Fields(strings.Trim(param[4:], `"`)) {
+					if strings.EqualFold(rel, "next") {
+						return match[1]
+					}
+				}
+			}
+		}
+	}
+	return ""
+}
+
+/* Pull the array of results out of a page of search results */
+func resultsArray(result interface{}, resultsKey string, path string, debug bool) ([]interface{}, error) {
+	if resultsKey == "" {
+		if debug {
+			log.Printf("pagination.go: results_key is not set - coaxing data to array of interfaces")
+		}
+		dataArray, ok := result.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("pagination.go: The results of a GET to '%s' did not return an array. It is a '%s'. Perhaps you meant to add a results_key?", path, reflect.TypeOf(result))
+		}
+		return dataArray, nil
+	}
+
+	if debug {
+		log.Printf("pagination.go: Locating '%s' in the results", resultsKey)
+	}
+
+	/* First verify the data we got back is a hash */
+	hash, ok := result.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("pagination.go: The results of a GET to '%s' did not return a hash. Cannot search within for results_key '%s'", path, resultsKey)
+	}
+
+	tmp, err := GetObjectAtKey(hash, resultsKey, debug)
+	if err != nil {
+		return nil, fmt.Errorf("pagination.go: Error finding results_key: %s", err)
+	}
+	dataArray, ok := tmp.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("pagination.go: The data at results_key location '%s' is not an array. It is a '%s'", resultsKey, reflect.TypeOf(tmp))
+	}
+	return dataArray, nil
+}
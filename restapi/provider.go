@@ -1,17 +1,71 @@
 package restapi
 
 import (
+	"context"
 	"fmt"
 	"math"
+	"net/http"
 	"net/url"
+	"runtime"
 
-	"github.com/hashicorp/terraform/helper/schema"
-	"github.com/hashicorp/terraform/terraform"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 )
 
-/*Provider implements the REST API provider*/
-func Provider() terraform.ResourceProvider {
-	return &schema.Provider{
+/*Provider implements the REST API provider, reporting itself as
+  the development version */
+func Provider() *schema.Provider {
+	return New("dev", "")()
+}
+
+/*New returns a function that builds the REST API provider for the
+  given release version and the commit it was built from (which may
+  be empty). This is the SDK half of the muxed server */
+func New(version string, commit string) func() *schema.Provider {
+	return func() *schema.Provider {
+		return newProvider(version, commit, nil)
+	}
+}
+
+/*NewProviderWithTransport is like New, but every request the provider
+  makes (including for oauth tokens) goes through transport. This lets
+  programs embedding the provider instrument or redirect its traffic.
+  insecure, cert_file, key_file and host_overrides are then up to transport */
+func NewProviderWithTransport(version string, commit string, transport http.RoundTripper) func() *schema.Provider {
+	return func() *schema.Provider {
+		return newProvider(version, commit, transport)
+	}
+}
+
+/*NewProviderWithClient returns a provider that uses client, which is
+  already set up, instead of building one from its configuration. uri
+  is then optional. It is meant for tests and embedding, and the provider
+  served to terraform never uses it */
+func NewProviderWithClient(client *APIClient) *schema.Provider {
+	provider := newProvider("dev", "", nil)
+	provider.Schema["uri"].Required = false
+	provider.Schema["uri"].Optional = true
+	provider.ConfigureContextFunc = func(ctx context.Context, d *schema.ResourceData) (interface{}, diag.Diagnostics) {
+		return client, nil
+	}
+	return provider
+}
+
+/* Both halves of the provider describe themselves to the API the same way */
+func userAgent(terraformVersion string, version string, commit string) string {
+	product := fmt.Sprintf("terraform-provider-restapi/%s", version)
+	if commit != "" {
+		product = fmt.Sprintf("%s (%s)", product, commit)
+	}
+	if terraformVersion == "" {
+		return product
+	}
+	return fmt.Sprintf("Terraform/%s (+https://www.terraform.io) %s", terraformVersion, product)
+}
+
+func newProvider(version string, commit string, transport http.RoundTripper) *schema.Provider {
+	provider := &schema.Provider{
 		Schema: map[string]*schema.Schema{
 			"uri": {
 				Type:        schema.TypeString,
@@ -41,7 +95,25 @@ func Provider() terraform.ResourceProvider {
 				Type:        schema.TypeMap,
 				Elem:        schema.TypeString,
 				Optional:    true,
-				Description: "A map of header names and values to set on all outbound requests. This is useful if you want to use a script via the 'external' provider or provide a pre-approved token or change Content-Type from `application/json`. If `username` and `password` are set and Authorization is one of the headers defined here, the BASIC auth credentials take precedence.",
+				Description: "A map of header names and values to set on all outbound requests. This is useful if you want to use a script via the 'external' provider or provide a pre-approved token or change Content-Type from `application/json`. If `username` and `password` are set and Authorization is one of the headers defined here, the BASIC auth credentials take precedence. A User-Agent set here replaces the one the provider sends, which names the terraform and provider versions and the commit the provider was built from.",
+			},
+			"content_type": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_CONTENT_TYPE", defaultContentType),
+				Description: "Defaults to `application/json`. The Content-Type header sent on requests that have a body. Some APIs are picky about the exact value (such as `application/json; charset=utf-8` or `application/vnd.api+json`). A Content-Type in `headers` takes precedence.",
+			},
+			"accept": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_ACCEPT", nil),
+				Description: "When set, the Accept header sent on all requests to the API. An Accept in `headers` takes precedence. This does not apply to requests for oauth tokens.",
+			},
+			"host_overrides": {
+				Type:        schema.TypeMap,
+				Elem:        schema.TypeString,
+				Optional:    true,
+				Description: "A map of hostnames to the `ip` or `ip:port` to connect to instead of what the hostname resolves to, similar to an entry in /etc/hosts. The hostname is still used for the Host header and TLS certificate validation. This also applies to the oauth token endpoint.",
 			},
 			"use_cookies": {
 				Type:        schema.TypeBool,
@@ -59,31 +131,35 @@ func Provider() terraform.ResourceProvider {
 				Type:        schema.TypeString,
 				Optional:    true,
 				DefaultFunc: schema.EnvDefaultFunc("REST_API_ID_ATTRIBUTE", nil),
-				Description: "When set, this key will be used to operate on REST objects. For example, if the ID is set to 'name', changes to the API object will be to http://foo.com/bar/VALUE_OF_NAME. This value may also be a '/'-delimeted path to the id attribute if it is multple levels deep in the data (such as `attributes/id` in the case of an object `{ \"attributes\": { \"id\": 1234 }, \"config\": { \"name\": \"foo\", \"something\": \"bar\"}}`",
+				Description: "When set, this key will be used to operate on REST objects. For example, if the ID is set to 'name', changes to the API object will be to http://foo.com/bar/VALUE_OF_NAME. This value may also be a '/'-delimeted (or '.'-delimited) path to the id attribute if it is multple levels deep in the data (such as `attributes/id` or `attributes.id` in the case of an object `{ \"attributes\": { \"id\": 1234 }, \"config\": { \"name\": \"foo\", \"something\": \"bar\"}}`). Numeric ids are used as the number is written, except that exponents are expanded (`1e3` is `1000`).",
 			},
 			"create_method": {
-				Type:        schema.TypeString,
-				DefaultFunc: schema.EnvDefaultFunc("REST_API_CREATE_METHOD", nil),
-				Description: "Defaults to `POST`. The HTTP method used to CREATE objects of this type on the API server.",
-				Optional:    true,
+				Type:         schema.TypeString,
+				DefaultFunc:  schema.EnvDefaultFunc("REST_API_CREATE_METHOD", nil),
+				Description:  "Defaults to `POST`. The HTTP method used to CREATE objects of this type on the API server.",
+				Optional:     true,
+				ValidateFunc: validateHTTPMethod,
 			},
 			"read_method": {
-				Type:        schema.TypeString,
-				DefaultFunc: schema.EnvDefaultFunc("REST_API_READ_METHOD", nil),
-				Description: "Defaults to `GET`. The HTTP method used to READ objects of this type on the API server.",
-				Optional:    true,
+				Type:         schema.TypeString,
+				DefaultFunc:  schema.EnvDefaultFunc("REST_API_READ_METHOD", nil),
+				Description:  "Defaults to `GET`. The HTTP method used to READ objects of this type on the API server.",
+				Optional:     true,
+				ValidateFunc: validateHTTPMethod,
 			},
 			"update_method": {
-				Type:        schema.TypeString,
-				DefaultFunc: schema.EnvDefaultFunc("REST_API_UPDATE_METHOD", nil),
-				Description: "Defaults to `PUT`. The HTTP method used to UPDATE objects of this type on the API server.",
-				Optional:    true,
+				Type:         schema.TypeString,
+				DefaultFunc:  schema.EnvDefaultFunc("REST_API_UPDATE_METHOD", nil),
+				Description:  "Defaults to `PUT`. The HTTP method used to UPDATE objects of this type on the API server.",
+				Optional:     true,
+				ValidateFunc: validateHTTPMethod,
 			},
 			"destroy_method": {
-				Type:        schema.TypeString,
-				DefaultFunc: schema.EnvDefaultFunc("REST_API_DESTROY_METHOD", nil),
-				Description: "Defaults to `DELETE`. The HTTP method used to DELETE objects of this type on the API server.",
-				Optional:    true,
+				Type:         schema.TypeString,
+				DefaultFunc:  schema.EnvDefaultFunc("REST_API_DESTROY_METHOD", nil),
+				Description:  "Defaults to `DELETE`. The HTTP method used to DELETE objects of this type on the API server.",
+				Optional:     true,
+				ValidateFunc: validateHTTPMethod,
 			},
 			"copy_keys": {
 				Type: schema.TypeList,
@@ -91,7 +167,7 @@ func Provider() terraform.ResourceProvider {
 					Type: schema.TypeString,
 				},
 				Optional:    true,
-				Description: "When set, any PUT to the API for an object will copy these keys from the data the provider has gathered about the object. This is useful if internal API information must also be provided with updates, such as the revision of the object.",
+				Description: "When set, any PUT to the API for an object will copy these keys from the data the provider has gathered about the object. This is useful if internal API information must also be provided with updates, such as the revision of the object. Keys may be a path into nested objects, such as `meta/revision` (or `meta.revision`).",
 			},
 			"write_returns_object": {
 				Type:        schema.TypeBool,
@@ -103,13 +179,13 @@ func Provider() terraform.ResourceProvider {
 				Type:        schema.TypeBool,
 				Optional:    true,
 				DefaultFunc: schema.EnvDefaultFunc("REST_API_CRO", nil),
-				Description: "Set this when the API returns the object created only on creation operations (POST). This is used by the provider to refresh internal data structures.",
+				Description: "Set this when the API returns the object created only on creation operations (POST). This is used by the provider to refresh internal data structures. If the response is empty or does not include the `id_attribute`, the id is taken from the last path segment of the `Location` header, and the object is read back from the API.",
 			},
 			"xssi_prefix": {
 				Type:        schema.TypeString,
 				Optional:    true,
 				DefaultFunc: schema.EnvDefaultFunc("REST_API_XSSI_PREFIX", nil),
-				Description: "Trim the xssi prefix from response string, if present, before parsing.",
+				Description: "Trim the xssi prefix (such as `)]}'`) from response string, if present, before parsing. A newline directly after the prefix is also removed.",
 			},
 			"rate_limit": {
 				Type:        schema.TypeFloat,
@@ -123,6 +199,85 @@ func Provider() terraform.ResourceProvider {
 				DefaultFunc: schema.EnvDefaultFunc("REST_API_TEST_PATH", nil),
 				Description: "If set, the provider will issue a read_method request to this path after instantiation requiring a 200 OK response before proceeding. This is useful if your API provides a no-op endpoint that can signal if this provider is configured correctly. Response data will be ignored.",
 			},
+			"skip_connectivity_checks": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_SKIP_CONNECTIVITY_CHECKS", nil),
+				Description: "When set, the `test_path` request is not made when the provider is configured, so commands such as `terraform validate` work on a machine that cannot reach the API (or its oauth token endpoint). The provider makes no other requests until a resource or data source needs one - oauth tokens are only fetched then, too.",
+			},
+			"error_body_length": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_ERROR_BODY_LENGTH", defaultErrorBodyLength),
+				Description: "Defaults to `512`. The maximum number of characters of a response body to include in error messages when a request to the API fails.",
+			},
+			"circuit_breaker_threshold": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_CIRCUIT_BREAKER_THRESHOLD", 5),
+				Description: "Defaults to `5`. After this many consecutive connection failures (not HTTP error responses) within `circuit_breaker_window`, requests to the API fail immediately instead of waiting for a timeout. A single probe request is allowed through every `circuit_breaker_cooldown` to detect when the API is back. Set to `0` to disable.",
+			},
+			"circuit_breaker_window": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_CIRCUIT_BREAKER_WINDOW", 60),
+				Description: "Defaults to `60`. The time (in seconds) within which `circuit_breaker_threshold` consecutive connection failures open the circuit.",
+			},
+			"circuit_breaker_cooldown": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_CIRCUIT_BREAKER_COOLDOWN", 30),
+				Description: "Defaults to `30`. The time (in seconds) to wait between probe requests while the circuit is open.",
+			},
+			"max_response_bytes": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_MAX_RESPONSE_BYTES", defaultMaxResponseBytes),
+				Description: "Defaults to `33554432` (32 MiB). Responses from the API larger than this are refused. This protects against a misconfigured `uri` causing the provider to read something huge (like a web UI bundle) into memory. Lists that are searched or paged through are decoded as they arrive, so for those the limit applies to the results kept rather than to the size of each page.",
+			},
+			"slow_request_threshold": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_SLOW_REQUEST_THRESHOLD", 0),
+				Description: "Defaults to `0` (disabled). Requests that take longer than this many seconds are logged at WARN along with a breakdown of where the time went (dns, connect, tls, time to first byte and transfer). The same breakdown is logged for every request at TRACE.",
+			},
+			"max_pages": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_MAX_PAGES", defaultMaxPages),
+				Description: "Defaults to `100`. When searching the results of a list endpoint that paginates (using a `Link` header with `rel=\"next\"` or an `X-Next-Page` header), at most this many pages are followed. A search that has not found its object by then is an error, rather than the object being treated as deleted.",
+			},
+			"enable_http_cache": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_ENABLE_HTTP_CACHE", nil),
+				Description: "When set, responses to GET requests that carry an `ETag` header are remembered for the duration of the terraform run. Subsequent reads send `If-None-Match` and reuse the remembered body if the server responds with `304 Not Modified`. Any write to a path forgets what was remembered for it.",
+			},
+			"enable_read_cache": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_ENABLE_READ_CACHE", nil),
+				Description: "When set, the searches and reads done by `restapi_object` data sources are remembered for the duration of the terraform run, so data sources looking up the same objects only reach the API once. Any write to a path forgets what was remembered for it and the paths above it. Resources always read from the API.",
+			},
+			"read_failure_mode": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				DefaultFunc:  schema.EnvDefaultFunc("REST_API_READ_FAILURE_MODE", readFailureError),
+				ValidateFunc: validation.StringInSlice([]string{readFailureError, readFailureWarnKeepState}, false),
+				Description:  "Defaults to `error`. What to do when refreshing a `restapi_object` or `restapi_object_collection` fails with a 5xx response or without a response at all (such as a timeout). `error` fails the plan. `warn_keep_state` keeps the values from the last refresh and adds a warning for each resource saying it was not refreshed, so the rest of the plan can go ahead - changes made outside terraform to those objects will not show up in it. A 404 always removes the object from state and other 4xx responses are always errors. So is a failure that stopped the request being sent, such as an oauth token that could not be obtained or an open circuit breaker.",
+			},
+			"verbose_errors": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_VERBOSE_ERRORS", nil),
+				Description: "When set, an update the API rejects with a 4xx response (other than 401 and 403) is explained with a diff of the object on the server against the data that was sent. This costs a read of the object unless it was just read. Values of keys that look like credentials (such as `password` or `token`) are redacted, and no diff is shown when `API_DATA_IS_SENSITIVE` is set.",
+			},
+			"verbose_errors_max_lines": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_VERBOSE_ERRORS_MAX_LINES", defaultVerboseErrorLines),
+				Description: "Defaults to `50`. The most lines of the diff `verbose_errors` adds to an error.",
+			},
 			"debug": {
 				Type:        schema.TypeBool,
 				Optional:    true,
@@ -157,6 +312,13 @@ func Provider() terraform.ResourceProvider {
 							Optional:    true,
 							Description: "scopes",
 						},
+						"oauth_expiry_skew": {
+							Type:         schema.TypeInt,
+							Optional:     true,
+							Default:      defaultOAuthExpirySkew,
+							ValidateFunc: validation.IntAtLeast(0),
+							Description:  "Defaults to `120`. Tokens are treated as expired this many seconds before their expiry time to allow for the clock on this machine being ahead of the token endpoint's. A token is never treated as expired for more than half of its lifetime, so short-lived tokens are still reused. If the API rejects a token as `invalid_token` while it is still valid by the local clock, the token is refreshed once and the request retried. Set to `0` to use tokens until the moment they expire.",
+						},
 						"endpoint_params": {
 							Type:        schema.TypeMap,
 							Optional:    true,
@@ -186,16 +348,42 @@ func Provider() terraform.ResourceProvider {
 			/* Could only get terraform to recognize this resource if
 			         the name began with the provider's name and had at least
 				 one underscore. This is not documented anywhere I could find */
-			"restapi_object": resourceRestAPI(),
+			"restapi_object":            resourceRestAPI(),
+			"restapi_object_collection": resourceRestAPICollection(),
 		},
 		DataSourcesMap: map[string]*schema.Resource{
 			"restapi_object": dataSourceRestAPI(),
 		},
-		ConfigureFunc: configureProvider,
 	}
+
+	provider.ConfigureContextFunc = func(ctx context.Context, d *schema.ResourceData) (interface{}, diag.Diagnostics) {
+		client, err := configureProvider(ctx, d, userAgent(provider.TerraformVersion, version, commit), transport)
+		if client != nil {
+			watchForStop(ctx, client)
+		}
+		return client, configureDiagnostics(err)
+	}
+
+	return provider
 }
 
-func configureProvider(d *schema.ResourceData) (interface{}, error) {
+/* Log the API call summary once terraform is finished with the provider -
+   either when it is asked to stop, or when the client is garbage collected
+   at the end of the run */
+func watchForStop(ctx context.Context, client *APIClient) {
+	metrics := client.metrics
+	if stopCtx, ok := schema.StopContext(ctx); ok {
+		go func() {
+			<-stopCtx.Done()
+			metrics.logSummary()
+		}()
+	}
+	runtime.SetFinalizer(client, func(c *APIClient) {
+		c.metrics.logSummary()
+	})
+}
+
+func configureProvider(ctx context.Context, d *schema.ResourceData, userAgent string, transport http.RoundTripper) (*APIClient, error) {
 
 	/* As "data-safe" as terraform says it is, you'd think
 	   it would have already coaxed this to a slice FOR me */
@@ -213,12 +401,22 @@ func configureProvider(d *schema.ResourceData) (interface{}, error) {
 		}
 	}
 
+	hostOverrides := make(map[string]string)
+	if iHostOverrides := d.Get("host_overrides"); iHostOverrides != nil {
+		for k, v := range iHostOverrides.(map[string]interface{}) {
+			hostOverrides[k] = v.(string)
+		}
+	}
+
 	opt := &apiClientOpt{
 		uri:                 d.Get("uri").(string),
 		insecure:            d.Get("insecure").(bool),
 		username:            d.Get("username").(string),
 		password:            d.Get("password").(string),
 		headers:             headers,
+		hostOverrides:       hostOverrides,
+		contentType:         d.Get("content_type").(string),
+		accept:              d.Get("accept").(string),
 		useCookies:          d.Get("use_cookies").(bool),
 		timeout:             d.Get("timeout").(int),
 		idAttribute:         d.Get("id_attribute").(string),
@@ -227,6 +425,20 @@ func configureProvider(d *schema.ResourceData) (interface{}, error) {
 		createReturnsObject: d.Get("create_returns_object").(bool),
 		xssiPrefix:          d.Get("xssi_prefix").(string),
 		rateLimit:           d.Get("rate_limit").(float64),
+		errorBodyLength:     d.Get("error_body_length").(int),
+		circuitThreshold:    d.Get("circuit_breaker_threshold").(int),
+		circuitWindow:       d.Get("circuit_breaker_window").(int),
+		circuitCooldown:     d.Get("circuit_breaker_cooldown").(int),
+		maxResponseBytes:    int64(d.Get("max_response_bytes").(int)),
+		enableHTTPCache:     d.Get("enable_http_cache").(bool),
+		enableReadCache:     d.Get("enable_read_cache").(bool),
+		verboseErrors:       d.Get("verbose_errors").(bool),
+		readFailureMode:     d.Get("read_failure_mode").(string),
+		verboseErrorLines:   d.Get("verbose_errors_max_lines").(int),
+		slowThreshold:       d.Get("slow_request_threshold").(int),
+		maxPages:            d.Get("max_pages").(int),
+		userAgent:           userAgent,
+		transport:           transport,
 		debug:               d.Get("debug").(bool),
 	}
 
@@ -249,13 +461,20 @@ func configureProvider(d *schema.ResourceData) (interface{}, error) {
 		opt.oauthClientSecret = oauthConfig["oauth_client_secret"].(string)
 		opt.oauthTokenURL = oauthConfig["oauth_token_endpoint"].(string)
 		opt.oauthScopes = expandStringSet(oauthConfig["oauth_scopes"].([]interface{}))
+		opt.oauthExpirySkew = oauthConfig["oauth_expiry_skew"].(int)
 
-		if tmp, ok := oauthConfig["endpoint_params"]; ok {
-			m := tmp.(map[string][]string)
+		/* terraform hands maps over as map[string]interface{}, whatever
+		   the schema says their values are */
+		if tmp, ok := oauthConfig["endpoint_params"].(map[string]interface{}); ok {
 			setVals := url.Values{}
-			for k, vals := range m {
-				for _, val := range vals {
-					setVals.Add(k, val)
+			for k, vals := range tmp {
+				switch vals := vals.(type) {
+				case string:
+					setVals.Add(k, vals)
+				case []interface{}:
+					for _, val := range vals {
+						setVals.Add(k, fmt.Sprint(val))
+					}
 				}
 			}
 			opt.oauthEndpointParams = setVals
@@ -269,13 +488,20 @@ func configureProvider(d *schema.ResourceData) (interface{}, error) {
 	}
 
 	client, err := NewAPIClient(opt)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.Get("skip_connectivity_checks").(bool) {
+		return client, nil
+	}
 
 	if v, ok := d.GetOk("test_path"); ok {
 		testPath := v.(string)
-		_, err := client.sendRequest(client.readMethod, testPath, "")
+		_, err := client.sendRequest(client.readMethod, testPath, "", withContext(ctx))
 		if err != nil {
-			return client, fmt.Errorf("a test request to %v after setting up the provider did not return an OK response - is your configuration correct? %v", testPath, err)
+			return client, fmt.Errorf("a test request to %v after setting up the provider did not return an OK response - is your configuration correct? %w", testPath, err)
 		}
 	}
-	return client, err
+	return client, nil
 }
@@ -0,0 +1,34 @@
+package restapi
+
+import (
+	"context"
+
+	"github.com/hashicorp/terraform-plugin-framework/providerserver"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov6"
+	"github.com/hashicorp/terraform-plugin-mux/tf5to6server"
+	"github.com/hashicorp/terraform-plugin-mux/tf6muxserver"
+)
+
+/*ProviderServerFactory combines the SDK and framework halves of the
+  provider into a single protocol 6 server, for the given release version
+  and commit. The SDK half is upgraded from protocol 5, which needs
+  terraform 1.0 or newer */
+func ProviderServerFactory(ctx context.Context, version string, commit string) (func() tfprotov6.ProviderServer, error) {
+	upgraded, err := tf5to6server.UpgradeServer(ctx, New(version, commit)().GRPCProvider)
+	if err != nil {
+		return nil, err
+	}
+
+	servers := []func() tfprotov6.ProviderServer{
+		func() tfprotov6.ProviderServer {
+			return upgraded
+		},
+		providerserver.NewProtocol6(NewFrameworkProvider(version, commit)()),
+	}
+
+	muxServer, err := tf6muxserver.NewMuxServer(ctx, servers...)
+	if err != nil {
+		return nil, err
+	}
+	return muxServer.ProviderServer, nil
+}
@@ -1,33 +1,65 @@
 package restapi
 
 import (
+	"context"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/Mastercard/terraform-provider-restapi/fakeserver"
 
-	"github.com/hashicorp/terraform/config"
-	"github.com/hashicorp/terraform/helper/schema"
-	"github.com/hashicorp/terraform/terraform"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov6"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
 )
 
-var testAccProvider terraform.ResourceProvider
-var testAccProviders map[string]terraform.ResourceProvider
-
-func init() {
-	testAccProvider = Provider().(terraform.ResourceProvider)
-	testAccProviders = map[string]terraform.ResourceProvider{
-		"restapi": testAccProvider,
-	}
+/* The acceptance tests run against the same muxed server terraform does */
+var testAccProtoV6ProviderFactories = map[string]func() (tfprotov6.ProviderServer, error){
+	"restapi": func() (tfprotov6.ProviderServer, error) {
+		serverFactory, err := ProviderServerFactory(context.Background(), "test", "")
+		if err != nil {
+			return nil, err
+		}
+		return serverFactory(), nil
+	},
 }
 
 func TestProvider(t *testing.T) {
-	if err := Provider().(*schema.Provider).InternalValidate(); err != nil {
+	if err := Provider().InternalValidate(); err != nil {
 		t.Fatalf("err: %s", err)
 	}
 }
 
-func TestProvider_impl(t *testing.T) {
-	var _ terraform.ResourceProvider = Provider()
+func TestProviderServer(t *testing.T) {
+	/* Whether uri is required depends on REST_API_URI, and
+	   both halves have to agree either way */
+	for _, uri := range []string{"", "http://127.0.0.1:8080/"} {
+		t.Setenv("REST_API_URI", uri)
+
+		serverFactory, err := ProviderServerFactory(context.Background(), "test", "")
+		if err != nil {
+			t.Fatalf("provider_test.go: Could not build the muxed server: %s", err)
+		}
+		resp, err := serverFactory().GetProviderSchema(context.Background(), &tfprotov6.GetProviderSchemaRequest{})
+		if err != nil {
+			t.Fatalf("provider_test.go: %s", err)
+		}
+		for _, d := range resp.Diagnostics {
+			t.Errorf("provider_test.go: Unexpected diagnostic from the muxed server: %s: %s", d.Summary, d.Detail)
+		}
+		for _, name := range []string{"restapi_object", "restapi_object_collection"} {
+			if _, ok := resp.ResourceSchemas[name]; !ok {
+				t.Errorf("provider_test.go: Expected the muxed server to serve resource %s", name)
+			}
+		}
+		for _, name := range []string{"restapi_object", "restapi_provider_info"} {
+			if _, ok := resp.DataSourceSchemas[name]; !ok {
+				t.Errorf("provider_test.go: Expected the muxed server to serve data source %s", name)
+			}
+		}
+	}
 }
 
 func TestResourceProvider_RequireBasic(t *testing.T) {
@@ -35,19 +67,14 @@ func TestResourceProvider_RequireBasic(t *testing.T) {
 
 	raw := map[string]interface{}{}
 
-	rawConfig, err := config.NewRawConfig(raw)
-	if err != nil {
-		t.Fatalf("err: %s", err)
-	}
-
 	/*
 	   XXX: This is expected to work even though we are not
 	        explicitly declaring the required url parameter since
 	        the test suite is run with the ENV entry set.
 	*/
-	err = rp.Configure(terraform.NewResourceConfig(rawConfig))
-	if err != nil {
-		t.Fatalf("Provider failed with error: %s", err)
+	diags := rp.Configure(context.Background(), terraform.NewResourceConfigRaw(raw))
+	if diags.HasError() {
+		t.Fatalf("Provider failed with error: %v", diags)
 	}
 }
 
@@ -59,7 +86,7 @@ func TestResourceProvider_Oauth(t *testing.T) {
 		"oauth_client_credentials": map[string]interface{}{
 			"oauth_client_id": "test",
 			"oauth_client_credentials": map[string]interface{}{
-				"test": []string{
+				"test": []interface{}{
 					"value1",
 					"value2",
 				},
@@ -67,19 +94,14 @@ func TestResourceProvider_Oauth(t *testing.T) {
 		},
 	}
 
-	rawConfig, err := config.NewRawConfig(raw)
-	if err != nil {
-		t.Fatalf("err: %s", err)
-	}
-
 	/*
 	   XXX: This is expected to work even though we are not
 	        explicitly declaring the required url parameter since
 	        the test suite is run with the ENV entry set.
 	*/
-	err = rp.Configure(terraform.NewResourceConfig(rawConfig))
-	if err != nil {
-		t.Fatalf("Provider failed with error: %s", err)
+	diags := rp.Configure(context.Background(), terraform.NewResourceConfigRaw(raw))
+	if diags.HasError() {
+		t.Fatalf("Provider failed with error: %v", diags)
 	}
 }
 
@@ -87,41 +109,213 @@ func TestResourceProvider_RequireTestPath(t *testing.T) {
 	debug := false
 	apiServerObjects := make(map[string]map[string]interface{})
 
-	svr := fakeserver.NewFakeServer(8085, apiServerObjects, true, debug, "")
-	svr.StartInBackground()
+	/* The provider reaches the fakeserver through the transport it is given */
+	svr := fakeserver.NewFakeServer(0, apiServerObjects, false, debug, "")
+	newProvider := NewProviderWithTransport("test", "", handlerTransport{svr.Handler()})
 
-	rp := Provider()
+	rp := newProvider()
 	raw := map[string]interface{}{
-		"uri":       "http://127.0.0.1:8085/",
+		"uri":       "http://fakeserver.invalid/",
 		"test_path": "/api/objects",
 	}
 
-	rawConfig, err := config.NewRawConfig(raw)
-	if err != nil {
-		t.Fatalf("err: %s", err)
+	diags := rp.Configure(context.Background(), terraform.NewResourceConfigRaw(raw))
+	if diags.HasError() {
+		t.Fatalf("Explicit provider configuration failed with error: %v", diags)
 	}
-
-	err = rp.Configure(terraform.NewResourceConfig(rawConfig))
-	if err != nil {
-		t.Fatalf("Explicit provider configuration failed with error: %s", err)
+	if requests := svr.Requests(); len(requests) != 1 || requests[0].URL != "/api/objects" {
+		t.Fatalf("provider_test.go: Expected the test_path request to go through the transport but the server saw %v", requests)
 	}
 
 	/* Now test the inverse */
-	rp = Provider()
+	rp = newProvider()
 	raw = map[string]interface{}{
-		"uri":       "http://127.0.0.1:8085/",
+		"uri":       "http://fakeserver.invalid/",
 		"test_path": "/api/apaththatdoesnotexist",
 	}
 
-	rawConfig, err = config.NewRawConfig(raw)
-	if err != nil {
-		t.Fatalf("err: %s", err)
+	diags = rp.Configure(context.Background(), terraform.NewResourceConfigRaw(raw))
+	if !diags.HasError() {
+		t.Fatalf("Provider was expected to fail when visiting %v at %v but it did not!", raw["test_path"], raw["uri"])
 	}
+}
 
-	err = rp.Configure(terraform.NewResourceConfig(rawConfig))
-	if err == nil {
-		t.Fatalf("Provider was expected to fail when visiting %v at %v but it did not!", raw["test_path"], raw["uri"])
+func TestResourceProvider_UserAgent(t *testing.T) {
+	debug := false
+	svr := fakeserver.NewFakeServer(0, make(map[string]map[string]interface{}), false, debug, "")
+
+	cases := []struct {
+		commit   string
+		expected string
+	}{
+		{commit: "abc123", expected: "terraform-provider-restapi/1.2.3 (abc123)"},
+		/* A build without a commit just leaves it out */
+		{commit: "", expected: "terraform-provider-restapi/1.2.3"},
+	}
+
+	for _, c := range cases {
+		seen := len(svr.Requests())
+		rp := NewProviderWithTransport("1.2.3", c.commit, handlerTransport{svr.Handler()})()
+		raw := map[string]interface{}{
+			"uri":       "http://fakeserver.invalid/",
+			"test_path": "/api/objects",
+		}
+		if diags := rp.Configure(context.Background(), terraform.NewResourceConfigRaw(raw)); diags.HasError() {
+			t.Fatalf("provider_test.go: %v", diags)
+		}
+
+		requests := svr.Requests()[seen:]
+		if len(requests) != 1 {
+			t.Fatalf("provider_test.go: expected the test_path request but the server saw %v", requests)
+		}
+		if got := requests[0].Header.Get("User-Agent"); got != c.expected {
+			t.Errorf("provider_test.go: expected the User-Agent '%s' but the server received '%s'", c.expected, got)
+		}
+	}
+}
+
+func TestResourceProvider_LazyOAuth(t *testing.T) {
+	debug := false
+	apiServerObjects := map[string]map[string]interface{}{"1": {"id": "1"}}
+
+	svr := fakeserver.NewFakeServer(0, apiServerObjects, false, debug, "")
+	svr.SetAuth(fakeserver.Auth{ClientID: "id", ClientSecret: "secret"})
+	newProvider := NewProviderWithTransport("test", "", handlerTransport{svr.Handler()})
+	config := func(skip bool) map[string]interface{} {
+		return map[string]interface{}{
+			"uri":                      "http://fakeserver.invalid/",
+			"test_path":                "/api/objects/1",
+			"skip_connectivity_checks": skip,
+			"oauth_client_credentials": []interface{}{map[string]interface{}{
+				"oauth_client_id":      "id",
+				"oauth_client_secret":  "secret",
+				"oauth_token_endpoint": "http://fakeserver.invalid/oauth/token",
+			}},
+		}
+	}
+
+	/* Configuring the provider with the checks skipped touches nothing */
+	rp := newProvider()
+	if diags := rp.Configure(context.Background(), terraform.NewResourceConfigRaw(config(true))); diags.HasError() {
+		t.Fatalf("provider_test.go: configuring the provider failed: %v", diags)
+	}
+	if svr.TokensIssued() != 0 || len(svr.Requests()) != 0 {
+		t.Fatalf("provider_test.go: expected no requests while configuring but %d tokens were issued and the API saw %v", svr.TokensIssued(), svr.Requests())
 	}
 
-	svr.Shutdown()
+	/* The token is fetched for the first request that needs one */
+	client := rp.Meta().(*APIClient)
+	if _, err := client.sendRequest("GET", "/api/objects/1", ""); err != nil {
+		t.Fatalf("provider_test.go: %s", err)
+	}
+	if svr.TokensIssued() != 1 {
+		t.Errorf("provider_test.go: expected the first request to fetch a token but %d were issued", svr.TokensIssued())
+	}
+
+	/* Without skip_connectivity_checks, test_path needs the token straight away */
+	rp = newProvider()
+	if diags := rp.Configure(context.Background(), terraform.NewResourceConfigRaw(config(false))); diags.HasError() {
+		t.Fatalf("provider_test.go: configuring the provider failed: %v", diags)
+	}
+	if svr.TokensIssued() != 2 {
+		t.Errorf("provider_test.go: expected test_path to fetch a token but %d were issued", svr.TokensIssued())
+	}
+
+	/* A token endpoint that cannot be reached only matters to the first
+	   request, which says which endpoint it was */
+	raw := config(true)
+	raw["oauth_client_credentials"].([]interface{})[0].(map[string]interface{})["oauth_token_endpoint"] = "http://fakeserver.invalid/no/token/here"
+	rp = newProvider()
+	if diags := rp.Configure(context.Background(), terraform.NewResourceConfigRaw(raw)); diags.HasError() {
+		t.Fatalf("provider_test.go: configuring the provider failed: %v", diags)
+	}
+	_, err := rp.Meta().(*APIClient).sendRequest("GET", "/api/objects/1", "")
+	if err == nil || !strings.Contains(err.Error(), "failed to obtain an oauth token from http://fakeserver.invalid/no/token/here") {
+		t.Errorf("provider_test.go: expected the token failure to name the endpoint but got: %v", err)
+	}
+}
+
+func TestResourceProvider_OAuthExpirySkew(t *testing.T) {
+	config := func(skew interface{}) map[string]interface{} {
+		oauth := map[string]interface{}{
+			"oauth_client_id":      "id",
+			"oauth_client_secret":  "secret",
+			"oauth_token_endpoint": "http://fakeserver.invalid/oauth/token",
+		}
+		if skew != nil {
+			oauth["oauth_expiry_skew"] = skew
+		}
+		return map[string]interface{}{
+			"uri":                      "http://fakeserver.invalid/",
+			"skip_connectivity_checks": true,
+			"oauth_client_credentials": []interface{}{oauth},
+		}
+	}
+
+	cases := []struct {
+		skew     interface{}
+		expected time.Duration
+	}{
+		{skew: nil, expected: defaultOAuthExpirySkew * time.Second},
+		{skew: 30, expected: 30 * time.Second},
+		{skew: 0, expected: 0},
+	}
+	for _, c := range cases {
+		rp := Provider()
+		if diags := rp.Configure(context.Background(), terraform.NewResourceConfigRaw(config(c.skew))); diags.HasError() {
+			t.Fatalf("provider_test.go: configuring the provider with oauth_expiry_skew %v failed: %v", c.skew, diags)
+		}
+		if got := rp.Meta().(*APIClient).oauthTokenSource.skew; got != c.expected {
+			t.Errorf("provider_test.go: expected oauth_expiry_skew %v to give a skew of %s but got %s", c.skew, c.expected, got)
+		}
+	}
+
+	if diags := Provider().Validate(terraform.NewResourceConfigRaw(config(-1))); !diags.HasError() {
+		t.Errorf("provider_test.go: expected a negative oauth_expiry_skew to be rejected")
+	}
+	if _, err := NewAPIClient(&apiClientOpt{uri: "http://fakeserver.invalid", oauthExpirySkew: -1}); err == nil {
+		t.Errorf("provider_test.go: expected NewAPIClient to refuse a negative oauth_expiry_skew")
+	}
+}
+
+/* A plan with nothing for the provider to manage fetches no token */
+func TestAccRestApiProvider_NoResources(t *testing.T) {
+	debug := false
+	svr := fakeserver.NewFakeServer(0, make(map[string]map[string]interface{}), false, debug, "")
+	svr.SetAuth(fakeserver.Auth{ClientID: "id", ClientSecret: "secret"})
+	newProvider := NewProviderWithTransport("test", "", handlerTransport{svr.Handler()})
+
+	resource.UnitTest(t, resource.TestCase{
+		ProviderFactories: map[string]func() (*schema.Provider, error){
+			"restapi": func() (*schema.Provider, error) {
+				return newProvider(), nil
+			},
+		},
+		Steps: []resource.TestStep{
+			{
+				Config: `
+provider "restapi" {
+  uri                      = "http://fakeserver.invalid/"
+  test_path                = "/api/objects"
+  skip_connectivity_checks = true
+  oauth_client_credentials {
+    oauth_client_id      = "id"
+    oauth_client_secret  = "secret"
+    oauth_token_endpoint = "http://fakeserver.invalid/oauth/token"
+  }
+}
+
+output "nothing" {
+  value = "managed"
+}
+`,
+				Check: func(s *terraform.State) error {
+					if svr.TokensIssued() != 0 || len(svr.Requests()) != 0 {
+						return fmt.Errorf("provider_test.go: expected no requests but %d tokens were issued and the API saw %v", svr.TokensIssued(), svr.Requests())
+					}
+					return nil
+				},
+			},
+		},
+	})
 }
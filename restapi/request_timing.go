@@ -0,0 +1,130 @@
+package restapi
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+)
+
+/*requestTimer records where the time went during a single request
+  using the hooks provided by net/http/httptrace. The transport can
+  still be dialing for a request after it has finished on another
+  connection, so the hooks may fire late and from other goroutines */
+type requestTimer struct {
+	mutex        sync.Mutex
+	start        time.Time
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	wroteRequest time.Time
+	firstByte    time.Time
+	end          time.Time
+	reused       bool
+}
+
+func newRequestTimer() *requestTimer {
+	return &requestTimer{start: time.Now()}
+}
+
+func (rt *requestTimer) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			rt.mutex.Lock()
+			defer rt.mutex.Unlock()
+			rt.reused = info.Reused
+		},
+		DNSStart:             func(httptrace.DNSStartInfo) { rt.mark(&rt.dnsStart) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { rt.mark(&rt.dnsDone) },
+		ConnectStart:         func(string, string) { rt.mark(&rt.connectStart) },
+		ConnectDone:          func(string, string, error) { rt.mark(&rt.connectDone) },
+		TLSHandshakeStart:    func() { rt.mark(&rt.tlsStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { rt.mark(&rt.tlsDone) },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { rt.mark(&rt.wroteRequest) },
+		GotFirstResponseByte: func() { rt.mark(&rt.firstByte) },
+	}
+}
+
+/* Record that a phase started or ended now */
+func (rt *requestTimer) mark(t *time.Time) {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	*t = time.Now()
+}
+
+/* Mark the request as finished (body fully read) */
+func (rt *requestTimer) done() {
+	rt.mark(&rt.end)
+}
+
+func (rt *requestTimer) total() time.Duration {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	return rt.totalLocked()
+}
+
+func (rt *requestTimer) totalLocked() time.Duration {
+	if rt.end.IsZero() {
+		return time.Since(rt.start)
+	}
+	return rt.end.Sub(rt.start)
+}
+
+/* A single line breakdown of the phases of the request. Phases
+   that did not happen (such as dns and connect on a reused
+   connection) are reported as 0s */
+func (rt *requestTimer) breakdown() string {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+
+	/* Time to first byte is measured from when the request was sent */
+	ttfbFrom := rt.wroteRequest
+	if ttfbFrom.IsZero() {
+		ttfbFrom = rt.start
+	}
+	transfer := time.Duration(0)
+	if !rt.firstByte.IsZero() && !rt.end.IsZero() {
+		transfer = rt.end.Sub(rt.firstByte)
+	}
+
+	return fmt.Sprintf("dns=%s connect=%s tls=%s ttfb=%s transfer=%s total=%s reused=%t",
+		phase(rt.dnsStart, rt.dnsDone),
+		phase(rt.connectStart, rt.connectDone),
+		phase(rt.tlsStart, rt.tlsDone),
+		phase(ttfbFrom, rt.firstByte),
+		transfer.Round(time.Microsecond),
+		rt.totalLocked().Round(time.Microsecond),
+		rt.reused,
+	)
+}
+
+func phase(start time.Time, end time.Time) time.Duration {
+	if start.IsZero() || end.IsZero() {
+		return 0
+	}
+	return end.Sub(start).Round(time.Microsecond)
+}
+
+/* Every request gets a breakdown at TRACE. Requests slower than
+   slow_request_threshold are also called out at WARN. Requests made
+   for a resource are logged through tflog, tagged with the resource */
+func (client *APIClient) logTiming(ctx context.Context, method string, url string, status int, timer *requestTimer) {
+	breakdown := timer.breakdown()
+	if resourceTraceFrom(ctx) != nil {
+		tflog.Trace(ctx, fmt.Sprintf("api_client.go: %s %s (%d) timing: %s", method, url, status, breakdown))
+	} else {
+		log.Printf("[TRACE] api_client.go: %s %s (%d) timing: %s", method, url, status, breakdown)
+	}
+
+	if client.slowThreshold > 0 && timer.total() > client.slowThreshold {
+		log.Printf("[WARN] api_client.go: Slow request - %s %s (%d) took longer than %s: %s", method, url, status, client.slowThreshold, breakdown)
+	}
+}
@@ -0,0 +1,73 @@
+package restapi
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Mastercard/terraform-provider-restapi/fakeserver"
+)
+
+func TestRequestTimingSlowRequests(t *testing.T) {
+	debug := false
+	apiServerObjects := map[string]map[string]interface{}{
+		"1": {"id": "1"},
+	}
+
+	svr := fakeserver.NewFakeServer(8088, apiServerObjects, true, debug, "")
+	defer svr.Shutdown()
+
+	client, err := NewAPIClient(&apiClientOpt{
+		uri:           "http://127.0.0.1:8088",
+		timeout:       5,
+		slowThreshold: 1,
+		debug:         debug,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	/* Fast requests are only traced */
+	for i := 0; i < 2; i++ {
+		if _, err := client.sendRequest("GET", "/api/objects/1", ""); err != nil {
+			t.Fatalf("request_timing_test.go: %s", err)
+		}
+	}
+	if strings.Contains(logs.String(), "[WARN]") {
+		t.Fatalf("request_timing_test.go: fast requests should not be logged as slow:\n%s", logs.String())
+	}
+	if !strings.Contains(logs.String(), "[TRACE] api_client.go: GET http://127.0.0.1:8088/api/objects/1 (200) timing:") {
+		t.Fatalf("request_timing_test.go: expected a timing breakdown at TRACE:\n%s", logs.String())
+	}
+	if !strings.Contains(logs.String(), "reused=true") {
+		t.Errorf("request_timing_test.go: expected the second request to reuse the connection:\n%s", logs.String())
+	}
+
+	logs.Reset()
+	svr.SetDelay(1500 * time.Millisecond)
+	if _, err := client.sendRequest("GET", "/api/objects/1", ""); err != nil {
+		t.Fatalf("request_timing_test.go: %s", err)
+	}
+
+	var warning string
+	for _, line := range strings.Split(logs.String(), "\n") {
+		if strings.Contains(line, "[WARN] api_client.go: Slow request") {
+			warning = line
+		}
+	}
+	if warning == "" {
+		t.Fatalf("request_timing_test.go: expected the delayed request to be logged as slow:\n%s", logs.String())
+	}
+	for _, field := range []string{"dns=", "connect=", "tls=", "ttfb=", "transfer=", "total=", "reused="} {
+		if !strings.Contains(warning, field) {
+			t.Errorf("request_timing_test.go: slow request warning is missing '%s': %s", field, warning)
+		}
+	}
+}
@@ -1,14 +1,18 @@
 package restapi
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"runtime"
 	"strconv"
 	"strings"
 
-	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 )
 
 func resourceRestAPI() *schema.Resource {
@@ -16,14 +20,15 @@ func resourceRestAPI() *schema.Resource {
 	isDataSensitive, _ := strconv.ParseBool(GetEnvOrDefault("API_DATA_IS_SENSITIVE", "false"))
 
 	return &schema.Resource{
-		Create: resourceRestAPICreate,
-		Read:   resourceRestAPIRead,
-		Update: resourceRestAPIUpdate,
-		Delete: resourceRestAPIDelete,
-		Exists: resourceRestAPIExists,
+		CreateContext: traced("create", objectAddress("restapi_object"), resourceRestAPICreate),
+		ReadContext:   traced("read", objectAddress("restapi_object"), resourceRestAPIRead),
+		UpdateContext: traced("update", objectAddress("restapi_object"), resourceRestAPIUpdate),
+		DeleteContext: traced("delete", objectAddress("restapi_object"), resourceRestAPIDelete),
+
+		CustomizeDiff: resourceRestAPICustomizeDiff,
 
 		Importer: &schema.ResourceImporter{
-			State: resourceRestAPIImport,
+			StateContext: resourceRestAPIImport,
 		},
 
 		Schema: map[string]*schema.Schema{
@@ -48,24 +53,39 @@ func resourceRestAPI() *schema.Resource {
 				Optional:    true,
 			},
 			"create_method": {
+				Type:         schema.TypeString,
+				Description:  "Defaults to `create_method` set on the provider. Allows per-resource override of `create_method` (see `create_method` provider config documentation)",
+				Optional:     true,
+				ValidateFunc: validateHTTPMethod,
+			},
+			"create_response_id_attribute": {
 				Type:        schema.TypeString,
-				Description: "Defaults to `create_method` set on the provider. Allows per-resource override of `create_method` (see `create_method` provider config documentation)",
+				Description: "For APIs that wrap the created object in their response to creating it (such as `{\"result\":{\"id\":...}}`), the path to the id in that response (such as `result/id`). The object is then read back from `read_path`. Without this, the id is looked for at `id_attribute` in the response and then in the `Location` header.",
 				Optional:    true,
 			},
 			"read_method": {
-				Type:        schema.TypeString,
-				Description: "Defaults to `read_method` set on the provider. Allows per-resource override of `read_method` (see `read_method` provider config documentation)",
-				Optional:    true,
+				Type:         schema.TypeString,
+				Description:  "Defaults to `read_method` set on the provider. Allows per-resource override of `read_method` (see `read_method` provider config documentation)",
+				Optional:     true,
+				ValidateFunc: validateHTTPMethod,
 			},
 			"update_method": {
-				Type:        schema.TypeString,
-				Description: "Defaults to `update_method` set on the provider. Allows per-resource override of `update_method` (see `update_method` provider config documentation)",
-				Optional:    true,
+				Type:         schema.TypeString,
+				Description:  "Defaults to `update_method` set on the provider. Allows per-resource override of `update_method` (see `update_method` provider config documentation)",
+				Optional:     true,
+				ValidateFunc: validateHTTPMethod,
 			},
 			"destroy_method": {
-				Type:        schema.TypeString,
-				Description: "Defaults to `destroy_method` set on the provider. Allows per-resource override of `destroy_method` (see `destroy_method` provider config documentation)",
-				Optional:    true,
+				Type:         schema.TypeString,
+				Description:  "Defaults to `destroy_method` set on the provider. Allows per-resource override of `destroy_method` (see `destroy_method` provider config documentation)",
+				Optional:     true,
+				ValidateFunc: validateHTTPMethod,
+			},
+			"destroy_data": {
+				Type:         schema.TypeString,
+				Description:  "Valid JSON data to send as the body of the request to destroy the object, for APIs that require one. By default no body is sent.",
+				Optional:     true,
+				ValidateFunc: validateJSONObject,
 			},
 			"destroy_path": {
 				Type:        schema.TypeString,
@@ -84,20 +104,13 @@ func resourceRestAPI() *schema.Resource {
 			},
 			"data": {
 				Type:        schema.TypeString,
-				Description: "Valid JSON data that this provider will manage with the API server.",
+				Description: "Valid JSON data that this provider will manage with the API server. Changes to whitespace or the order of keys are not considered a difference.",
 				Required:    true,
 				Sensitive:   isDataSensitive,
-				ValidateFunc: func(val interface{}, key string) (warns []string, errs []error) {
-					v := val.(string)
-					if v != "" {
-						data := make(map[string]interface{})
-						err := json.Unmarshal([]byte(v), &data)
-						if err != nil {
-							errs = append(errs, fmt.Errorf("data attribute is invalid JSON: %v", err))
-						}
-					}
-					return warns, errs
+				DiffSuppressFunc: func(k string, old string, new string, d *schema.ResourceData) bool {
+					return jsonEquivalent(old, new)
 				},
+				ValidateFunc: validateJSONObject,
 			},
 			"debug": {
 				Type:        schema.TypeBool,
@@ -106,13 +119,38 @@ func resourceRestAPI() *schema.Resource {
 			},
 			"read_search": {
 				Type:        schema.TypeMap,
-				Description: "Custom search for `read_path`. This map will take `search_key`, `search_value`, `results_key` and `query_string` (see datasource config documentation)",
+				Description: "Custom search for `read_path`. This map will take `search_key`, `search_value`, `results_key` and `query_string` (see datasource config documentation), and optionally `search_path`. When `search_path` is set, or the id of the object is not known (such as after creating an object when the API does not return it), the object is located by searching `search_path` (default `path`) and its id is taken from the match.",
 				Optional:    true,
 			},
 			"query_string": {
-				Type:        schema.TypeString,
-				Description: "Query string to be included in the path",
-				Optional:    true,
+				Type:         schema.TypeString,
+				Description:  "Query string to be included in the path of every request for the object, including searches. It is added to any query string already in the path.",
+				Optional:     true,
+				ValidateFunc: validateQueryString,
+			},
+			"create_query_string": {
+				Type:         schema.TypeString,
+				Description:  "Defaults to `query_string`. Allows a different query string to be sent when creating the object.",
+				Optional:     true,
+				ValidateFunc: validateQueryString,
+			},
+			"read_query_string": {
+				Type:         schema.TypeString,
+				Description:  "Defaults to `query_string`. Allows a different query string to be sent when reading the object, including searches.",
+				Optional:     true,
+				ValidateFunc: validateQueryString,
+			},
+			"update_query_string": {
+				Type:         schema.TypeString,
+				Description:  "Defaults to `query_string`. Allows a different query string to be sent when updating the object.",
+				Optional:     true,
+				ValidateFunc: validateQueryString,
+			},
+			"destroy_query_string": {
+				Type:         schema.TypeString,
+				Description:  "Defaults to `query_string`. Allows a different query string to be sent when destroying the object.",
+				Optional:     true,
+				ValidateFunc: validateQueryString,
 			},
 			"api_data": {
 				Type: schema.TypeMap,
@@ -123,6 +161,18 @@ func resourceRestAPI() *schema.Resource {
 				Computed:    true,
 				Sensitive:   isDataSensitive,
 			},
+			"extract": {
+				Type:        schema.TypeMap,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Optional:    true,
+				Description: "A map of output names to paths within the object returned by the API server (in the same format as `id_attribute`, such as `network/ips/0`). After the object is read, the value at each path is available under the output name in `extracted`.",
+			},
+			"extracted": {
+				Type:        schema.TypeMap,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Computed:    true,
+				Description: "The values found for each of the paths in `extract`. Scalars are given as they are, and hashes and arrays as JSON. A path that is not in the object gives an empty string.",
+			},
 			"api_response": {
 				Type:        schema.TypeString,
 				Description: "The raw body of the HTTP response from the last read of the object.",
@@ -140,18 +190,62 @@ func resourceRestAPI() *schema.Resource {
 				Elem:        &schema.Schema{Type: schema.TypeString},
 				Optional:    true,
 				ForceNew:    true,
-				Description: "Any changes to these values will result in recreating the resource instead of updating.",
+				Deprecated:  "force_new recreates the object when the list itself changes, not when anything in data does. Use force_new_keys to recreate the object when the value at a path in data changes, or the replace_triggered_by lifecycle argument to recreate it when some other value changes.",
+				Description: "Any change to the values in this list (not to the data at them) will result in recreating the resource instead of updating. Deprecated: use `force_new_keys` for paths within `data`, or `replace_triggered_by` for other values.",
+			},
+			"ignore_keys": {
+				Type:        schema.TypeList,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Optional:    true,
+				Description: "Paths within the object (such as `updated_at` or `meta/hash`) that the API server changes on its own. Changes made to these by the server are not reflected in `data`, so they do not show up as differences. Their values are still available in `api_data` and `api_response`.",
+			},
+			"ignore_all_server_changes": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Description: "By default, changes made to the object on the API server are reflected in `data` when it is read, so that they show up as differences to be put right. Set this to true to treat `data` as write-only: only the object no longer existing is detected.",
+			},
+			"force_new_keys": {
+				Type:        schema.TypeList,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Optional:    true,
+				Description: "Paths within `data` (such as `name` or `config/tags/0`) that the API will not change once the object is created. Any change to the value at one of these paths will result in recreating the resource instead of updating it. Unlike `force_new`, the paths themselves can be changed without recreating it.",
 			},
 		}, /* End schema */
 
 	}
 }
 
+/* Recreate the object, rather than updating it, when a value
+   the API treats as immutable changes within data. If data is not
+   known until apply, the decision is left until it is */
+func resourceRestAPICustomizeDiff(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
+	if d.Id() == "" || !d.HasChange("data") || !d.NewValueKnown("data") {
+		return nil
+	}
+
+	paths := expandStringList(d.Get("force_new_keys").([]interface{}))
+	if len(paths) == 0 {
+		return nil
+	}
+
+	oldData, newData := d.GetChange("data")
+	changed, err := changedKeys(oldData.(string), newData.(string), paths)
+	if err != nil {
+		return err
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+
+	log.Printf("resource_api_object.go: force_new_keys changed (%s). The object will be recreated.", strings.Join(changed, ", "))
+	return d.ForceNew("data")
+}
+
 /* Since there is nothing in the ResourceData structure other
    than the "id" passed on the command line, we have to use an opinionated
    view of the API paths to figure out how to read that object
    from the API */
-func resourceRestAPIImport(d *schema.ResourceData, meta interface{}) (imported []*schema.ResourceData, err error) {
+func resourceRestAPIImport(ctx context.Context, d *schema.ResourceData, meta interface{}) (imported []*schema.ResourceData, err error) {
 	input := d.Id()
 
 	hasTrailingSlash := strings.HasSuffix(input, "/")
@@ -189,7 +283,10 @@ func resourceRestAPIImport(d *schema.ResourceData, meta interface{}) (imported [
 	}
 	log.Printf("resource_api_object.go: Import routine called. Object built:\n%s\n", obj.toString())
 
-	err = obj.readObject()
+	err = obj.readObject(ctx)
+	if err == nil && obj.id == "" {
+		return imported, fmt.Errorf("cannot import '%s' - the API says there is no such object", input)
+	}
 	if err == nil {
 		setResourceState(obj, d)
 		/* Data that we set in the state above must be passed along
@@ -200,107 +297,138 @@ func resourceRestAPIImport(d *schema.ResourceData, meta interface{}) (imported [
 	return imported, err
 }
 
-func resourceRestAPICreate(d *schema.ResourceData, meta interface{}) error {
+func resourceRestAPICreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	obj, err := makeAPIObject(d, meta)
 	if err != nil {
-		return err
+		return errorDiagnostics("Invalid restapi_object configuration", err)
 	}
 	log.Printf("resource_api_object.go: Create routine called. Object built:\n%s\n", obj.toString())
 
-	err = obj.createObject()
-	if err == nil {
-		/* Setting terraform ID tells terraform the object was created or it exists */
-		d.SetId(obj.id)
-		setResourceState(obj, d)
-		/* Only set during create for APIs that don't return sensitive data on subsequent retrieval */
-		d.Set("create_response", obj.apiResponse)
+	if err := obj.createObject(ctx); err != nil {
+		return writeDiagnostics(fmt.Sprintf("Could not create the object at '%s'", obj.postPath), err, obj.data)
 	}
-	return err
+
+	/* Setting terraform ID tells terraform the object was created or it exists */
+	d.SetId(obj.id)
+	diags := setResourceState(obj, d)
+	/* Only set during create for APIs that don't return sensitive data on subsequent retrieval */
+	d.Set("create_response", obj.apiResponse)
+	return diags
 }
 
-func resourceRestAPIRead(d *schema.ResourceData, meta interface{}) error {
+func resourceRestAPIRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	var diags diag.Diagnostics
 	obj, err := makeAPIObject(d, meta)
 	if err != nil {
 		if strings.Contains(err.Error(), "error parsing data provided") {
 			log.Printf("resource_api_object.go: WARNING! The data passed from Terraform's state is invalid! %v", err)
 			log.Printf("resource_api_object.go: Continuing with partially constructed object...")
+			diags = append(diags, diag.Diagnostic{
+				Severity:      diag.Warning,
+				Summary:       "The data in state is invalid",
+				Detail:        fmt.Sprintf("%s. The object will be read from the API without it.", err),
+				AttributePath: cty.GetAttrPath("data"),
+			})
 		} else {
-			return err
+			return errorDiagnostics("Invalid restapi_object configuration", err)
 		}
 	}
 	log.Printf("resource_api_object.go: Read routine called. Object built:\n%s\n", obj.toString())
 
-	err = obj.readObject()
-	if err == nil {
-		/* Setting terraform ID tells terraform the object was created or it exists */
-		log.Printf("resource_api_object.go: Read resource. Returned id is '%s'\n", obj.id)
-		d.SetId(obj.id)
-		setResourceState(obj, d)
+	if err := obj.readObject(ctx); err != nil {
+		/* Only a 404 takes the object out of state. Not being allowed
+		   to see it does not mean it is gone */
+		if warnings, keep := readFailureDiagnostics(meta.(*APIClient), fmt.Sprintf("The object '%s'", obj.id), err); keep {
+			return append(diags, warnings...)
+		}
+		var forbidden *ForbiddenError
+		if errors.As(err, &forbidden) {
+			return append(diags, errorDiagnostics(fmt.Sprintf("Not permitted to read the object '%s' - it was NOT removed from state", obj.id), err)...)
+		}
+		return append(diags, errorDiagnostics(fmt.Sprintf("Could not read the object '%s'", obj.id), err)...)
+	}
+
+	/* Setting terraform ID tells terraform the object was created or it exists */
+	log.Printf("resource_api_object.go: Read resource. Returned id is '%s'\n", obj.id)
+	d.SetId(obj.id)
+	diags = append(diags, setResourceState(obj, d)...)
+	if obj.id != "" && !d.Get("ignore_all_server_changes").(bool) {
+		diags = append(diags, refreshData(obj, d)...)
 	}
-	return err
+	return diags
 }
 
-func resourceRestAPIUpdate(d *schema.ResourceData, meta interface{}) error {
+/* Reflect any changes made to the object on the server in data,
+   apart from those to ignore_keys, so they show up as differences.
+   Keys copied back to the server (copy_keys) and a server assigned
+   id are the server's to change, so are not counted */
+func refreshData(obj *APIObject, d *schema.ResourceData) diag.Diagnostics {
+	recorded := make(map[string]interface{})
+	if err := decodeJSON(d.Get("data").(string), &recorded); err != nil {
+		return diag.Diagnostics{{
+			Severity:      diag.Warning,
+			Summary:       "Not checking the object for changes made on the server",
+			Detail:        fmt.Sprintf("The data in state could not be parsed: %s", err),
+			AttributePath: cty.GetAttrPath("data"),
+		}}
+	}
+
+	ignore := expandStringList(d.Get("ignore_keys").([]interface{}))
+	ignore = append(ignore, obj.apiClient.copyKeys...)
+	if _, err := GetObjectAtKey(recorded, obj.idAttribute, false); err != nil {
+		ignore = append(ignore, obj.idAttribute)
+	}
+
+	data, changed := getDelta(recorded, obj.apiData, ignore)
+	if !changed {
+		return nil
+	}
+
+	b, _ := json.Marshal(data)
+	log.Printf("resource_api_object.go: The object '%s' was changed on the server. Updating data to '%s'", obj.id, string(b))
+	d.Set("data", string(b))
+	return nil
+}
+
+func resourceRestAPIUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	obj, err := makeAPIObject(d, meta)
 	if err != nil {
-		return err
+		return errorDiagnostics("Invalid restapi_object configuration", err)
 	}
 
 	/* If copy_keys is not empty, we have to grab the latest
 	   data so we can copy anything needed before the update */
 	client := meta.(*APIClient)
 	if len(client.copyKeys) > 0 {
-		err = obj.readObject()
-		if err != nil {
-			return err
+		if err := obj.readObject(ctx); err != nil {
+			return errorDiagnostics(fmt.Sprintf("Could not read the object '%s' to copy keys from before updating it", obj.id), err)
 		}
 	}
 
 	log.Printf("resource_api_object.go: Update routine called. Object built:\n%s\n", obj.toString())
 
-	err = obj.updateObject()
-	if err == nil {
-		setResourceState(obj, d)
+	if err := obj.updateObject(ctx); err != nil {
+		return writeDiagnostics(fmt.Sprintf("Could not update the object '%s'", obj.id), err, obj.data)
 	}
-	return err
+	return setResourceState(obj, d)
 }
 
-func resourceRestAPIDelete(d *schema.ResourceData, meta interface{}) error {
+func resourceRestAPIDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	obj, err := makeAPIObject(d, meta)
 	if err != nil {
-		return err
+		return errorDiagnostics("Invalid restapi_object configuration", err)
 	}
 	log.Printf("resource_api_object.go: Delete routine called. Object built:\n%s\n", obj.toString())
 
-	err = obj.deleteObject()
+	err = obj.deleteObject(ctx)
 	if err != nil {
-		if strings.Contains(err.Error(), "404") {
+		if isNotFound(err) {
 			/* 404 means it doesn't exist. Call that good enough */
-			err = nil
+			return nil
 		}
+		return errorDiagnostics(fmt.Sprintf("Could not delete the object '%s'", obj.id), err)
 	}
-	return err
-}
-
-func resourceRestAPIExists(d *schema.ResourceData, meta interface{}) (exists bool, err error) {
-	obj, err := makeAPIObject(d, meta)
-	if err != nil {
-		if strings.Contains(err.Error(), "error parsing data provided") {
-			log.Printf("resource_api_object.go: WARNING! The data passed from Terraform's state is invalid! %v", err)
-			log.Printf("resource_api_object.go: Continuing with partially constructed object...")
-		} else {
-			return exists, err
-		}
-	}
-	log.Printf("resource_api_object.go: Exists routine called. Object built: %s\n", obj.toString())
-
-	/* Assume all errors indicate the object just doesn't exist.
-	This may not be a good assumption... */
-	err = obj.readObject()
-	if err == nil {
-		exists = true
-	}
-	return exists, err
+	return nil
 }
 
 /* Simple helper routine to build an api_object struct
@@ -359,6 +487,9 @@ func buildAPIObjectOpts(d *schema.ResourceData) (*apiObjectOpts, error) {
 	if v, ok := d.GetOk("create_method"); ok {
 		opts.createMethod = v.(string)
 	}
+	if v, ok := d.GetOk("create_response_id_attribute"); ok {
+		opts.createResponseIDAttribute = v.(string)
+	}
 	if v, ok := d.GetOk("read_method"); ok {
 		opts.readMethod = v.(string)
 	}
@@ -368,12 +499,27 @@ func buildAPIObjectOpts(d *schema.ResourceData) (*apiObjectOpts, error) {
 	if v, ok := d.GetOk("destroy_method"); ok {
 		opts.destroyMethod = v.(string)
 	}
+	if v, ok := d.GetOk("destroy_data"); ok {
+		opts.destroyData = v.(string)
+	}
 	if v, ok := d.GetOk("destroy_path"); ok {
 		opts.deletePath = v.(string)
 	}
 	if v, ok := d.GetOk("query_string"); ok {
 		opts.queryString = v.(string)
 	}
+	if v, ok := d.GetOk("create_query_string"); ok {
+		opts.createQueryString = v.(string)
+	}
+	if v, ok := d.GetOk("read_query_string"); ok {
+		opts.readQueryString = v.(string)
+	}
+	if v, ok := d.GetOk("update_query_string"); ok {
+		opts.updateQueryString = v.(string)
+	}
+	if v, ok := d.GetOk("destroy_query_string"); ok {
+		opts.destroyQueryString = v.(string)
+	}
 
 	readSearch := expandReadSearch(d.Get("read_search").(map[string]interface{}))
 	opts.readSearch = readSearch
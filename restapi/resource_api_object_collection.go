@@ -0,0 +1,312 @@
+package restapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func resourceRestAPICollection() *schema.Resource {
+	return &schema.Resource{
+		CreateContext: traced("create", collectionAddress, resourceRestAPICollectionPut),
+		ReadContext:   traced("read", collectionAddress, resourceRestAPICollectionRead),
+		UpdateContext: traced("update", collectionAddress, resourceRestAPICollectionPut),
+		DeleteContext: traced("delete", collectionAddress, resourceRestAPICollectionDelete),
+
+		Importer: &schema.ResourceImporter{
+			StateContext: resourceRestAPICollectionImport,
+		},
+
+		Schema: map[string]*schema.Schema{
+			"path": {
+				Type:        schema.TypeString,
+				Description: "The API path on top of the base URL set in the provider of a list that is only ever written as a whole. The full array of items is sent here with `update_method`, and read back with a GET.",
+				Required:    true,
+				ForceNew:    true,
+			},
+			"items": {
+				Type:        schema.TypeSet,
+				Elem:        &schema.Schema{Type: schema.TypeString, ValidateFunc: validateCollectionItem},
+				Set:         collectionItemHash,
+				Description: "The items the list holds, each given as a string of JSON. A single string holding a JSON array of all of the items may be given instead. The order of the items does not matter. Without any, the list is kept empty.",
+				Optional:    true,
+			},
+			"key_attribute": {
+				Type:        schema.TypeString,
+				Description: "Defaults to `id_attribute` set on the provider. The key (or path, as for `id_attribute`) within each item that identifies it, used to match up items with those on the server when reporting changes made there.",
+				Optional:    true,
+			},
+			"update_method": {
+				Type:         schema.TypeString,
+				Description:  "Defaults to `update_method` set on the provider. The method used to write the whole list to `path`, whether creating, updating or clearing it.",
+				Optional:     true,
+				ValidateFunc: validateHTTPMethod,
+			},
+			"delete_clears": {
+				Type:        schema.TypeBool,
+				Description: "When true (the default), destroying the resource writes an empty array to `path` with `update_method`. When false, `destroy_method` set on the provider (DELETE unless set) is sent to `path` instead.",
+				Optional:    true,
+				Default:     true,
+			},
+			"debug": {
+				Type:        schema.TypeBool,
+				Description: "Whether to emit verbose debug output while working with the list on the server.",
+				Optional:    true,
+			},
+			"api_response": {
+				Type:        schema.TypeString,
+				Description: "The raw body of the HTTP response from the last read of the list.",
+				Computed:    true,
+			},
+		}, /* End schema */
+
+	}
+}
+
+/* Create and update are the same: the whole list is replaced */
+func resourceRestAPICollectionPut(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	client := meta.(*APIClient)
+	path := d.Get("path").(string)
+
+	items, _, err := collectionItems(d.Get("items").(*schema.Set).List())
+	if err != nil {
+		return errorDiagnostics("Invalid restapi_object_collection items", err)
+	}
+	b, _ := json.Marshal(items)
+	if d.Get("debug").(bool) {
+		log.Printf("resource_api_object_collection.go: Putting %d items to '%s': %s", len(items), path, string(b))
+	}
+
+	if _, err := client.doRequestBody(collectionWriteMethod(d, client), path, bytes.NewReader(b), withContext(ctx)); err != nil {
+		return errorDiagnostics(fmt.Sprintf("Could not write the list at '%s'", path), err)
+	}
+
+	d.SetId(path)
+	return resourceRestAPICollectionRead(ctx, d, meta)
+}
+
+func resourceRestAPICollectionRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	client := meta.(*APIClient)
+	path := d.Get("path").(string)
+	debug := d.Get("debug").(bool)
+
+	resp, err := client.doRequest(client.readMethod, path, "", withContext(ctx))
+	if err != nil {
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+			log.Printf("resource_api_object_collection.go: 404 error while refreshing state for '%s'. Removing from state.", path)
+			d.SetId("")
+			return nil
+		}
+		if warnings, keep := readFailureDiagnostics(client, fmt.Sprintf("The list at '%s'", path), err); keep {
+			return warnings
+		}
+		return errorDiagnostics(fmt.Sprintf("Could not read the list at '%s'", path), err)
+	}
+
+	var actual []interface{}
+	if err := decodeJSON(resp.body, &actual); err != nil {
+		return errorDiagnostics(fmt.Sprintf("Could not read the list at '%s'", path), client.responseError(resp, fmt.Errorf("resource_api_object_collection.go: The response is not a JSON array: %s", err)))
+	}
+
+	recorded, single, err := collectionItems(d.Get("items").(*schema.Set).List())
+	if err != nil {
+		return errorDiagnostics("Invalid restapi_object_collection items", err)
+	}
+
+	keyAttribute := d.Get("key_attribute").(string)
+	if keyAttribute == "" {
+		keyAttribute = client.idAttribute
+	}
+	items, changed := collectionDelta(recorded, actual, keyAttribute, debug)
+	if changed {
+		log.Printf("resource_api_object_collection.go: The list at '%s' was changed on the server", path)
+	}
+
+	/* Keep to the form the items were given in so only real
+	   changes show up as differences */
+	var values []interface{}
+	if single {
+		b, _ := json.Marshal(items)
+		values = []interface{}{string(b)}
+	} else {
+		for _, item := range items {
+			b, _ := json.Marshal(item)
+			values = append(values, string(b))
+		}
+	}
+
+	d.Set("items", schema.NewSet(collectionItemHash, values))
+	d.Set("api_response", resp.body)
+	return nil
+}
+
+func resourceRestAPICollectionDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	client := meta.(*APIClient)
+	path := d.Get("path").(string)
+
+	var err error
+	if d.Get("delete_clears").(bool) {
+		_, err = client.sendRequest(collectionWriteMethod(d, client), path, "[]", withContext(ctx))
+	} else {
+		_, err = client.sendRequest(client.destroyMethod, path, "", withContext(ctx))
+	}
+	return errorDiagnostics(fmt.Sprintf("Could not clear the list at '%s'", path), err)
+}
+
+/* The method the whole list is written with */
+func collectionWriteMethod(d *schema.ResourceData, client *APIClient) string {
+	if method := d.Get("update_method").(string); method != "" {
+		return method
+	}
+	return client.updateMethod
+}
+
+/* The id of a collection is its path */
+func resourceRestAPICollectionImport(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	d.Set("path", d.Id())
+	d.Set("items", schema.NewSet(collectionItemHash, []interface{}{}))
+	d.Set("delete_clears", true)
+	return []*schema.ResourceData{d}, nil
+}
+
+/* Decode the configured items. If they were given as a single JSON
+   array, its elements are the items and single is true */
+func collectionItems(values []interface{}) (items []interface{}, single bool, err error) {
+	items = make([]interface{}, 0, len(values))
+	for _, value := range values {
+		var item interface{}
+		if err := decodeJSON(value.(string), &item); err != nil {
+			return nil, false, fmt.Errorf("resource_api_object_collection.go: An item is invalid JSON: %s", err)
+		}
+		if list, ok := item.([]interface{}); ok && len(values) == 1 {
+			return list, true, nil
+		}
+		items = append(items, item)
+	}
+	return items, false, nil
+}
+
+/* Match the items on the server to those recorded by key_attribute
+   (or by their content when they have none), giving back the recorded
+   items in their order with the server's changes applied and anything
+   only on the server at the end. Keys the server adds within an item
+   are not counted as a change */
+func collectionDelta(recorded []interface{}, actual []interface{}, keyAttribute string, debug bool) ([]interface{}, bool) {
+	actualByKey := make(map[string]interface{})
+	actualKeys := make([]string, 0, len(actual))
+	for _, item := range actual {
+		key := collectionItemKey(item, keyAttribute)
+		actualByKey[key] = item
+		actualKeys = append(actualKeys, key)
+	}
+
+	result := make([]interface{}, 0, len(actual))
+	seen := make(map[string]bool)
+	changed := false
+	for _, item := range recorded {
+		key := collectionItemKey(item, keyAttribute)
+		seen[key] = true
+		actualItem, ok := actualByKey[key]
+		if !ok {
+			log.Printf("resource_api_object_collection.go: Item '%s' was removed on the server", key)
+			changed = true
+			continue
+		}
+
+		value, itemChanged := collectionItemDelta(item, actualItem)
+		if itemChanged {
+			log.Printf("resource_api_object_collection.go: Item '%s' was changed on the server", key)
+			changed = true
+		} else if debug {
+			log.Printf("resource_api_object_collection.go: Item '%s' is unchanged", key)
+		}
+		result = append(result, value)
+	}
+
+	for _, key := range actualKeys {
+		if !seen[key] {
+			log.Printf("resource_api_object_collection.go: Item '%s' was added on the server", key)
+			result = append(result, actualByKey[key])
+			changed = true
+		}
+	}
+	return result, changed
+}
+
+/* Of a hash, only the keys that were recorded are compared */
+func collectionItemDelta(recorded interface{}, actual interface{}) (interface{}, bool) {
+	recordedHash, ok := recorded.(map[string]interface{})
+	actualHash, ok2 := actual.(map[string]interface{})
+	if !ok || !ok2 {
+		return actual, canonicalJSON(recorded) != canonicalJSON(actual)
+	}
+
+	result := make(map[string]interface{})
+	changed := false
+	for key, value := range recordedHash {
+		actualValue, ok := actualHash[key]
+		if !ok {
+			changed = true
+			continue
+		}
+		if canonicalJSON(value) != canonicalJSON(actualValue) {
+			changed = true
+		}
+		result[key] = actualValue
+	}
+	return result, changed
+}
+
+/* The value at key_attribute identifies an item. Items without
+   one are identified by their content */
+func collectionItemKey(item interface{}, keyAttribute string) string {
+	if hash, ok := item.(map[string]interface{}); ok && keyAttribute != "" {
+		if key, err := GetStringAtKey(hash, keyAttribute, false); err == nil {
+			return key
+		}
+	}
+	return canonicalJSON(item)
+}
+
+/* Items hash the same regardless of whitespace or key order, and
+   an array of items regardless of the order of its elements */
+func collectionItemHash(v interface{}) int {
+	var item interface{}
+	if err := decodeJSON(v.(string), &item); err != nil {
+		return schema.HashString(v.(string))
+	}
+	if list, ok := item.([]interface{}); ok {
+		elements := make([]string, 0, len(list))
+		for _, element := range list {
+			elements = append(elements, canonicalJSON(element))
+		}
+		sort.Strings(elements)
+		return schema.HashString("[" + strings.Join(elements, ",") + "]")
+	}
+	return schema.HashString(canonicalJSON(item))
+}
+
+func validateCollectionItem(val interface{}, key string) (warns []string, errs []error) {
+	var item interface{}
+	if err := decodeJSON(val.(string), &item); err != nil {
+		errs = append(errs, fmt.Errorf("%s is invalid JSON: %v", key, err))
+	}
+	return warns, errs
+}
+
+/* A single string form for a decoded JSON value. The json package
+   sorts the keys of hashes as it encodes them */
+func canonicalJSON(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
@@ -0,0 +1,208 @@
+package restapi
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+/* A list endpoint that can only be written as a whole, with
+   method (PUT unless set) */
+type testCollection struct {
+	mutex  sync.Mutex
+	body   string
+	method string
+}
+
+func (c *testCollection) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if r.URL.Path != "/api/vendors" {
+		http.NotFound(w, r)
+		return
+	}
+	method := c.method
+	if method == "" {
+		method = "PUT"
+	}
+	switch r.Method {
+	case "GET":
+	case method:
+		b, _ := ioutil.ReadAll(r.Body)
+		c.body = string(b)
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(c.body))
+}
+
+func (c *testCollection) set(body string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.body = body
+}
+
+/* Check the server holds exactly these items, in any order */
+func (c *testCollection) holds(items ...string) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		var actual []interface{}
+		if err := decodeJSON(c.body, &actual); err != nil {
+			return err
+		}
+		if len(actual) != len(items) {
+			return fmt.Errorf("resource_api_object_collection_test.go: expected %d items on the server but it has %s", len(items), c.body)
+		}
+		for _, item := range items {
+			var expected interface{}
+			decodeJSON(item, &expected)
+			found := false
+			for _, a := range actual {
+				if canonicalJSON(a) == canonicalJSON(expected) {
+					found = true
+				}
+			}
+			if !found {
+				return fmt.Errorf("resource_api_object_collection_test.go: expected %s on the server but it has %s", item, c.body)
+			}
+		}
+		return nil
+	}
+}
+
+func generateTestCollection(items ...string) string {
+	config := ""
+	for _, item := range items {
+		config += fmt.Sprintf("    %q,\n", item)
+	}
+	return fmt.Sprintf(`
+resource "restapi_object_collection" "Vendors" {
+  path          = "/api/vendors"
+  key_attribute = "name"
+  items = [
+%s  ]
+}
+`, config)
+}
+
+func TestAccRestApiObjectCollection(t *testing.T) {
+	collection := &testCollection{body: "[]"}
+	svr := httptest.NewServer(collection)
+	defer svr.Close()
+	os.Setenv("REST_API_URI", svr.URL)
+
+	a := `{"name": "a", "enabled": true}`
+	b := `{"name": "b", "enabled": false}`
+	c := `{"name": "c", "enabled": true}`
+
+	resource.UnitTest(t, resource.TestCase{
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		CheckDestroy: func(s *terraform.State) error {
+			return collection.holds()(s)
+		},
+		Steps: []resource.TestStep{
+			{
+				Config: generateTestCollection(a, b),
+				Check: resource.ComposeTestCheckFunc(
+					collection.holds(a, b),
+					resource.TestCheckResourceAttr("restapi_object_collection.Vendors", "id", "/api/vendors"),
+				),
+			},
+			/* Adding an item */
+			{
+				Config: generateTestCollection(a, b, c),
+				Check:  collection.holds(a, b, c),
+			},
+			/* Removing an item */
+			{
+				Config: generateTestCollection(a, c),
+				Check:  collection.holds(a, c),
+			},
+			/* Reordering (or reformatting) items is not a change */
+			{
+				Config:   generateTestCollection(`{"enabled":true,"name":"c"}`, a),
+				PlanOnly: true,
+			},
+			/* Nor is giving them as a single array */
+			{
+				Config: generateTestCollection(fmt.Sprintf("[%s, %s]", c, a)),
+				Check:  collection.holds(a, c),
+			},
+			/* Changes made on the server are put right */
+			{
+				PreConfig: func() {
+					collection.set(`[{"name": "a", "enabled": false, "added_by_server": 1}, {"name": "c", "enabled": true}, {"name": "z", "enabled": true}]`)
+				},
+				Config: generateTestCollection(fmt.Sprintf("[%s, %s]", c, a)),
+				Check:  collection.holds(a, c),
+			},
+		},
+	})
+}
+
+func TestAccRestApiObjectCollectionUpdateMethod(t *testing.T) {
+	collection := &testCollection{body: "[]", method: "PATCH"}
+	svr := httptest.NewServer(collection)
+	defer svr.Close()
+	os.Setenv("REST_API_URI", svr.URL)
+
+	config := func(items string) string {
+		return fmt.Sprintf(`
+resource "restapi_object_collection" "Vendors" {
+  path          = "/api/vendors"
+  key_attribute = "name"
+  update_method = "PATCH"
+  items         = [%q]
+}
+`, items)
+	}
+	a := `{"name": "a", "enabled": true}`
+	b := `{"name": "b", "enabled": false}`
+
+	resource.UnitTest(t, resource.TestCase{
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		/* Clearing the list on destroy uses the same method */
+		CheckDestroy: func(s *terraform.State) error {
+			return collection.holds()(s)
+		},
+		Steps: []resource.TestStep{
+			{
+				Config: config(fmt.Sprintf("[%s]", a)),
+				Check:  collection.holds(a),
+			},
+			{
+				Config: config(fmt.Sprintf("[%s, %s]", a, b)),
+				Check:  collection.holds(a, b),
+			},
+		},
+	})
+}
+
+func TestCollectionDelta(t *testing.T) {
+	var recorded, actual []interface{}
+	decodeJSON(`[{"name": "a", "v": 1}, {"name": "b", "v": 2}, "plain"]`, &recorded)
+	decodeJSON(`[{"name": "b", "v": 2, "extra": true}, "plain", {"name": "a", "v": 1}]`, &actual)
+
+	/* Order and keys only the server has do not matter */
+	items, changed := collectionDelta(recorded, actual, "name", false)
+	if changed || canonicalJSON(items) != canonicalJSON(recorded) {
+		t.Errorf("resource_api_object_collection_test.go: expected no change but got %s (changed: %t)", canonicalJSON(items), changed)
+	}
+
+	decodeJSON(`[{"name": "a", "v": 3}, {"name": "c", "v": 4}]`, &actual)
+	items, changed = collectionDelta(recorded, actual, "name", false)
+	expected := `[{"name":"a","v":3},{"name":"c","v":4}]`
+	if !changed || canonicalJSON(items) != expected {
+		t.Errorf("resource_api_object_collection_test.go: expected %s but got %s (changed: %t)", expected, canonicalJSON(items), changed)
+	}
+}
@@ -12,13 +12,25 @@ package restapi
   "github.com/hashicorp/terraform/config"
 */
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/Mastercard/terraform-provider-restapi/fakeserver"
-	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
 )
 
 // example.Widget represents a concrete Go type that represents an API resource
@@ -48,8 +60,8 @@ func TestAccRestApiObject_Basic(t *testing.T) {
 	}
 
 	resource.UnitTest(t, resource.TestCase{
-		Providers: testAccProviders,
-		PreCheck:  func() { svr.StartInBackground() },
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		PreCheck:                 func() { svr.StartInBackground() },
 		Steps: []resource.TestStep{
 			{
 				Config: generateTestResource(
@@ -82,6 +94,15 @@ func TestAccRestApiObject_Basic(t *testing.T) {
 					resource.TestCheckResourceAttr("restapi_object.Foo", "create_response", "{\"first\":\"Foo\",\"id\":\"1234\",\"last\":\"Bar\"}"),
 				),
 			},
+			/* Reformatting the data is not a change */
+			{
+				Config: generateTestResource(
+					"Foo",
+					`{"last":"Value",  "id":"1234","first":"Updated"}`,
+					make(map[string]interface{}),
+				),
+				PlanOnly: true,
+			},
 			/* Make a complex object with id_attribute as a child of another key
 			   Note that we have to pass "id" just so fakeserver won't get angry at us
 			*/
@@ -106,6 +127,400 @@ func TestAccRestApiObject_Basic(t *testing.T) {
 	svr.Shutdown()
 }
 
+func TestAccRestApiObject_ForceNewKeys(t *testing.T) {
+	svr := fakeserver.NewFakeServer(8094, make(map[string]map[string]interface{}), false, false, "")
+	os.Setenv("REST_API_URI", "http://127.0.0.1:8094")
+
+	config := func(data string) string {
+		strData, _ := json.Marshal(data)
+		return fmt.Sprintf(`
+resource "restapi_object" "Foo" {
+  path           = "/api/objects"
+  data           = %s
+  force_new_keys = ["kind", "tags/0"]
+}
+`, strData)
+	}
+
+	/* Count the objects destroyed so far to tell a
+	   replacement apart from an update */
+	deletes := func(expected int) resource.TestCheckFunc {
+		return func(s *terraform.State) error {
+			count := 0
+			for _, r := range svr.Requests() {
+				if r.Method == "DELETE" {
+					count++
+				}
+			}
+			if count != expected {
+				return fmt.Errorf("resource_api_object_test.go: expected %d DELETE requests but got %d", expected, count)
+			}
+			return nil
+		}
+	}
+
+	resource.UnitTest(t, resource.TestCase{
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		PreCheck:                 func() { svr.StartInBackground() },
+		Steps: []resource.TestStep{
+			{
+				Config: config(`{ "id": "77", "kind": "a", "name": "one", "tags": ["x", "y"] }`),
+				Check:  deletes(0),
+			},
+			/* Other keys are updated in place */
+			{
+				Config: config(`{ "id": "77", "kind": "a", "name": "two", "tags": ["x", "z"] }`),
+				Check: resource.ComposeTestCheckFunc(
+					deletes(0),
+					resource.TestCheckResourceAttr("restapi_object.Foo", "api_data.name", "two"),
+				),
+			},
+			/* A listed key has the object replaced */
+			{
+				Config: config(`{ "id": "77", "kind": "a", "name": "two", "tags": ["w", "z"] }`),
+				Check: resource.ComposeTestCheckFunc(
+					deletes(1),
+					resource.TestCheckResourceAttr("restapi_object.Foo", "id", "77"),
+				),
+			},
+		},
+	})
+
+	svr.Shutdown()
+}
+
+func TestAccRestApiObject_IgnoreServerChanges(t *testing.T) {
+	/* Stores objects as sent, but adds updated_at whenever one is read */
+	var mutex sync.Mutex
+	objects := make(map[string]map[string]interface{})
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		id := strings.TrimPrefix(r.URL.Path, "/api/objects/")
+		switch r.Method {
+		case "POST", "PUT":
+			obj := make(map[string]interface{})
+			json.NewDecoder(r.Body).Decode(&obj)
+			id = fmt.Sprintf("%v", obj["id"])
+			objects[id] = obj
+		case "DELETE":
+			delete(objects, id)
+			return
+		}
+		obj, ok := objects[id]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		obj["updated_at"] = time.Now().Format(time.RFC3339Nano)
+		json.NewEncoder(w).Encode(obj)
+	}))
+	defer svr.Close()
+	os.Setenv("REST_API_URI", svr.URL)
+
+	data := `{ "id": "55", "name": "watched" }`
+	resource.UnitTest(t, resource.TestCase{
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			/* With the key ignored, the plan is clean */
+			{
+				Config: fmt.Sprintf(`
+resource "restapi_object" "Foo" {
+  path        = "/api/objects"
+  data        = %q
+  ignore_keys = ["updated_at"]
+}
+`, data),
+				Check: resource.TestCheckResourceAttrSet("restapi_object.Foo", "api_data.updated_at"),
+			},
+			/* Without, the server's change is a difference */
+			{
+				Config:             generateTestResource("Foo", data, make(map[string]interface{})),
+				ExpectNonEmptyPlan: true,
+			},
+			/* Unless all changes on the server are ignored */
+			{
+				Config: generateTestResource("Foo", data, map[string]interface{}{
+					"ignore_all_server_changes": true,
+				}),
+			},
+		},
+	})
+}
+
+func TestAccRestApiObject_Extract(t *testing.T) {
+	svr := fakeserver.NewFakeServer(8095, make(map[string]map[string]interface{}), false, false, "")
+	os.Setenv("REST_API_URI", "http://127.0.0.1:8095")
+
+	resource.UnitTest(t, resource.TestCase{
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		PreCheck:                 func() { svr.StartInBackground() },
+		Steps: []resource.TestStep{
+			{
+				Config: `
+resource "restapi_object" "Foo" {
+  path = "/api/objects"
+  data = "{ \"id\": \"88\", \"network\": { \"ips\": [\"10.0.0.1\", \"10.0.0.2\"] } }"
+  extract = {
+    second_ip = "network/ips/1"
+    missing   = "network/gateway"
+  }
+}
+`,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("restapi_object.Foo", "extracted.second_ip", "10.0.0.2"),
+					resource.TestCheckResourceAttr("restapi_object.Foo", "extracted.missing", ""),
+				),
+			},
+		},
+	})
+
+	svr.Shutdown()
+}
+
+func TestResourceRestAPIDiagnostics(t *testing.T) {
+	svr := fakeserver.NewFakeServer(0, make(map[string]map[string]interface{}), false, false, "")
+	httpSvr := httptest.NewServer(svr.Handler())
+	defer httpSvr.Close()
+
+	client, err := NewAPIClient(&apiClientOpt{
+		uri:         httpSvr.URL,
+		timeout:     2,
+		idAttribute: "id",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	/* A path in extract that is not in the object is only a warning */
+	d := schema.TestResourceDataRaw(t, resourceRestAPI().Schema, map[string]interface{}{
+		"path":    "/api/objects",
+		"data":    `{ "id": "96", "name": "Foo" }`,
+		"extract": map[string]interface{}{"gateway": "network/gateway"},
+	})
+	diags := resourceRestAPICreate(context.Background(), d, client)
+	if diags.HasError() {
+		t.Fatalf("resource_api_object_test.go: Expected the create to succeed but got %v", diags)
+	}
+	if len(diags) != 1 || diags[0].Severity != diag.Warning || !strings.Contains(diags[0].Summary, "gateway") {
+		t.Fatalf("resource_api_object_test.go: Expected a single warning about 'gateway' but got %v", diags)
+	}
+	if !diags[0].AttributePath.Equals(cty.GetAttrPath("extract").IndexString("gateway")) {
+		t.Errorf("resource_api_object_test.go: Expected the warning to point at extract.gateway but got %#v", diags[0].AttributePath)
+	}
+
+	/* Errors from the API carry the status code in their detail */
+	d = schema.TestResourceDataRaw(t, resourceRestAPI().Schema, map[string]interface{}{
+		"path": "/api/objects",
+		"data": `{ "id": "404", "name": "Foo" }`,
+	})
+	d.SetId("404")
+	diags = resourceRestAPIUpdate(context.Background(), d, client)
+	if !diags.HasError() {
+		t.Fatalf("resource_api_object_test.go: Expected updating a missing object to fail")
+	}
+	if !strings.Contains(diags[0].Detail, "Status code: 404") {
+		t.Errorf("resource_api_object_test.go: Expected the status code in the detail but got '%s'", diags[0].Detail)
+	}
+
+	/* As do failures the server is made to return */
+	svr.FailNext("POST", "/api/objects", http.StatusServiceUnavailable, 1)
+	d = schema.TestResourceDataRaw(t, resourceRestAPI().Schema, map[string]interface{}{
+		"path": "/api/objects",
+		"data": `{ "id": "503", "name": "Foo" }`,
+	})
+	diags = resourceRestAPICreate(context.Background(), d, client)
+	if !diags.HasError() || !strings.Contains(diags[0].Detail, "Status code: 503") {
+		t.Fatalf("resource_api_object_test.go: Expected the injected 503 to fail the create but got %v", diags)
+	}
+	diags = resourceRestAPICreate(context.Background(), d, client)
+	if diags.HasError() {
+		t.Errorf("resource_api_object_test.go: Expected only the first create to fail but got %v", diags)
+	}
+}
+
+func TestResourceRestAPIReadStatus(t *testing.T) {
+	svr := fakeserver.NewFakeServer(0, map[string]map[string]interface{}{
+		"1": {"id": "1", "name": "Foo"},
+	}, false, false, "")
+	client, err := NewAPIClient(&apiClientOpt{
+		uri:       "http://fakeserver.invalid",
+		timeout:   2,
+		transport: handlerTransport{svr.Handler()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	/* Only a 404 takes the object out of state */
+	cases := []struct {
+		status   int
+		summary  string
+		inState  bool
+		hasError bool
+	}{
+		{status: http.StatusOK, inState: true},
+		{status: http.StatusForbidden, summary: "Not permitted to read the object '1' - it was NOT removed from state", inState: true, hasError: true},
+		{status: http.StatusNotFound, inState: false},
+		{status: http.StatusInternalServerError, summary: "Could not read the object '1'", inState: true, hasError: true},
+	}
+	for _, c := range cases {
+		if c.status != http.StatusOK {
+			svr.FailNext("GET", "/api/objects/1", c.status, 1)
+		}
+		d := schema.TestResourceDataRaw(t, resourceRestAPI().Schema, map[string]interface{}{
+			"path": "/api/objects",
+			"data": `{ "id": "1", "name": "Foo" }`,
+		})
+		d.SetId("1")
+
+		diags := resourceRestAPIRead(context.Background(), d, client)
+		if diags.HasError() != c.hasError || (c.hasError && diags[0].Summary != c.summary) {
+			t.Errorf("resource_api_object_test.go: %d: expected error %t (%s) but got %v", c.status, c.hasError, c.summary, diags)
+		}
+		if inState := d.Id() != ""; inState != c.inState {
+			t.Errorf("resource_api_object_test.go: %d: expected the object to be in state %t but it was %t", c.status, c.inState, inState)
+		}
+
+		/* Deleting is the same - only a 404 counts as already gone */
+		if c.status != http.StatusOK {
+			d.SetId("1")
+			svr.FailNext("DELETE", "/api/objects/1", c.status, 1)
+			if diags := resourceRestAPIDelete(context.Background(), d, client); diags.HasError() == (c.status == http.StatusNotFound) {
+				t.Errorf("resource_api_object_test.go: %d: unexpected result deleting: %v", c.status, diags)
+			}
+		}
+	}
+}
+
+func TestResourceRestAPIReadFailureMode(t *testing.T) {
+	svr := fakeserver.NewFakeServer(0, map[string]map[string]interface{}{
+		"1": {"id": "1", "name": "Changed on the server"},
+	}, false, false, "")
+
+	/* Nothing listens here, so requests to it get no response */
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := "http://" + l.Addr().String()
+	l.Close()
+
+	const (
+		refreshed = "refreshed"
+		kept      = "kept"
+		removed   = "removed"
+		failed    = "failed"
+	)
+	cases := []struct {
+		name    string
+		rule    *fakeserver.Rule
+		opt     func(opt *apiClientOpt)
+		prepare func(client *APIClient)
+		error   string
+		warn    string
+	}{
+		{name: "200", error: refreshed, warn: refreshed},
+		{name: "404", rule: &fakeserver.Rule{Status: http.StatusNotFound}, error: removed, warn: removed},
+		{name: "400", rule: &fakeserver.Rule{Status: http.StatusBadRequest}, error: failed, warn: failed},
+		{name: "403", rule: &fakeserver.Rule{Status: http.StatusForbidden}, error: failed, warn: failed},
+		{name: "503", rule: &fakeserver.Rule{Status: http.StatusServiceUnavailable}, error: failed, warn: kept},
+		{name: "transport", rule: &fakeserver.Rule{DropConnection: true}, error: failed, warn: kept},
+		{
+			name: "connection_refused",
+			opt: func(opt *apiClientOpt) {
+				opt.uri = closed
+				opt.transport = nil
+			},
+			error: failed,
+			warn:  kept,
+		},
+		{
+			/* The request is never sent, so this says nothing about the API */
+			name: "oauth_failure",
+			opt: func(opt *apiClientOpt) {
+				opt.oauthClientID = "id"
+				opt.oauthClientSecret = "secret"
+				opt.oauthTokenURL = "http://fakeserver.invalid/no/token/here"
+			},
+			error: failed,
+			warn:  failed,
+		},
+		{
+			name: "circuit_open",
+			opt: func(opt *apiClientOpt) {
+				opt.circuitThreshold = 1
+				opt.circuitWindow = 60
+				opt.circuitCooldown = 60
+			},
+			prepare: func(client *APIClient) {
+				client.circuitBreaker.failure(errors.New("connection refused"))
+			},
+			error: failed,
+			warn:  failed,
+		},
+	}
+
+	for _, mode := range []string{readFailureError, readFailureWarnKeepState} {
+		for _, c := range cases {
+			opt := &apiClientOpt{
+				uri:             "http://fakeserver.invalid",
+				timeout:         2,
+				readFailureMode: mode,
+				transport:       handlerTransport{svr.Handler()},
+			}
+			if c.opt != nil {
+				c.opt(opt)
+			}
+			client, err := NewAPIClient(opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.prepare != nil {
+				c.prepare(client)
+			}
+
+			svr.ResetRules()
+			if c.rule != nil {
+				c.rule.Method, c.rule.Path = "GET", "/api/objects/1"
+				svr.AddRule(c.rule)
+			}
+			d := schema.TestResourceDataRaw(t, resourceRestAPI().Schema, map[string]interface{}{
+				"path": "/api/objects",
+				"data": `{ "id": "1", "name": "Foo" }`,
+			})
+			d.SetId("1")
+
+			diags := resourceRestAPIRead(context.Background(), d, client)
+			var got string
+			switch {
+			case diags.HasError():
+				got = failed
+			case d.Id() == "":
+				got = removed
+			case len(diags) == 1 && diags[0].Severity == diag.Warning && diags[0].Summary == "The object '1' was NOT refreshed":
+				got = kept
+				if !jsonEquivalent(d.Get("data").(string), `{ "id": "1", "name": "Foo" }`) {
+					t.Errorf("resource_api_object_test.go: %s %s: expected the data to be kept but it is %s", mode, c.name, d.Get("data"))
+				}
+			case len(diags) == 0 && strings.Contains(d.Get("data").(string), "Changed on the server"):
+				got = refreshed
+			default:
+				got = fmt.Sprintf("%v", diags)
+			}
+
+			expected := c.error
+			if mode == readFailureWarnKeepState {
+				expected = c.warn
+			}
+			if got != expected {
+				t.Errorf("resource_api_object_test.go: %s %s: expected the object to be %s but it was %s", mode, c.name, expected, got)
+			}
+		}
+	}
+	svr.ResetRules()
+}
+
 /* This function generates a terraform JSON configuration from
    a name, JSON data and a list of params to set by coaxing it
    all to maps and then serializing to JSON */
@@ -0,0 +1,114 @@
+package restapi
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+/* The tflog field naming the resource a request was made for */
+const resourceTraceField = "restapi_resource"
+
+/*resourceTrace collects the requests made for one operation on one
+  resource, so they can be picked out of the logs of a busy run.
+  Terraform does not tell providers the address of a resource, so it is
+  named by its type, path and id instead */
+type resourceTrace struct {
+	address string
+
+	mutex sync.Mutex
+	calls []tracedCall
+}
+
+type tracedCall struct {
+	method   string
+	path     string
+	status   int
+	duration time.Duration
+}
+
+type resourceTraceKey struct{}
+
+/* Tag ctx with the resource its requests are made for. Log entries made
+   with the context carry the resource as a field */
+func withResourceTrace(ctx context.Context, address string) (context.Context, *resourceTrace) {
+	trace := &resourceTrace{address: address}
+	ctx = context.WithValue(ctx, resourceTraceKey{}, trace)
+	return tflog.SetField(ctx, resourceTraceField, address), trace
+}
+
+/* The trace ctx was tagged with, if any */
+func resourceTraceFrom(ctx context.Context) *resourceTrace {
+	if ctx == nil {
+		return nil
+	}
+	trace, _ := ctx.Value(resourceTraceKey{}).(*resourceTrace)
+	return trace
+}
+
+func (trace *resourceTrace) record(method string, path string, status int, duration time.Duration) {
+	trace.mutex.Lock()
+	defer trace.mutex.Unlock()
+	trace.calls = append(trace.calls, tracedCall{method: method, path: path, status: status, duration: duration})
+}
+
+/* Note a request against the trace ctx was tagged with, if any */
+func recordTrace(ctx context.Context, method string, path string, status int, duration time.Duration) {
+	if trace := resourceTraceFrom(ctx); trace != nil {
+		trace.record(method, path, status, duration)
+	}
+}
+
+/* One line listing the requests made, in the order they were made.
+   A status of 0 is a request that got no response */
+func (trace *resourceTrace) summary() string {
+	trace.mutex.Lock()
+	defer trace.mutex.Unlock()
+
+	if len(trace.calls) == 0 {
+		return "no requests"
+	}
+	calls := make([]string, len(trace.calls))
+	for i, call := range trace.calls {
+		calls[i] = fmt.Sprintf("%s %s (%d, %s)", call.method, call.path, call.status, call.duration.Round(time.Millisecond))
+	}
+	return fmt.Sprintf("%d requests: %s", len(trace.calls), strings.Join(calls, ", "))
+}
+
+/* Wrap a CRUD function so the requests it makes are tagged with the
+   resource, and listed at TRACE once it returns */
+func traced(operation string, address func(*schema.ResourceData) string, fn func(context.Context, *schema.ResourceData, interface{}) diag.Diagnostics) func(context.Context, *schema.ResourceData, interface{}) diag.Diagnostics {
+	return func(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+		ctx, trace := withResourceTrace(ctx, address(d))
+		diags := fn(ctx, d, meta)
+		tflog.Trace(ctx, fmt.Sprintf("resource_trace.go: %s of %s made %s", operation, trace.address, trace.summary()))
+		return diags
+	}
+}
+
+/* How each kind of resource is named in traces */
+func objectAddress(resourceType string) func(*schema.ResourceData) string {
+	return func(d *schema.ResourceData) string {
+		if d.Id() == "" {
+			return fmt.Sprintf("%s %s (new)", resourceType, d.Get("path"))
+		}
+		return fmt.Sprintf("%s %s/%s", resourceType, d.Get("path"), d.Id())
+	}
+}
+
+func collectionAddress(d *schema.ResourceData) string {
+	return fmt.Sprintf("restapi_object_collection %s", d.Get("path"))
+}
+
+func searchAddress(d *schema.ResourceData) string {
+	if d.Get("search_key") == "" {
+		return fmt.Sprintf("data.restapi_object %s", d.Get("path"))
+	}
+	return fmt.Sprintf("data.restapi_object %s?%s=%s", d.Get("path"), d.Get("search_key"), d.Get("search_value"))
+}
@@ -0,0 +1,138 @@
+package restapi
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Mastercard/terraform-provider-restapi/fakeserver"
+	"github.com/hashicorp/terraform-plugin-log/tflogtest"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+/* The messages logged through tflog with the resource field, and the value of the field */
+func tracedEntries(t *testing.T, output *bytes.Buffer) map[string]string {
+	entries, err := tflogtest.MultilineJSONDecode(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tagged := make(map[string]string)
+	for _, entry := range entries {
+		if address, ok := entry[resourceTraceField].(string); ok {
+			tagged[entry["@message"].(string)] = address
+		}
+	}
+	return tagged
+}
+
+func TestResourceTrace(t *testing.T) {
+	debug := false
+	apiServerObjects := make(map[string]map[string]interface{})
+	for i := 1; i <= 5; i++ {
+		id := fmt.Sprintf("%d", i)
+		apiServerObjects[id] = map[string]interface{}{"id": id, "name": "object" + id}
+	}
+	svr := fakeserver.NewFakeServer(0, apiServerObjects, false, debug, "")
+	svr.SetAuth(fakeserver.Auth{ClientID: "id", ClientSecret: "secret"})
+	svr.SetPageSize(2)
+
+	client, err := NewAPIClient(&apiClientOpt{
+		uri:               "http://fakeserver.invalid",
+		timeout:           2,
+		oauthClientID:     "id",
+		oauthClientSecret: "secret",
+		oauthTokenURL:     "http://fakeserver.invalid/oauth/token",
+		transport:         handlerTransport{svr.Handler()},
+		debug:             debug,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var output bytes.Buffer
+	ctx, trace := withResourceTrace(tflogtest.RootLogger(context.Background(), &output), "restapi_object /api/objects/1")
+
+	/* The retry after a rejected token is made with the same context */
+	if _, err := client.sendRequest("GET", "/api/objects/1", "", withContext(ctx)); err != nil {
+		t.Fatal(err)
+	}
+	svr.RevokeTokens()
+	if _, err := client.sendRequest("GET", "/api/objects/1", "", withContext(ctx)); err != nil {
+		t.Fatal(err)
+	}
+
+	/* As is every page of a list that is searched */
+	obj, err := NewAPIObject(client, &apiObjectOpts{path: "/api/objects", debug: debug})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := obj.findObject(ctx, "", "name", "object5", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	/* Requests made without the context are not part of the trace */
+	if _, err := client.sendRequest("GET", "/api/objects/2", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	var calls []string
+	for _, call := range trace.calls {
+		calls = append(calls, fmt.Sprintf("%s %s (%d)", call.method, call.path, call.status))
+	}
+	expected := "GET /api/objects/1 (200), GET /api/objects/1 (401), GET /api/objects/1 (200), GET /api/objects (200), GET /api/objects?page=2 (200), GET /api/objects?page=3 (200)"
+	if strings.Join(calls, ", ") != expected {
+		t.Errorf("resource_trace_test.go: expected the trace\n%s\nbut got\n%s", expected, trace.summary())
+	}
+
+	tagged := tracedEntries(t, &output)
+	timings := 0
+	for message, address := range tagged {
+		if strings.Contains(message, " timing: ") {
+			timings++
+		}
+		if address != "restapi_object /api/objects/1" {
+			t.Errorf("resource_trace_test.go: '%s' was logged for '%s'", message, address)
+		}
+	}
+	if timings != 6 {
+		t.Errorf("resource_trace_test.go: expected each of the 6 requests to be logged with the resource but got %v", tagged)
+	}
+}
+
+func TestTraced(t *testing.T) {
+	svr := fakeserver.NewFakeServer(0, map[string]map[string]interface{}{"1": {"id": "1"}}, false, false, "")
+	client, err := NewAPIClient(&apiClientOpt{
+		uri:       "http://fakeserver.invalid",
+		timeout:   2,
+		transport: handlerTransport{svr.Handler()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read := traced("read", objectAddress("restapi_object"), func(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+		_, err := meta.(*APIClient).sendRequest("GET", "/api/objects/1", "", withContext(ctx))
+		return diag.FromErr(err)
+	})
+
+	d := schema.TestResourceDataRaw(t, resourceRestAPI().Schema, map[string]interface{}{"path": "/api/objects", "data": `{"id": "1"}`})
+	d.SetId("1")
+
+	var output bytes.Buffer
+	if diags := read(tflogtest.RootLogger(context.Background(), &output), d, client); diags.HasError() {
+		t.Fatalf("resource_trace_test.go: %v", diags)
+	}
+
+	found := false
+	for message, address := range tracedEntries(t, &output) {
+		if strings.HasPrefix(message, "resource_trace.go: read of restapi_object /api/objects/1 made 1 requests: GET /api/objects/1 (200, ") {
+			found = address == "restapi_object /api/objects/1"
+		}
+	}
+	if !found {
+		t.Errorf("resource_trace_test.go: expected a summary of the read tagged with the resource")
+	}
+}
@@ -0,0 +1,122 @@
+package restapi
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+)
+
+/* Objects left behind by failed acceptance runs are recognised by the
+   value at their sweep key starting with this */
+const sweepPrefix = "tftest"
+
+/*
+  Run with, for example:
+    go test ./restapi -v -sweep=https://api.example.com
+
+  The value of -sweep is the uri of the API (REST_API_URI is used for
+  "default"). REST_API_SWEEP_PATH (default /api/objects) and
+  REST_API_SWEEP_KEY (default name) say where to list objects and which
+  key to match the prefix against. REST_API_RATE_LIMIT is honoured.
+*/
+func init() {
+	resource.AddTestSweepers("restapi_object", &resource.Sweeper{
+		Name: "restapi_object",
+		F:    sweepObjects,
+	})
+}
+
+func sweepObjects(uri string) error {
+	if uri == "" || uri == "default" {
+		uri = os.Getenv("REST_API_URI")
+	}
+	path := GetEnvOrDefault("REST_API_SWEEP_PATH", "/api/objects")
+	key := GetEnvOrDefault("REST_API_SWEEP_KEY", "name")
+	idAttribute := GetEnvOrDefault("REST_API_ID_ATTRIBUTE", "id")
+
+	rateLimit := math.MaxFloat64
+	if v := os.Getenv("REST_API_RATE_LIMIT"); v != "" {
+		var err error
+		if rateLimit, err = strconv.ParseFloat(v, 64); err != nil {
+			return fmt.Errorf("sweeper_test.go: Invalid REST_API_RATE_LIMIT '%s': %s", v, err)
+		}
+	}
+
+	client, err := NewAPIClient(&apiClientOpt{
+		uri:         uri,
+		timeout:     30,
+		idAttribute: idAttribute,
+		rateLimit:   rateLimit,
+	})
+	if err != nil {
+		return err
+	}
+
+	objects, err := client.getPaginated(path, os.Getenv("REST_API_SWEEP_RESULTS_KEY"))
+	if err != nil {
+		return fmt.Errorf("sweeper_test.go: Could not list objects at '%s': %s", path, err)
+	}
+
+	for _, id := range sweepableIDs(objects, key, idAttribute, sweepPrefix) {
+		log.Printf("[INFO] sweeper_test.go: Deleting %s/%s", path, id)
+		if _, err := client.sendRequest(client.destroyMethod, strings.TrimSuffix(path, "/")+"/"+id, ""); err != nil {
+			return fmt.Errorf("sweeper_test.go: Could not delete %s/%s: %s", path, id, err)
+		}
+	}
+	return nil
+}
+
+/* The ids of the objects whose value at key starts with prefix */
+func sweepableIDs(objects []interface{}, key string, idAttribute string, prefix string) []string {
+	ids := make([]string, 0)
+	for _, object := range objects {
+		hash, ok := object.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		value, err := GetStringAtKey(hash, key, false)
+		if err != nil || !strings.HasPrefix(value, prefix) {
+			continue
+		}
+		id, err := GetStringAtKey(hash, idAttribute, false)
+		if err != nil || id == "" {
+			log.Printf("[WARN] sweeper_test.go: Not deleting '%s' as it has no '%s'", value, idAttribute)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func TestSweepableIDs(t *testing.T) {
+	var objects []interface{}
+	decodeJSON(`[
+    { "id": "1", "name": "tftest-foo" },
+    { "id": "2", "name": "production" },
+    { "id": 3, "name": "tftest-bar" },
+    { "id": "4", "name": "not-tftest" },
+    { "name": "tftest-no-id" },
+    { "id": "6" },
+    "not an object"
+  ]`, &objects)
+
+	ids := sweepableIDs(objects, "name", "id", sweepPrefix)
+	if strings.Join(ids, ",") != "1,3" {
+		t.Errorf("sweeper_test.go: Expected to sweep 1 and 3 but got %v", ids)
+	}
+
+	decodeJSON(`[
+    { "meta": { "uuid": "a", "labels": { "owner": "tftest-ci" } } },
+    { "meta": { "uuid": "b", "labels": { "owner": "team" } } }
+  ]`, &objects)
+	ids = sweepableIDs(objects, "meta/labels/owner", "meta/uuid", sweepPrefix)
+	if strings.Join(ids, ",") != "a" {
+		t.Errorf("sweeper_test.go: Expected to sweep only a but got %v", ids)
+	}
+}
@@ -12,11 +12,17 @@ export GOARCH=""
 cd ../restapi
 
 echo "Running tests..."
-if ! go test "$@";then
+if ! TF_ACC=1 go test "$@";then
   echo "Failed testing. Aborting."
   exit 1
 fi
 
+echo "Running concurrency tests with the race detector..."
+if ! go test -race -run 'Concurrent' .;then
+  echo "Failed race testing. Aborting."
+  exit 1
+fi
+
 #echo "Vetting result..."
 #go vet ./...
 